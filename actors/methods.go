@@ -0,0 +1,72 @@
+// Package actors provides version agnostic information about the builtin actors.
+package actors
+
+import (
+	"reflect"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa2builtin "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	"github.com/ipfs/go-cid"
+)
+
+// methodNames holds the names of the methods exported by each builtin actor, keyed by actor code. Actor codes are
+// distinct for each version of specs-actors so the version is implied by the code.
+var methodNames = map[cid.Cid]map[abi.MethodNum]string{}
+
+func init() {
+	registerMethods(sa0builtin.AccountActorCodeID, sa0builtin.MethodsAccount)
+	registerMethods(sa0builtin.InitActorCodeID, sa0builtin.MethodsInit)
+	registerMethods(sa0builtin.CronActorCodeID, sa0builtin.MethodsCron)
+	registerMethods(sa0builtin.RewardActorCodeID, sa0builtin.MethodsReward)
+	registerMethods(sa0builtin.MultisigActorCodeID, sa0builtin.MethodsMultisig)
+	registerMethods(sa0builtin.PaymentChannelActorCodeID, sa0builtin.MethodsPaych)
+	registerMethods(sa0builtin.StorageMarketActorCodeID, sa0builtin.MethodsMarket)
+	registerMethods(sa0builtin.StoragePowerActorCodeID, sa0builtin.MethodsPower)
+	registerMethods(sa0builtin.StorageMinerActorCodeID, sa0builtin.MethodsMiner)
+	registerMethods(sa0builtin.VerifiedRegistryActorCodeID, sa0builtin.MethodsVerifiedRegistry)
+
+	registerMethods(sa2builtin.AccountActorCodeID, sa2builtin.MethodsAccount)
+	registerMethods(sa2builtin.InitActorCodeID, sa2builtin.MethodsInit)
+	registerMethods(sa2builtin.CronActorCodeID, sa2builtin.MethodsCron)
+	registerMethods(sa2builtin.RewardActorCodeID, sa2builtin.MethodsReward)
+	registerMethods(sa2builtin.MultisigActorCodeID, sa2builtin.MethodsMultisig)
+	registerMethods(sa2builtin.PaymentChannelActorCodeID, sa2builtin.MethodsPaych)
+	registerMethods(sa2builtin.StorageMarketActorCodeID, sa2builtin.MethodsMarket)
+	registerMethods(sa2builtin.StoragePowerActorCodeID, sa2builtin.MethodsPower)
+	registerMethods(sa2builtin.StorageMinerActorCodeID, sa2builtin.MethodsMiner)
+	registerMethods(sa2builtin.VerifiedRegistryActorCodeID, sa2builtin.MethodsVerifiedRegistry)
+}
+
+// registerMethods records the method names of an actor from a specs-actors method table, which is a struct with
+// one abi.MethodNum field per method.
+func registerMethods(code cid.Cid, table interface{}) {
+	names := map[abi.MethodNum]string{
+		sa0builtin.MethodSend: "Send",
+	}
+
+	v := reflect.ValueOf(table)
+	for i := 0; i < v.NumField(); i++ {
+		names[abi.MethodNum(v.Field(i).Uint())] = v.Type().Field(i).Name
+	}
+
+	methodNames[code] = names
+}
+
+// MethodName returns the name of a method of the actor with the given code. Method zero is always named Send since
+// it is a plain value transfer to any actor. An empty string is returned for methods that are not known.
+func MethodName(code cid.Cid, method abi.MethodNum) string {
+	if method == sa0builtin.MethodSend {
+		return "Send"
+	}
+	return methodNames[code][method]
+}
+
+// ActorNameByCode returns the name of the actor code. Agnostic to the
+// version of specs-actors.
+func ActorNameByCode(code cid.Cid) string {
+	if name := sa0builtin.ActorNameByCode(code); name != "<unknown>" {
+		return name
+	}
+	return sa2builtin.ActorNameByCode(code)
+}
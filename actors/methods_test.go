@@ -0,0 +1,35 @@
+package actors
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa2builtin "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		code   cid.Cid
+		method abi.MethodNum
+		want   string
+	}{
+		{name: "v0 miner", code: sa0builtin.StorageMinerActorCodeID, method: sa0builtin.MethodsMiner.SubmitWindowedPoSt, want: "SubmitWindowedPoSt"},
+		{name: "v2 miner", code: sa2builtin.StorageMinerActorCodeID, method: sa2builtin.MethodsMiner.PreCommitSector, want: "PreCommitSector"},
+		{name: "v2 market", code: sa2builtin.StorageMarketActorCodeID, method: sa2builtin.MethodsMarket.PublishStorageDeals, want: "PublishStorageDeals"},
+		{name: "constructor", code: sa0builtin.MultisigActorCodeID, method: sa0builtin.MethodConstructor, want: "Constructor"},
+		{name: "send to any actor", code: sa2builtin.AccountActorCodeID, method: sa2builtin.MethodSend, want: "Send"},
+		{name: "send to unknown actor", code: cid.Undef, method: sa0builtin.MethodSend, want: "Send"},
+		{name: "unknown method", code: sa0builtin.AccountActorCodeID, method: 99, want: ""},
+		{name: "unknown actor", code: cid.Undef, method: 2, want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, MethodName(tc.code, tc.method))
+		})
+	}
+}
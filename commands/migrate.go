@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"io"
+	"os"
+
 	"github.com/filecoin-project/sentinel-visor/storage"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
@@ -8,7 +11,7 @@ import (
 
 var Migrate = &cli.Command{
 	Name:  "migrate",
-	Usage: "Reports and verifies the current database schema version and latest available for migration. Use --to or --latest to perform a schema migration.",
+	Usage: "Reports and verifies the current database schema version and latest available for migration. Use --to or --latest to perform a schema migration and --dry-run to review it first. Use --verify for a full report of schema differences.",
 	Flags: []cli.Flag{
 		&cli.IntFlag{
 			Name:  "to",
@@ -20,6 +23,20 @@ var Migrate = &cli.Command{
 			Value: false,
 			Usage: "Migrate the schema to the latest version.",
 		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Value: false,
+			Usage: "Print the SQL that would be run by the migration given by --to or --latest, without changing the database.",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "Write the SQL printed by --dry-run to `FILE` instead of stdout.",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Value: false,
+			Usage: "Verify tables, columns, primary keys, indexes, hypertables and materialized views against the current schema version and report every difference found.",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		if err := setupLogging(cctx); err != nil {
@@ -28,11 +45,25 @@ var Migrate = &cli.Command{
 
 		ctx := cctx.Context
 
-		db, err := storage.NewDatabase(ctx, cctx.String("db"), cctx.Int("db-pool-size"))
+		db, err := storage.NewDatabase(ctx, cctx.String("db"), cctx.Int("db-pool-size"), cctx.String("db-schema"))
 		if err != nil {
 			return xerrors.Errorf("connect database: %w", err)
 		}
 
+		if cctx.Bool("dry-run") {
+			target := storage.LatestSchemaVersion()
+			if cctx.IsSet("to") {
+				target = cctx.Int("to")
+			} else if !cctx.Bool("latest") {
+				return xerrors.Errorf("--dry-run requires --to or --latest")
+			}
+			return planMigration(cctx, db, target)
+		}
+
+		if cctx.Bool("verify") {
+			return verifySchema(cctx, db)
+		}
+
 		if cctx.IsSet("to") {
 			return db.MigrateSchemaTo(ctx, cctx.Int("to"))
 		}
@@ -55,3 +86,51 @@ var Migrate = &cli.Command{
 		return nil
 	},
 }
+
+func planMigration(cctx *cli.Context, db *storage.Database, target int) error {
+	plan, err := db.PlanMigration(cctx.Context, target)
+	if err != nil {
+		return xerrors.Errorf("plan migration: %w", err)
+	}
+
+	for _, step := range plan.Steps {
+		for _, warning := range step.Warnings {
+			log.Warnf("schema version %d: %s", step.Version, warning)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if cctx.IsSet("output") {
+		f, err := os.Create(cctx.String("output"))
+		if err != nil {
+			return xerrors.Errorf("create output: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := plan.WriteSQL(w); err != nil {
+		return xerrors.Errorf("write plan: %w", err)
+	}
+
+	if cctx.IsSet("output") {
+		log.Infof("wrote plan to migrate schema %s from version %d to version %d to %s", plan.Schema, plan.From, plan.To, cctx.String("output"))
+	}
+	return nil
+}
+
+func verifySchema(cctx *cli.Context, db *storage.Database) error {
+	report, err := db.VerifySchema(cctx.Context)
+	if err != nil {
+		return xerrors.Errorf("verify schema: %w", err)
+	}
+
+	if err := report.Write(os.Stdout); err != nil {
+		return xerrors.Errorf("write report: %w", err)
+	}
+
+	if !report.OK() {
+		return xerrors.Errorf("schema %s has %d problems", report.Schema, len(report.Problems))
+	}
+	return nil
+}
@@ -14,10 +14,13 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/sentinel-visor/schedule"
+	"github.com/filecoin-project/sentinel-visor/storage"
 	"github.com/filecoin-project/sentinel-visor/tasks/actorstate"
 	"github.com/filecoin-project/sentinel-visor/tasks/chain"
+	"github.com/filecoin-project/sentinel-visor/tasks/derived"
 	"github.com/filecoin-project/sentinel-visor/tasks/indexer"
 	"github.com/filecoin-project/sentinel-visor/tasks/message"
+	"github.com/filecoin-project/sentinel-visor/tasks/retention"
 	"github.com/filecoin-project/sentinel-visor/tasks/stats"
 	"github.com/filecoin-project/sentinel-visor/tasks/views"
 	"github.com/filecoin-project/sentinel-visor/version"
@@ -51,6 +54,12 @@ var Run = &cli.Command{
 			Value:   25,
 			EnvVars: []string{"VISOR_INDEXHEAD_CONFIDENCE"},
 		},
+		&cli.BoolFlag{
+			Name:    "mempool",
+			Usage:   "Start recording messages seen in the mempool of the lens",
+			Value:   false,
+			EnvVars: []string{"VISOR_MEMPOOL"},
+		},
 		&cli.BoolFlag{
 			Name:    "indexhistory",
 			Value:   false,
@@ -108,6 +117,12 @@ var Run = &cli.Command{
 			Usage:   "Number of actor state processors to start",
 			EnvVars: []string{"VISOR_ACTORSTATE_WORKERS"},
 		},
+		&cli.IntFlag{
+			Name:    "actorstate-prefetch",
+			Value:   8,
+			Usage:   "Maximum number of concurrent requests used by each actor state processor to prefetch state for a batch, 0 disables prefetching",
+			EnvVars: []string{"VISOR_ACTORSTATE_PREFETCH"},
+		},
 		&cli.StringSliceFlag{
 			Name:        "actorstate-include",
 			Usage:       "List of actor codes that should be procesed by actor state processors",
@@ -120,6 +135,12 @@ var Run = &cli.Command{
 			DefaultText: "none",
 			EnvVars:     []string{"VISOR_ACTORSTATE_EXCLUDE"},
 		},
+		&cli.StringFlag{
+			Name:    "actorstate-raw-storage",
+			Value:   actorstate.RawStateFull,
+			Usage:   "How raw actor state is stored: full stores the state of every changed actor at every height in actor_states, dedup stores each distinct state once by head in actor_state_contents and diff also stores a state as a diff against the previous head when it is already stored",
+			EnvVars: []string{"VISOR_ACTORSTATE_RAW_STORAGE"},
+		},
 		&cli.DurationFlag{
 			Name:    "message-lease",
 			Aliases: []string{"ml"},
@@ -145,8 +166,31 @@ var Run = &cli.Command{
 			Name:    "derive-parsed-messages",
 			Aliases: []string{"dpm"},
 			Value:   false,
-			Usage:   "Fill the parsed messages table when processing messages",
+			Usage:   "Deprecated: use --parsedmessage-workers. Starts one parsed message processor if no workers are configured",
 			EnvVars: []string{"VISOR_MESSAGE_PARSED"},
+			Hidden:  true,
+		},
+
+		&cli.IntFlag{
+			Name:    "parsedmessage-workers",
+			Aliases: []string{"pmw"},
+			Value:   0,
+			Usage:   "Number of parsed message processors to start",
+			EnvVars: []string{"VISOR_PARSEDMESSAGE_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "parsedmessage-batch",
+			Aliases: []string{"pmb"},
+			Value:   10,
+			Usage:   "Batch size for the parsed message processor",
+			EnvVars: []string{"VISOR_PARSEDMESSAGE_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "parsedmessage-lease",
+			Aliases: []string{"pml"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the parsed message processor",
+			EnvVars: []string{"VISOR_PARSEDMESSAGE_LEASE"},
 		},
 
 		&cli.DurationFlag{
@@ -178,6 +222,28 @@ var Run = &cli.Command{
 			Usage:   "Refresh frequency for chain visualization views (0 = disables refresh)",
 			EnvVars: []string{"VISOR_CHAINVIS_REFRESH"},
 		},
+		&cli.DurationFlag{
+			Name:        "consensus-refresh-rate",
+			Aliases:     []string{"csr"},
+			Usage:       "Refresh frequency for the consensus chain view (0 = disables refresh)",
+			DefaultText: "chainvis-refresh-rate",
+			EnvVars:     []string{"VISOR_CONSENSUS_REFRESH"},
+		},
+
+		&cli.DurationFlag{
+			Name:    "chainaggregate-refresh-rate",
+			Aliases: []string{"car"},
+			Value:   0,
+			Usage:   "Refresh frequency for aggregates of chain metrics (0 = disables refresh)",
+			EnvVars: []string{"VISOR_CHAINAGGREGATE_REFRESH"},
+		},
+		&cli.Int64Flag{
+			Name:    "chainaggregate-window",
+			Aliases: []string{"caw"},
+			Value:   5760,
+			Usage:   "Number of recent epochs recomputed by each refresh of the chain aggregates (0 = recompute everything)",
+			EnvVars: []string{"VISOR_CHAINAGGREGATE_WINDOW"},
+		},
 
 		&cli.DurationFlag{
 			Name:    "processingstats-refresh-rate",
@@ -187,6 +253,79 @@ var Run = &cli.Command{
 			EnvVars: []string{"VISOR_PROCESSINGSTATS_REFRESH"},
 		},
 
+		&cli.DurationFlag{
+			Name:    "lease-reap-rate",
+			Aliases: []string{"lrr"},
+			Value:   0,
+			Usage:   "Frequency to clear expired claims on leased work (0 = disables reaping)",
+			EnvVars: []string{"VISOR_LEASE_REAP"},
+		},
+
+		&cli.DurationFlag{
+			Name:    "lease-stall-limit",
+			Aliases: []string{"lsl"},
+			Value:   2 * time.Hour,
+			Usage:   "Time a processor may hold a lease without completing an item before the lease is allowed to expire (0 = renews until released)",
+			EnvVars: []string{"VISOR_LEASE_STALL_LIMIT"},
+		},
+
+		&cli.DurationFlag{
+			Name:    "retention-rate",
+			Aliases: []string{"rtr"},
+			Value:   0,
+			Usage:   "Frequency to apply data retention policies (0 = disables retention)",
+			EnvVars: []string{"VISOR_RETENTION_RATE"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "retention-policy",
+			Usage:       "Retention policy for a table as table=all, table=epochs to keep the most recent epochs, table=epochs/interval to also keep one height in every interval before that or table=referenced to keep only rows still referenced by other tables (supported by actor_state_contents)",
+			DefaultText: "none, all data is retained",
+			EnvVars:     []string{"VISOR_RETENTION_POLICY"},
+		},
+
+		&cli.DurationFlag{
+			Name:    "minersector-refresh-rate",
+			Aliases: []string{"msr"},
+			Value:   0,
+			Usage:   "Frequency to check for tipsets whose sector events can be applied to the miner sector summary (0 = disables summary)",
+			EnvVars: []string{"VISOR_MINERSECTOR_REFRESH"},
+		},
+		&cli.IntFlag{
+			Name:    "minersector-batch",
+			Aliases: []string{"msb"},
+			Value:   500,
+			Usage:   "Number of tipsets whose sectors are summarized in a single transaction",
+			EnvVars: []string{"VISOR_MINERSECTOR_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "minersector-lease",
+			Aliases: []string{"msl"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the miner sector summary",
+			EnvVars: []string{"VISOR_MINERSECTOR_LEASE"},
+		},
+		&cli.DurationFlag{
+			Name:    "deal-refresh-rate",
+			Aliases: []string{"dlr"},
+			Value:   0,
+			Usage:   "Frequency to check for tipsets whose market and sector deal changes can be applied to the deal lifecycle (0 = disables deal lifecycle)",
+			EnvVars: []string{"VISOR_DEAL_REFRESH"},
+		},
+		&cli.IntFlag{
+			Name:    "deal-batch",
+			Aliases: []string{"dlb"},
+			Value:   500,
+			Usage:   "Number of tipsets whose deals are rebuilt in a single transaction",
+			EnvVars: []string{"VISOR_DEAL_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "deal-lease",
+			Aliases: []string{"dll"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the deal lifecycle",
+			EnvVars: []string{"VISOR_DEAL_LEASE"},
+		},
+
 		&cli.IntFlag{
 			Name:    "chaineconomics-workers",
 			Aliases: []string{"cew"},
@@ -209,6 +348,94 @@ var Run = &cli.Command{
 			EnvVars: []string{"VISOR_CHAINECONOMICS_LEASE"},
 		},
 
+		&cli.IntFlag{
+			Name:    "internalmessage-workers",
+			Aliases: []string{"imw"},
+			Value:   0,
+			Usage:   "Number of internal message processors to start",
+			EnvVars: []string{"VISOR_INTERNALMESSAGE_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "internalmessage-batch",
+			Aliases: []string{"imb"},
+			Value:   10, // each tipset is replayed by the lens so keep batches small
+			Usage:   "Batch size for the internal message processor",
+			EnvVars: []string{"VISOR_INTERNALMESSAGE_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "internalmessage-lease",
+			Aliases: []string{"iml"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the internal message processor",
+			EnvVars: []string{"VISOR_INTERNALMESSAGE_LEASE"},
+		},
+
+		&cli.IntFlag{
+			Name:    "gasaggregate-workers",
+			Aliases: []string{"gaw"},
+			Value:   0,
+			Usage:   "Number of gas aggregate processors to start",
+			EnvVars: []string{"VISOR_GASAGGREGATE_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "gasaggregate-batch",
+			Aliases: []string{"gab"},
+			Value:   10,
+			Usage:   "Batch size for the gas aggregate processor",
+			EnvVars: []string{"VISOR_GASAGGREGATE_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "gasaggregate-lease",
+			Aliases: []string{"gal"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the gas aggregate processor",
+			EnvVars: []string{"VISOR_GASAGGREGATE_LEASE"},
+		},
+
+		&cli.IntFlag{
+			Name:    "blockreward-workers",
+			Aliases: []string{"brw"},
+			Value:   0,
+			Usage:   "Number of block reward processors to start",
+			EnvVars: []string{"VISOR_BLOCKREWARD_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "blockreward-batch",
+			Aliases: []string{"brb"},
+			Value:   10,
+			Usage:   "Batch size for the block reward processor",
+			EnvVars: []string{"VISOR_BLOCKREWARD_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "blockreward-lease",
+			Aliases: []string{"brl"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the block reward processor",
+			EnvVars: []string{"VISOR_BLOCKREWARD_LEASE"},
+		},
+
+		&cli.IntFlag{
+			Name:    "balancechange-workers",
+			Aliases: []string{"bcw"},
+			Value:   0,
+			Usage:   "Number of balance change processors to start",
+			EnvVars: []string{"VISOR_BALANCECHANGE_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "balancechange-batch",
+			Aliases: []string{"bcb"},
+			Value:   10,
+			Usage:   "Batch size for the balance change processor",
+			EnvVars: []string{"VISOR_BALANCECHANGE_BATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "balancechange-lease",
+			Aliases: []string{"bcl"},
+			Value:   time.Minute * 15,
+			Usage:   "Lease time for the balance change processor",
+			EnvVars: []string{"VISOR_BALANCECHANGE_LEASE"},
+		},
+
 		&cli.DurationFlag{
 			Name:    "task-delay",
 			Aliases: []string{"td"},
@@ -231,6 +458,11 @@ var Run = &cli.Command{
 			return err
 		}
 
+		retentionPolicies, err := getRetentionPolicies(cctx)
+		if err != nil {
+			return err
+		}
+
 		if err := setupLogging(cctx); err != nil {
 			return xerrors.Errorf("setup logging: %w", err)
 		}
@@ -251,6 +483,7 @@ var Run = &cli.Command{
 		if err != nil {
 			return xerrors.Errorf("setup storage and api: %w", err)
 		}
+		rctx.db.LeaseStallLimit = cctx.Duration("lease-stall-limit")
 		defer func() {
 			rctx.closer()
 			if err := rctx.db.Close(ctx); err != nil {
@@ -272,6 +505,17 @@ var Run = &cli.Command{
 			})
 		}
 
+		// Add one task to watch the mempool
+		if cctx.Bool("mempool") {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "MempoolWatcher",
+				Task:                message.NewMempoolWatcher(rctx.db, rctx.opener),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true, // the subscription may be closed by the lens
+				RestartDelay:        time.Minute,
+			})
+		}
+
 		// Add one indexing task to walk the chain history
 		if cctx.Bool("indexhistory") {
 			scheduler.Add(schedule.TaskConfig{
@@ -312,7 +556,7 @@ var Run = &cli.Command{
 			hr := heightRange{min: actorStateHeightFrom, max: heightTo}
 			srs := hr.divide(cctx.Int("actorstate-workers"))
 			for i, sr := range srs {
-				p, err := actorstate.NewActorStateProcessor(rctx.db, rctx.opener, 0, cctx.Int("actorstate-batch"), sr.min, sr.max, actorCodes, false)
+				p, err := actorstate.NewActorStateProcessor(rctx.db, rctx.opener, 0, cctx.Int("actorstate-batch"), sr.min, sr.max, actorCodes, false, cctx.Int("actorstate-prefetch"), cctx.String("actorstate-raw-storage"))
 				if err != nil {
 					return err
 				}
@@ -328,7 +572,7 @@ var Run = &cli.Command{
 		} else {
 			// Use workers with leasing
 			for i := 0; i < cctx.Int("actorstate-workers"); i++ {
-				p, err := actorstate.NewActorStateProcessor(rctx.db, rctx.opener, cctx.Duration("actorstate-lease"), cctx.Int("actorstate-batch"), actorStateHeightFrom, heightTo, actorCodes, true)
+				p, err := actorstate.NewActorStateProcessor(rctx.db, rctx.opener, cctx.Duration("actorstate-lease"), cctx.Int("actorstate-batch"), actorStateHeightFrom, heightTo, actorCodes, true, cctx.Int("actorstate-prefetch"), cctx.String("actorstate-raw-storage"))
 				if err != nil {
 					return err
 				}
@@ -345,7 +589,26 @@ var Run = &cli.Command{
 		for i := 0; i < cctx.Int("message-workers"); i++ {
 			scheduler.Add(schedule.TaskConfig{
 				Name:                fmt.Sprintf("MessageProcessor%03d", i),
-				Task:                message.NewMessageProcessor(rctx.db, rctx.opener, cctx.Duration("message-lease"), cctx.Int("message-batch"), cctx.Bool("derive-parsed-messages"), heightFrom, heightTo),
+				Task:                message.NewMessageProcessor(rctx.db, rctx.opener, cctx.Duration("message-lease"), cctx.Int("message-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+
+		parsedMessageWorkers := cctx.Int("parsedmessage-workers")
+		if cctx.Bool("derive-parsed-messages") {
+			log.Warnf("--derive-parsed-messages is deprecated, use --parsedmessage-workers instead")
+			if !cctx.IsSet("parsedmessage-workers") {
+				parsedMessageWorkers = 1
+			}
+		}
+
+		// Add several parsed message tasks to parse messages from indexed tipsets
+		for i := 0; i < parsedMessageWorkers; i++ {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                fmt.Sprintf("ParsedMessageProcessor%03d", i),
+				Task:                message.NewParsedMessageProcessor(rctx.db, rctx.opener, cctx.Duration("parsedmessage-lease"), cctx.Int("parsedmessage-batch"), heightFrom, heightTo),
 				RestartOnFailure:    true,
 				RestartOnCompletion: true,
 				RestartDelay:        time.Minute,
@@ -394,6 +657,50 @@ var Run = &cli.Command{
 			})
 		}
 
+		// Add several internal message tasks to replay indexed tipsets
+		for i := 0; i < cctx.Int("internalmessage-workers"); i++ {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                fmt.Sprintf("InternalMessageProcessor%03d", i),
+				Task:                message.NewInternalMessageProcessor(rctx.db, rctx.opener, cctx.Duration("internalmessage-lease"), cctx.Int("internalmessage-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+
+		// Add several gas aggregate tasks to summarize the gas used by indexed tipsets
+		for i := 0; i < cctx.Int("gasaggregate-workers"); i++ {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                fmt.Sprintf("GasAggregateProcessor%03d", i),
+				Task:                message.NewGasAggregateProcessor(rctx.db, rctx.opener, cctx.Duration("gasaggregate-lease"), cctx.Int("gasaggregate-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+
+		// Add several block reward tasks to attribute rewards to the miners of indexed blocks
+		for i := 0; i < cctx.Int("blockreward-workers"); i++ {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                fmt.Sprintf("BlockRewardProcessor%03d", i),
+				Task:                message.NewBlockRewardProcessor(rctx.db, rctx.opener, cctx.Duration("blockreward-lease"), cctx.Int("blockreward-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+
+		// Add several balance change tasks to record the history of every actor's balance
+		for i := 0; i < cctx.Int("balancechange-workers"); i++ {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                fmt.Sprintf("BalanceChangeProcessor%03d", i),
+				Task:                actorstate.NewBalanceChangeProcessor(rctx.db, rctx.opener, cctx.Duration("balancechange-lease"), cctx.Int("balancechange-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+
 		// Include optional refresher for Chain Visualization views
 		// Zero duration will cause ChainVisRefresher to exit and should not restart
 		if cctx.Duration("chainvis-refresh-rate") != 0 {
@@ -406,6 +713,35 @@ var Run = &cli.Command{
 				RestartDelay:        time.Minute,
 			})
 		}
+
+		// Include optional refresher for the consensus chain view, which may need a longer rate than the other
+		// chain visualization views since it is slower to refresh
+		consensusRefreshRate := cctx.Duration("chainvis-refresh-rate")
+		if cctx.IsSet("consensus-refresh-rate") {
+			consensusRefreshRate = cctx.Duration("consensus-refresh-rate")
+		}
+		if consensusRefreshRate != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "ConsensusChainRefresher",
+				Locker:              NewGlobalSingleton(ConsensusChainRefresherLockID, rctx.db), // only need one consensus chain refresher anywhere
+				Task:                views.NewConsensusChainRefresher(rctx.db, consensusRefreshRate),
+				RestartOnFailure:    true,
+				RestartOnCompletion: false,
+				RestartDelay:        time.Minute,
+			})
+		}
+
+		// Include optional incremental refresher for aggregates of chain metrics
+		if cctx.Duration("chainaggregate-refresh-rate") != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "ChainAggregateRefresher",
+				Locker:              NewGlobalSingleton(ChainAggregateRefresherLockID, rctx.db), // only need one chain aggregate refresher anywhere
+				Task:                views.NewChainAggregateRefresher(rctx.db, cctx.Duration("chainaggregate-refresh-rate"), cctx.Int64("chainaggregate-window")),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
 		// Include optional refresher for processing stats
 		if cctx.Duration("processingstats-refresh-rate") != 0 {
 			scheduler.Add(schedule.TaskConfig{
@@ -417,6 +753,50 @@ var Run = &cli.Command{
 				RestartDelay:        time.Minute,
 			})
 		}
+		// Include optional reaper of expired leases
+		if cctx.Duration("lease-reap-rate") != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "LeaseReaper",
+				Locker:              NewGlobalSingleton(LeaseReaperLockID, rctx.db),
+				Task:                stats.NewLeaseReaper(rctx.db, cctx.Duration("lease-reap-rate")),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+		// Include optional data retention
+		if cctx.Duration("retention-rate") != 0 && len(retentionPolicies) != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "RetentionManager",
+				Locker:              NewGlobalSingleton(RetentionManagerLockID, rctx.db), // only need one retention manager anywhere
+				Task:                retention.NewRetentionManager(rctx.db, retentionPolicies, cctx.Duration("retention-rate")),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+		// Include optional summary of miner sectors
+		if cctx.Duration("minersector-refresh-rate") != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "MinerSectorProcessor",
+				Locker:              NewGlobalSingleton(MinerSectorProcessorLockID, rctx.db), // a single processor so rebuilds of the same sector do not interleave
+				Task:                derived.NewMinerSectorProcessor(rctx.db, cctx.Duration("minersector-refresh-rate"), cctx.Duration("minersector-lease"), cctx.Int("minersector-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
+		// Include optional deal lifecycle
+		if cctx.Duration("deal-refresh-rate") != 0 {
+			scheduler.Add(schedule.TaskConfig{
+				Name:                "DealProcessor",
+				Locker:              NewGlobalSingleton(DealProcessorLockID, rctx.db), // a single processor so rebuilds of the same deal do not interleave
+				Task:                derived.NewDealProcessor(rctx.db, cctx.Duration("deal-refresh-rate"), cctx.Duration("deal-lease"), cctx.Int("deal-batch"), heightFrom, heightTo),
+				RestartOnFailure:    true,
+				RestartOnCompletion: true,
+				RestartDelay:        time.Minute,
+			})
+		}
 
 		// Start the scheduler and wait for it to complete or to be cancelled.
 		err = scheduler.Run(ctx)
@@ -472,6 +852,24 @@ func getActorCodes(cctx *cli.Context) ([]cid.Cid, error) {
 	return codes, nil
 }
 
+// getRetentionPolicies parses the cli flags to obtain the retention policy of each table.
+func getRetentionPolicies(cctx *cli.Context) ([]storage.RetentionPolicy, error) {
+	var policies []storage.RetentionPolicy
+	seen := map[string]bool{}
+	for _, s := range cctx.StringSlice("retention-policy") {
+		p, err := storage.ParseRetentionPolicy(s)
+		if err != nil {
+			return nil, err
+		}
+		if seen[p.Table] {
+			return nil, fmt.Errorf("more than one retention policy for table %s", p.Table)
+		}
+		seen[p.Table] = true
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
 func parseActorCodes(ss []string) ([]cid.Cid, error) {
 	var codes []cid.Cid
 	for _, s := range ss {
@@ -49,6 +49,8 @@ func setupStorageAndAPI(cctx *cli.Context) (context.Context, *RunContext, error)
 
 	if cctx.String("lens") == "lotus" {
 		opener, closer, err = vapi.NewAPIOpener(cctx, 10_000)
+	} else if cctx.String("lens") == "gateway" {
+		opener, closer, err = vapi.NewGatewayAPIOpener(cctx, 10_000)
 	} else if cctx.String("lens") == "lotusrepo" {
 		opener, closer, err = repoapi.NewAPIOpener(cctx)
 	} else if cctx.String("lens") == "carrepo" {
@@ -60,7 +62,7 @@ func setupStorageAndAPI(cctx *cli.Context) (context.Context, *RunContext, error)
 		return nil, nil, xerrors.Errorf("get node api: %w", err)
 	}
 
-	db, err := storage.NewDatabase(ctx, cctx.String("db"), cctx.Int("db-pool-size"))
+	db, err := storage.NewDatabase(ctx, cctx.String("db"), cctx.Int("db-pool-size"), cctx.String("db-schema"))
 	if err != nil {
 		closer()
 		return nil, nil, xerrors.Errorf("new database: %w", err)
@@ -188,27 +190,51 @@ const (
 	ChainHistoryIndexerLockID      = 98981112
 	ChainVisRefresherLockID        = 98981113
 	ProcessingStatsRefresherLockID = 98981114
+	MinerSectorProcessorLockID     = 98981115
+	DealProcessorLockID            = 98981116
+	LeaseReaperLockID              = 98981117
+	RetentionManagerLockID         = 98981118
+	ChainAggregateRefresherLockID  = 98981119
+	ConsensusChainRefresherLockID  = 98981120
 )
 
+// lockCheckInterval is how often a GlobalSingleton verifies that it still holds its lock
+const lockCheckInterval = 10 * time.Second
+
 func NewGlobalSingleton(id int64, d *storage.Database) *GlobalSingleton {
 	return &GlobalSingleton{
-		LockID:  storage.AdvisoryLock(id),
-		Storage: d,
+		LockID:        storage.AdvisoryLock(id),
+		Storage:       d,
+		CheckInterval: lockCheckInterval,
 	}
 }
 
-// GlobalSingleton is a task locker that ensures only one task can run across all processes
+// GlobalSingleton is a task locker that ensures only one task can run across all processes. The lock is held on a
+// dedicated database connection and the task's context is cancelled if the lock is lost.
 type GlobalSingleton struct {
-	LockID  storage.AdvisoryLock
-	Storage *storage.Database
+	LockID        storage.AdvisoryLock
+	Storage       *storage.Database
+	CheckInterval time.Duration
+
+	held *storage.HeldLock
 }
 
-func (g *GlobalSingleton) Lock(ctx context.Context) error {
-	return g.LockID.LockExclusive(ctx, g.Storage.DB)
+func (g *GlobalSingleton) Lock(ctx context.Context) (context.Context, error) {
+	held, err := g.LockID.HoldExclusive(ctx, g.Storage.DB, g.CheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	g.held = held
+	return held.Context(), nil
 }
 
 func (g *GlobalSingleton) Unlock(ctx context.Context) error {
-	return g.LockID.UnlockExclusive(ctx, g.Storage.DB)
+	if g.held == nil {
+		return storage.ErrLockNotReleased
+	}
+	held := g.held
+	g.held = nil
+	return held.Release(ctx)
 }
 
 func setupMetrics(cctx *cli.Context) error {
@@ -0,0 +1,40 @@
+package lens
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrMethodUnavailable is returned when a task requires an api method that the lens does not provide.
+var ErrMethodUnavailable = errors.New("api method unavailable")
+
+// A CapabilityReporter is implemented by lenses that only provide a subset of the full node api, such as
+// those connected to a Lotus gateway.
+type CapabilityReporter interface {
+	// MethodAvailable reports whether the named api method, such as "StateVMCirculatingSupplyInternal",
+	// can be called on this lens.
+	MethodAvailable(name string) bool
+}
+
+// RequireMethods returns an error wrapping ErrMethodUnavailable if any of the named methods are not
+// available from the api. Lenses that do not implement CapabilityReporter are assumed to provide every method.
+func RequireMethods(api API, methods ...string) error {
+	cr, ok := api.(CapabilityReporter)
+	if !ok {
+		return nil
+	}
+
+	var missing []string
+	for _, m := range methods {
+		if !cr.MethodAvailable(m) {
+			missing = append(missing, m)
+		}
+	}
+
+	if len(missing) > 0 {
+		return xerrors.Errorf("lens does not provide %s: %w", strings.Join(missing, ", "), ErrMethodUnavailable)
+	}
+	return nil
+}
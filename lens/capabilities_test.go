@@ -0,0 +1,48 @@
+package lens
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fullAPI provides every method since it does not report its capabilities
+type fullAPI struct {
+	API
+}
+
+// partialAPI only provides the methods listed in available
+type partialAPI struct {
+	API
+	available map[string]bool
+}
+
+func (p *partialAPI) MethodAvailable(name string) bool {
+	return p.available[name]
+}
+
+func TestRequireMethods(t *testing.T) {
+	partial := &partialAPI{available: map[string]bool{"ChainHead": true, "StateGetActor": true}}
+
+	t.Run("full api", func(t *testing.T) {
+		assert.NoError(t, RequireMethods(&fullAPI{}, "ChainHead", "StateCompute"))
+	})
+
+	t.Run("available", func(t *testing.T) {
+		assert.NoError(t, RequireMethods(partial, "ChainHead", "StateGetActor"))
+	})
+
+	t.Run("no methods", func(t *testing.T) {
+		assert.NoError(t, RequireMethods(partial))
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		err := RequireMethods(partial, "ChainHead", "StateCompute", "MpoolSub")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrMethodUnavailable))
+		assert.Contains(t, err.Error(), "StateCompute, MpoolSub")
+		assert.NotContains(t, err.Error(), "ChainHead")
+	})
+}
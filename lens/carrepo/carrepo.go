@@ -30,9 +30,11 @@ import (
 	"github.com/filecoin-project/specs-actors/actors/util/adt"
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
-	"github.com/willscott/carbs"
+	logging "github.com/ipfs/go-log/v2"
 )
 
+var log = logging.Logger("carrepo")
+
 type APIOpener struct {
 	// shared instance of the repo since the opener holds an exclusive lock on it
 	rapi *CarAPI
@@ -45,36 +47,56 @@ func NewAPIOpener(c *cli.Context) (*APIOpener, lens.APICloser, error) {
 		return nil, nil, fmt.Errorf("setting file descriptor limit: %s", err)
 	}
 
-	db, err := carbs.Load(c.String("repo"), false)
+	paths, err := ExpandCarPaths(c.String("repo"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := LoadMultiCarStore(c.Context, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cacheDB, cacheCloser, err := NewCachingStore(db, c.Int("lens-write-cache"))
 	if err != nil {
+		_ = db.Close()
 		return nil, nil, err
 	}
-	cacheDB := NewCachingStore(db)
 
 	r := repo.NewMemory(nil)
 
 	lr, err := r.Lock(repo.FullNode)
 	if err != nil {
+		_ = cacheCloser()
+		_ = db.Close()
 		return nil, nil, err
 	}
 
+	sf := func() {
+		lr.Close()
+		if err := cacheCloser(); err != nil {
+			log.Errorf("failed to remove carrepo write cache: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			log.Errorf("failed to close car files: %v", err)
+		}
+	}
+
 	mds, err := lr.Datastore("/metadata")
 	if err != nil {
+		sf()
 		return nil, nil, err
 	}
 
 	cs := store.NewChainStore(cacheDB, mds, vm.Syscalls(&fakeVerifier{}), journal.NilJournal())
 
-	headKey, err := db.Roots()
+	headTs, err := heaviestRoot(cs, db.Roots())
 	if err != nil {
+		sf()
 		return nil, nil, err
 	}
-
-	headTs, err := cs.LoadTipSet(types.NewTipSetKey(headKey...))
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load our own chainhead: %w", err)
-	}
 	if err := cs.SetHead(headTs); err != nil {
+		sf()
 		return nil, nil, fmt.Errorf("failed to set our own chainhead: %w", err)
 	}
 
@@ -86,15 +108,29 @@ func NewAPIOpener(c *cli.Context) (*APIOpener, lens.APICloser, error) {
 	rapi.FullNodeAPI.StateAPI.StateManager = sm
 	rapi.FullNodeAPI.StateAPI.StateModuleAPI = &full.StateModule{Chain: cs, StateManager: sm}
 
-	sf := func() {
-		lr.Close()
-	}
-
 	rapi.Context = c.Context
 	rapi.cacheSize = c.Int("lens-cache-hint")
 	return &APIOpener{&rapi}, sf, nil
 }
 
+// heaviestRoot loads the tipset at the roots of each car and returns the one with the greatest height.
+func heaviestRoot(cs *store.ChainStore, roots [][]cid.Cid) (*types.TipSet, error) {
+	var head *types.TipSet
+	for _, r := range roots {
+		ts, err := cs.LoadTipSet(types.NewTipSetKey(r...))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load our own chainhead: %w", err)
+		}
+		if head == nil || ts.Height() > head.Height() {
+			head = ts
+		}
+	}
+	if head == nil {
+		return nil, fmt.Errorf("no roots found in car files")
+	}
+	return head, nil
+}
+
 func (o *APIOpener) Open(ctx context.Context) (lens.API, lens.APICloser, error) {
 	return o.rapi, lens.APICloser(func() {}), nil
 }
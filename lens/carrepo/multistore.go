@@ -0,0 +1,188 @@
+package carrepo
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/lotus/lib/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/willscott/carbs"
+	"golang.org/x/xerrors"
+)
+
+// ExpandCarPaths expands a comma separated list of paths or glob patterns into the list of CAR files it refers to.
+func ExpandCarPaths(spec string) ([]string, error) {
+	seen := map[string]bool{}
+	var paths []string
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid car path pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, xerrors.Errorf("no car files match %q", pattern)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			paths = append(paths, m)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, xerrors.Errorf("no car files specified")
+	}
+	return paths, nil
+}
+
+// MultiCarStore composes a set of CAR files into a single read-only blockstore. An index of every CID to the
+// CAR that contains it is built when the store is loaded so reads go directly to the right file.
+type MultiCarStore struct {
+	cars  []*carbs.Carbs
+	index map[string]int // multihash -> index into cars
+	roots [][]cid.Cid    // roots of each car, in the same order as cars
+}
+
+var _ blockstore.Blockstore = (*MultiCarStore)(nil)
+
+// LoadMultiCarStore opens each of the CAR files at paths and indexes their contents.
+func LoadMultiCarStore(ctx context.Context, paths []string) (*MultiCarStore, error) {
+	ms := &MultiCarStore{
+		index: map[string]int{},
+	}
+
+	if err := ms.load(ctx, paths); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func (ms *MultiCarStore) load(ctx context.Context, paths []string) error {
+	for i, path := range paths {
+		db, err := carbs.Load(path, false)
+		if err != nil {
+			return xerrors.Errorf("load car %s: %w", path, err)
+		}
+		ms.cars = append(ms.cars, db)
+
+		roots, err := db.Roots()
+		if err != nil {
+			return xerrors.Errorf("read roots of car %s: %w", path, err)
+		}
+
+		keys, err := db.AllKeysChan(ctx)
+		if err != nil {
+			return xerrors.Errorf("read keys of car %s: %w", path, err)
+		}
+		for c := range keys {
+			// Earlier cars take precedence when a block is duplicated across files
+			if _, exists := ms.index[string(c.Hash())]; !exists {
+				ms.index[string(c.Hash())] = i
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ms.roots = append(ms.roots, roots)
+	}
+
+	return nil
+}
+
+// Close drops the store's references to its CAR files. carbs v0.0.3 memory maps each CAR file when it is loaded but
+// provides no way to unmap it, so the mappings and their file descriptors are only released when the process exits.
+// The store must not be used after it is closed.
+func (ms *MultiCarStore) Close() error {
+	ms.cars = nil
+	ms.index = map[string]int{}
+	ms.roots = nil
+	return nil
+}
+
+// Roots returns the roots of each CAR file in the store, in the order they were loaded.
+func (ms *MultiCarStore) Roots() [][]cid.Cid {
+	return ms.roots
+}
+
+func (ms *MultiCarStore) lookup(c cid.Cid) (*carbs.Carbs, bool) {
+	i, ok := ms.index[string(c.Hash())]
+	if !ok {
+		return nil, false
+	}
+	return ms.cars[i], true
+}
+
+func (ms *MultiCarStore) Get(c cid.Cid) (blocks.Block, error) {
+	db, ok := ms.lookup(c)
+	if !ok {
+		return nil, blockstore.ErrNotFound
+	}
+	return db.Get(c)
+}
+
+func (ms *MultiCarStore) Has(c cid.Cid) (bool, error) {
+	_, ok := ms.lookup(c)
+	return ok, nil
+}
+
+func (ms *MultiCarStore) GetSize(c cid.Cid) (int, error) {
+	db, ok := ms.lookup(c)
+	if !ok {
+		return -1, blockstore.ErrNotFound
+	}
+	return db.GetSize(c)
+}
+
+func (ms *MultiCarStore) DeleteBlock(c cid.Cid) error {
+	return xerrors.Errorf("multi car store is read-only")
+}
+
+func (ms *MultiCarStore) Put(b blocks.Block) error {
+	return xerrors.Errorf("multi car store is read-only")
+}
+
+func (ms *MultiCarStore) PutMany(bs []blocks.Block) error {
+	return xerrors.Errorf("multi car store is read-only")
+}
+
+func (ms *MultiCarStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	outChan := make(chan cid.Cid, 10)
+
+	go func() {
+		defer close(outChan)
+		for i, db := range ms.cars {
+			keys, err := db.AllKeysChan(ctx)
+			if err != nil {
+				return
+			}
+			for c := range keys {
+				// Skip duplicates of blocks that are served from an earlier car
+				if ms.index[string(c.Hash())] != i {
+					continue
+				}
+				select {
+				case outChan <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return outChan, nil
+}
+
+func (ms *MultiCarStore) HashOnRead(enabled bool) {
+	return
+}
@@ -0,0 +1,148 @@
+package carrepo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lotus/lib/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "carrepo")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeCar writes a car file holding blks whose root is the first block
+func writeCar(t *testing.T, path string, blks ...blocks.Block) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, f))
+	for _, b := range blks {
+		require.NoError(t, carutil.LdWrite(f, b.Cid().Bytes(), b.RawData()))
+	}
+}
+
+func TestExpandCarPaths(t *testing.T) {
+	dir := tempDir(t)
+	for _, name := range []string{"b.car", "a.car", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	a := filepath.Join(dir, "a.car")
+	b := filepath.Join(dir, "b.car")
+
+	testCases := []struct {
+		name     string
+		spec     string
+		expected []string
+		err      bool
+	}{
+		{name: "single path", spec: b, expected: []string{b}},
+		{name: "glob is sorted", spec: filepath.Join(dir, "*.car"), expected: []string{a, b}},
+		{name: "list keeps order", spec: b + "," + a, expected: []string{b, a}},
+		{name: "duplicates removed", spec: " " + filepath.Join(dir, "*.car") + " , " + a + ",", expected: []string{a, b}},
+		{name: "no match", spec: filepath.Join(dir, "missing*.car"), err: true},
+		{name: "invalid pattern", spec: filepath.Join(dir, "[.car"), err: true},
+		{name: "empty", spec: " , ", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			paths, err := ExpandCarPaths(tc.spec)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, paths)
+		})
+	}
+}
+
+func TestMultiCarStore(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+
+	b1 := blocks.NewBlock([]byte("block one"))
+	b2 := blocks.NewBlock([]byte("block two"))
+	b3 := blocks.NewBlock([]byte("block three"))
+	missing := blocks.NewBlock([]byte("not in any car"))
+
+	car1 := filepath.Join(dir, "1.car")
+	car2 := filepath.Join(dir, "2.car")
+	writeCar(t, car1, b1, b2)
+	writeCar(t, car2, b3, b2) // b2 is in both cars
+
+	ms, err := LoadMultiCarStore(ctx, []string{car1, car2})
+	require.NoError(t, err)
+	defer ms.Close()
+
+	assert.Equal(t, [][]cid.Cid{{b1.Cid()}, {b3.Cid()}}, ms.Roots())
+
+	t.Run("get", func(t *testing.T) {
+		for _, b := range []blocks.Block{b1, b2, b3} {
+			got, err := ms.Get(b.Cid())
+			require.NoError(t, err)
+			assert.Equal(t, b.RawData(), got.RawData())
+
+			has, err := ms.Has(b.Cid())
+			require.NoError(t, err)
+			assert.True(t, has)
+
+			size, err := ms.GetSize(b.Cid())
+			require.NoError(t, err)
+			assert.Equal(t, len(b.RawData()), size)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := ms.Get(missing.Cid())
+		assert.Equal(t, blockstore.ErrNotFound, err)
+
+		has, err := ms.Has(missing.Cid())
+		require.NoError(t, err)
+		assert.False(t, has)
+
+		_, err = ms.GetSize(missing.Cid())
+		assert.Equal(t, blockstore.ErrNotFound, err)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		assert.Error(t, ms.Put(missing))
+		assert.Error(t, ms.PutMany([]blocks.Block{missing}))
+		assert.Error(t, ms.DeleteBlock(b1.Cid()))
+	})
+
+	t.Run("all keys once", func(t *testing.T) {
+		keys, err := ms.AllKeysChan(ctx)
+		require.NoError(t, err)
+
+		var got []cid.Cid
+		for c := range keys {
+			got = append(got, c)
+		}
+		assert.ElementsMatch(t, []cid.Cid{b1.Cid(), b2.Cid(), b3.Cid()}, got)
+	})
+}
+
+func TestLoadMultiCarStoreMissingCar(t *testing.T) {
+	dir := tempDir(t)
+
+	car1 := filepath.Join(dir, "1.car")
+	writeCar(t, car1, blocks.NewBlock([]byte("block one")))
+
+	_, err := LoadMultiCarStore(context.Background(), []string{car1, filepath.Join(dir, "missing.car")})
+	assert.Error(t, err)
+}
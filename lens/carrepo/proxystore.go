@@ -2,29 +2,70 @@ package carrepo
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
 
 	"github.com/filecoin-project/lotus/lib/blockstore"
+	lru "github.com/hashicorp/golang-lru"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
-	ds "github.com/ipfs/go-datastore"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"golang.org/x/xerrors"
 )
 
-func NewCachingStore(backing blockstore.Blockstore) blockstore.Blockstore {
-	cache := ds.NewMapDatastore()
-	bs := blockstore.NewBlockstore(cache)
+// NewCachingStore returns a blockstore that reads from backing and keeps any blocks written to it in a
+// write cache. Written blocks are spilled to a temporary leveldb datastore on disk, with at most
+// memCacheSize of the most recently written blocks also held in memory. The returned closer removes the
+// temporary datastore.
+func NewCachingStore(backing blockstore.Blockstore, memCacheSize int) (blockstore.Blockstore, func() error, error) {
+	dir, err := ioutil.TempDir("", "visor-carrepo-")
+	if err != nil {
+		return nil, nil, xerrors.Errorf("create write cache dir: %w", err)
+	}
 
-	return &proxyingBlockstore{
-		cache: bs,
-		store: backing,
+	spill, err := levelds.NewDatastore(dir, nil)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, nil, xerrors.Errorf("open write cache datastore: %w", err)
+	}
+
+	var recent *lru.ARCCache
+	if memCacheSize > 0 {
+		recent, err = lru.NewARC(memCacheSize)
+		if err != nil {
+			_ = spill.Close()
+			_ = os.RemoveAll(dir)
+			return nil, nil, xerrors.Errorf("create write cache: %w", err)
+		}
 	}
+
+	closer := func() error {
+		if err := spill.Close(); err != nil {
+			return err
+		}
+		return os.RemoveAll(dir)
+	}
+
+	return &proxyingBlockstore{
+		recent: recent,
+		cache:  blockstore.NewBlockstore(spill),
+		store:  backing,
+	}, closer, nil
 }
 
 type proxyingBlockstore struct {
-	cache blockstore.Blockstore
-	store blockstore.Blockstore
+	recent *lru.ARCCache // most recently written blocks, may be nil
+	cache  blockstore.Blockstore
+	store  blockstore.Blockstore
 }
 
 func (pb *proxyingBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	if pb.recent != nil {
+		if v, ok := pb.recent.Get(c); ok {
+			return v.(blocks.Block), nil
+		}
+	}
+
 	if block, err := pb.cache.Get(c); err == nil {
 		return block, err
 	}
@@ -33,6 +74,10 @@ func (pb *proxyingBlockstore) Get(c cid.Cid) (blocks.Block, error) {
 }
 
 func (pb *proxyingBlockstore) Has(c cid.Cid) (bool, error) {
+	if pb.recent != nil && pb.recent.Contains(c) {
+		return true, nil
+	}
+
 	if h, err := pb.cache.Has(c); err == nil && h {
 		return true, nil
 	}
@@ -41,10 +86,18 @@ func (pb *proxyingBlockstore) Has(c cid.Cid) (bool, error) {
 }
 
 func (pb *proxyingBlockstore) DeleteBlock(c cid.Cid) error {
+	if pb.recent != nil {
+		pb.recent.Remove(c)
+	}
 	return pb.cache.DeleteBlock(c)
 }
 
 func (pb *proxyingBlockstore) GetSize(c cid.Cid) (int, error) {
+	if pb.recent != nil {
+		if v, ok := pb.recent.Get(c); ok {
+			return len(v.(blocks.Block).RawData()), nil
+		}
+	}
 	if s, err := pb.cache.GetSize(c); err == nil {
 		return s, nil
 	}
@@ -52,13 +105,22 @@ func (pb *proxyingBlockstore) GetSize(c cid.Cid) (int, error) {
 }
 
 func (pb *proxyingBlockstore) Put(b blocks.Block) error {
-	return pb.cache.Put(b)
+	if err := pb.cache.Put(b); err != nil {
+		return err
+	}
+	if pb.recent != nil {
+		pb.recent.Add(b.Cid(), b)
+	}
+	return nil
 }
 
 func (pb *proxyingBlockstore) PutMany(bs []blocks.Block) error {
-	for _, b := range bs {
-		if err := pb.Put(b); err != nil {
-			return err
+	if err := pb.cache.PutMany(bs); err != nil {
+		return err
+	}
+	if pb.recent != nil {
+		for _, b := range bs {
+			pb.recent.Add(b.Cid(), b)
 		}
 	}
 	return nil
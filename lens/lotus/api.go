@@ -188,3 +188,12 @@ func (aw *APIWrapper) StateVMCirculatingSupplyInternal(ctx context.Context, tsk
 	defer span.End()
 	return aw.FullNode.StateVMCirculatingSupplyInternal(ctx, tsk)
 }
+
+func (aw *APIWrapper) StateCompute(ctx context.Context, height abi.ChainEpoch, msgs []*types.Message, tsk types.TipSetKey) (*api.ComputeStateOutput, error) {
+	ctx, span := global.Tracer("").Start(ctx, "Lotus.StateCompute")
+	defer span.End()
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.API, "StateCompute"))
+	stop := metrics.Timer(ctx, metrics.LensRequestDuration)
+	defer stop()
+	return aw.FullNode.StateCompute(ctx, height, msgs, tsk)
+}
@@ -0,0 +1,266 @@
+package lotus
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+)
+
+// GatewayAPIOpener opens connections to a Lotus gateway, which only serves a restricted subset of the full
+// node api. The methods offered by the gateway are detected the first time the opener is used.
+type GatewayAPIOpener struct {
+	cache   *lru.ARCCache // cache shared across all instances of the api
+	addr    string
+	headers http.Header
+
+	mu        sync.Mutex
+	available map[string]bool // methods detected as available, nil until first successful detection
+}
+
+// NewGatewayAPIOpener creates an opener for the gateway given by the --api flag. The address may be a
+// websocket or http url, a multiaddr or a <token>:<multiaddr> pair as accepted by the lotus lens.
+func NewGatewayAPIOpener(cctx *cli.Context, cacheSize int) (*GatewayAPIOpener, lens.APICloser, error) {
+	ac, err := lru.NewARC(cacheSize)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("new arc cache: %w", err)
+	}
+
+	if !cctx.IsSet("api") {
+		return nil, nil, xerrors.Errorf("cannot connect to lotus gateway: missing --api flag")
+	}
+
+	addr, token, err := parseGatewayAddr(cctx.String("api"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := http.Header{}
+	if token != "" {
+		headers = apiHeaders(token)
+	}
+
+	o := &GatewayAPIOpener{
+		cache:   ac,
+		addr:    addr,
+		headers: headers,
+	}
+
+	return o, lens.APICloser(func() {}), nil
+}
+
+func parseGatewayAddr(raw string) (string, string, error) {
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" && u.Host != "" {
+		switch u.Scheme {
+		case "http":
+			u.Scheme = "ws"
+		case "https":
+			u.Scheme = "wss"
+		case "ws", "wss":
+		default:
+			return "", "", xerrors.Errorf("unsupported gateway url scheme: %s", u.Scheme)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/rpc/v0"
+		}
+		return u.String(), "", nil
+	}
+
+	var token string
+	rawaddr := raw
+	if toks := strings.SplitN(raw, ":", 2); len(toks) == 2 && !strings.HasPrefix(raw, "/") {
+		token, rawaddr = toks[0], toks[1]
+	}
+
+	parsedAddr, err := ma.NewMultiaddr(rawaddr)
+	if err != nil {
+		return "", "", xerrors.Errorf("parse gateway address: %w", err)
+	}
+
+	_, addr, err := manet.DialArgs(parsedAddr)
+	if err != nil {
+		return "", "", xerrors.Errorf("dial multiaddress: %w", err)
+	}
+
+	return apiURI(addr), token, nil
+}
+
+func (o *GatewayAPIOpener) Open(ctx context.Context) (lens.API, lens.APICloser, error) {
+	node, closer, err := client.NewFullNodeRPC(ctx, o.addr, o.headers)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("new gateway rpc: %w", err)
+	}
+
+	available, err := o.detectMethods(ctx, node)
+	if err != nil {
+		closer()
+		return nil, nil, xerrors.Errorf("detect gateway methods: %w", err)
+	}
+
+	cacheStore, err := NewCacheCtxStore(ctx, node, o.cache)
+	if err != nil {
+		closer()
+		return nil, nil, xerrors.Errorf("new cache store: %w", err)
+	}
+
+	return &GatewayAPIWrapper{
+		APIWrapper: NewAPIWrapper(node, cacheStore),
+		available:  available,
+	}, lens.APICloser(closer), nil
+}
+
+func (o *GatewayAPIOpener) detectMethods(ctx context.Context, node api.FullNode) (map[string]bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.available != nil {
+		return o.available, nil
+	}
+
+	// The chain head is served by every gateway so any failure here means the endpoint is unusable
+	if _, err := node.ChainHead(ctx); err != nil {
+		return nil, xerrors.Errorf("chain head: %w", err)
+	}
+
+	available := map[string]bool{
+		"ChainHead":   true,
+		"ChainNotify": true, // subscriptions can't be probed cheaply but all gateways serve chain notifications
+	}
+
+	for name, probe := range gatewayProbes {
+		err := probe(ctx, node)
+		available[name] = !isMethodNotFound(err)
+		if !available[name] {
+			log.Infow("lotus gateway does not provide method", "method", name)
+		}
+	}
+
+	o.available = available
+	return available, nil
+}
+
+// isMethodNotFound reports whether err is the json-rpc error returned when calling a method that is not
+// served by the remote endpoint. go-jsonrpc does not export the error code of a response so the error is recognised
+// by the "method '<name>' not found" message it uses for the -32601 code.
+func isMethodNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "method '") && strings.Contains(msg, "not found")
+}
+
+var (
+	// probeCid refers to a block that does not exist so that probes fail quickly on endpoints that provide the method
+	probeCid, _ = cid.NewPrefixV1(cid.DagCBOR, mh.IDENTITY).Sum([]byte("visor-probe"))
+	probeTsk    = types.NewTipSetKey(probeCid)
+	probeAddr   = builtin.SystemActorAddr
+)
+
+// gatewayProbes calls each api method used by visor tasks with arguments that cannot be resolved.
+var gatewayProbes = map[string]func(context.Context, api.FullNode) error{
+	"ChainGetGenesis": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetGenesis(ctx)
+		return err
+	},
+	"ChainGetTipSet": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetTipSet(ctx, probeTsk)
+		return err
+	},
+	"ChainGetTipSetByHeight": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetTipSetByHeight(ctx, 0, probeTsk)
+		return err
+	},
+	"ChainGetBlockMessages": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetBlockMessages(ctx, probeCid)
+		return err
+	},
+	"ChainGetParentMessages": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetParentMessages(ctx, probeCid)
+		return err
+	},
+	"ChainGetParentReceipts": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainGetParentReceipts(ctx, probeCid)
+		return err
+	},
+	"ChainHasObj": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainHasObj(ctx, probeCid)
+		return err
+	},
+	"ChainReadObj": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.ChainReadObj(ctx, probeCid)
+		return err
+	},
+	"StateGetActor": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateGetActor(ctx, probeAddr, probeTsk)
+		return err
+	},
+	"StateListActors": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateListActors(ctx, probeTsk)
+		return err
+	},
+	"StateLookupID": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateLookupID(ctx, probeAddr, probeTsk)
+		return err
+	},
+	"StateChangedActors": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateChangedActors(ctx, probeCid, probeCid)
+		return err
+	},
+	"StateReadState": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateReadState(ctx, probeAddr, probeTsk)
+		return err
+	},
+	"StateGetReceipt": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateGetReceipt(ctx, probeCid, probeTsk)
+		return err
+	},
+	"StateMarketDeals": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateMarketDeals(ctx, probeTsk)
+		return err
+	},
+	"StateMinerPower": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateMinerPower(ctx, probeAddr, probeTsk)
+		return err
+	},
+	"StateMinerSectors": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateMinerSectors(ctx, probeAddr, nil, probeTsk)
+		return err
+	},
+	"StateVMCirculatingSupplyInternal": func(ctx context.Context, n api.FullNode) error {
+		_, err := n.StateVMCirculatingSupplyInternal(ctx, probeTsk)
+		return err
+	},
+}
+
+var (
+	_ lens.API                = &GatewayAPIWrapper{}
+	_ lens.CapabilityReporter = &GatewayAPIWrapper{}
+)
+
+// GatewayAPIWrapper is a lens.API backed by a Lotus gateway that reports which methods the gateway provides.
+type GatewayAPIWrapper struct {
+	*APIWrapper
+	available map[string]bool
+}
+
+// MethodAvailable reports whether the gateway was detected as providing the named method. Methods that
+// were not probed are reported as unavailable since a gateway only serves a small part of the full node api.
+func (gw *GatewayAPIWrapper) MethodAvailable(name string) bool {
+	return gw.available[name]
+}
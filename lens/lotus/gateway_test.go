@@ -0,0 +1,131 @@
+package lotus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/lotus/api/apistruct"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+)
+
+func TestParseGatewayAddr(t *testing.T) {
+	testCases := []struct {
+		raw   string
+		addr  string
+		token string
+		err   bool
+	}{
+		{raw: "wss://api.chain.love/rpc/v0", addr: "wss://api.chain.love/rpc/v0"},
+		{raw: "https://api.chain.love", addr: "wss://api.chain.love/rpc/v0"},
+		{raw: "http://localhost:2346/", addr: "ws://localhost:2346/rpc/v0"},
+		{raw: "ws://localhost:2346/rpc/v1", addr: "ws://localhost:2346/rpc/v1"},
+		{raw: "/ip4/127.0.0.1/tcp/2346", addr: "ws://127.0.0.1:2346/rpc/v0"},
+		{raw: "secret:/ip4/127.0.0.1/tcp/2346", addr: "ws://127.0.0.1:2346/rpc/v0", token: "secret"},
+		{raw: "ftp://localhost:2346", err: true},
+		{raw: "/ip4/not-an-ip/tcp/2346", err: true},
+		{raw: "secret:nonsense", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.raw, func(t *testing.T) {
+			addr, token, err := parseGatewayAddr(tc.raw)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.addr, addr)
+			assert.Equal(t, tc.token, token)
+		})
+	}
+}
+
+func TestIsMethodNotFound(t *testing.T) {
+	assert.False(t, isMethodNotFound(nil))
+	assert.False(t, isMethodNotFound(errors.New("blockstore: block not found")))
+	assert.False(t, isMethodNotFound(errors.New("resolution lookup failed (t01): actor not found")))
+	assert.True(t, isMethodNotFound(errors.New("method 'Filecoin.StateCompute' not found")))
+	assert.True(t, isMethodNotFound(fmt.Errorf("rpc call: %w", errors.New("method 'Filecoin.StateMarketDeals' not found"))))
+}
+
+// stubGateway returns a full node api whose methods fail as a gateway would: served methods fail to resolve the probe
+// arguments and other methods are not found. ChainHead succeeds unless headErr is set.
+func stubGateway(served map[string]bool, headErr error) *apistruct.FullNodeStruct {
+	var s apistruct.FullNodeStruct
+
+	internal := reflect.ValueOf(&s.Internal).Elem()
+	for i := 0; i < internal.NumField(); i++ {
+		fld := internal.Field(i)
+		if fld.Kind() != reflect.Func {
+			continue
+		}
+		name := internal.Type().Field(i).Name
+		ft := fld.Type()
+
+		var err error
+		switch {
+		case name == "ChainHead":
+			err = headErr
+		case served[name]:
+			err = errors.New("blockstore: block not found")
+		default:
+			err = fmt.Errorf("method 'Filecoin.%s' not found", name)
+		}
+
+		fld.Set(reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+			out := make([]reflect.Value, ft.NumOut())
+			for j := 0; j < ft.NumOut(); j++ {
+				out[j] = reflect.Zero(ft.Out(j))
+			}
+			if err != nil {
+				out[ft.NumOut()-1] = reflect.ValueOf(&err).Elem()
+			}
+			return out
+		}))
+	}
+
+	return &s
+}
+
+func TestDetectMethods(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("probes", func(t *testing.T) {
+		node := stubGateway(map[string]bool{"ChainGetTipSet": true, "StateGetActor": true, "StateLookupID": true}, nil)
+
+		o := &GatewayAPIOpener{}
+		available, err := o.detectMethods(ctx, node)
+		require.NoError(t, err)
+
+		for name := range gatewayProbes {
+			expected := name == "ChainGetTipSet" || name == "StateGetActor" || name == "StateLookupID"
+			assert.Equal(t, expected, available[name], name)
+		}
+		assert.True(t, available["ChainHead"])
+		assert.True(t, available["ChainNotify"])
+
+		// Detection is only done once per opener
+		again, err := o.detectMethods(ctx, stubGateway(nil, nil))
+		require.NoError(t, err)
+		assert.Equal(t, available, again)
+
+		gw := &GatewayAPIWrapper{available: available}
+		assert.True(t, gw.MethodAvailable("StateGetActor"))
+		assert.False(t, gw.MethodAvailable("StateCompute"))
+		assert.NoError(t, lens.RequireMethods(gw, "ChainHead", "ChainGetTipSet", "StateGetActor"))
+		assert.True(t, errors.Is(lens.RequireMethods(gw, "ChainHead", "StateReadState"), lens.ErrMethodUnavailable))
+	})
+
+	t.Run("unusable endpoint", func(t *testing.T) {
+		o := &GatewayAPIOpener{}
+		_, err := o.detectMethods(ctx, stubGateway(nil, errors.New("connection refused")))
+		assert.Error(t, err)
+		assert.Nil(t, o.available)
+	})
+}
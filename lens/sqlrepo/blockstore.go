@@ -13,57 +13,87 @@ import (
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multibase"
+	"golang.org/x/xerrors"
 )
 
 var errNoRows = pgx.ErrNoRows
 
-var dbpool *pgxpool.Pool
-
 var log = logging.Logger("sql")
 
-func DB(connStr string) *pgxpool.Pool {
-	if dbpool == nil {
-		var err error
-		dbpool, err = pgxpool.Connect(context.Background(), connStr)
-		if err != nil {
-			log.Fatalf("failed to connect to %s: %s", connStr, err)
-		}
+// Config holds the connection settings for a SqlBlockstore.
+type Config struct {
+	ConnString string // postgres connection string
+	PoolSize   int    // maximum number of connections in the pool, zero uses the pgx default
+	Schema     string // schema holding the blocks and heads tables, empty uses the connection's search path
+}
 
-		for _, ddl := range []string{
-			"CREATE TABLE IF NOT EXISTS blocks(" +
-				"multiHash TEXT NOT NULL PRIMARY KEY," +
-				"initialCodecID INTEGER NOT NULL," +
-				"content BYTEA NOT NULL" +
-				")",
-			"CREATE TABLE IF NOT EXISTS heads(" +
-				"seq SERIAL NOT NULL PRIMARY KEY," +
-				"ts TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL ," +
-				"height BIGINT NOT NULL," +
-				"blockCids TEXT NOT NULL" +
-				")",
-			"CREATE INDEX IF NOT EXISTS height_idx ON heads ( height )",
-		} {
-			if _, err = dbpool.Exec(context.Background(), ddl); err != nil {
-				log.Fatalf("On-connect DDL execution failed: %s", err)
-			}
-		}
+// NewBlockStore connects to the database described by cfg and ensures the tables needed by the
+// blockstore exist. The returned blockstore owns its connection pool and must be closed when no longer needed.
+func NewBlockStore(ctx context.Context, cfg Config) (*SqlBlockstore, error) {
+	pcfg, err := pgxpool.ParseConfig(cfg.ConnString)
+	if err != nil {
+		return nil, xerrors.Errorf("parse connection string: %w", err)
 	}
 
-	return dbpool
-}
+	if cfg.PoolSize > 0 {
+		pcfg.MaxConns = int32(cfg.PoolSize)
+	}
 
-type SqlBlockstore struct {
-	db *pgxpool.Pool
+	if cfg.Schema != "" {
+		pcfg.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{cfg.Schema}.Sanitize()
+	}
+
+	db, err := pgxpool.ConnectConfig(ctx, pcfg)
+	if err != nil {
+		return nil, xerrors.Errorf("connect: %w", err)
+	}
+
+	if err := ensureTables(ctx, db, cfg.Schema); err != nil {
+		db.Close()
+		return nil, xerrors.Errorf("ensure tables: %w", err)
+	}
+
+	return &SqlBlockstore{
+		db: db,
+	}, nil
 }
 
-func NewBlockStore(connstr string) (blockstore.Blockstore, error) {
+func ensureTables(ctx context.Context, db *pgxpool.Pool, schema string) error {
+	var ddls []string
+	if schema != "" {
+		ddls = append(ddls, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{schema}.Sanitize())
+	}
+
+	ddls = append(ddls,
+		"CREATE TABLE IF NOT EXISTS blocks("+
+			"multiHash TEXT NOT NULL PRIMARY KEY,"+
+			"initialCodecID INTEGER NOT NULL,"+
+			"content BYTEA NOT NULL"+
+			")",
+		"CREATE TABLE IF NOT EXISTS heads("+
+			"seq SERIAL NOT NULL PRIMARY KEY,"+
+			"ts TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL ,"+
+			"height BIGINT NOT NULL,"+
+			"blockCids TEXT NOT NULL"+
+			")",
+		"CREATE INDEX IF NOT EXISTS height_idx ON heads ( height )",
+	)
 
-	sbs := &SqlBlockstore{
-		db: DB(connstr),
+	for _, ddl := range ddls {
+		if _, err := db.Exec(ctx, ddl); err != nil {
+			return xerrors.Errorf("exec %q: %w", ddl, err)
+		}
 	}
+	return nil
+}
 
-	// we do not currently use the Identity codec, but just in case...
-	return blockstore.WrapIDStore(sbs), nil
+type SqlBlockstore struct {
+	db *pgxpool.Pool
+}
+
+// Close closes all connections used by the blockstore.
+func (sbs *SqlBlockstore) Close() {
+	sbs.db.Close()
 }
 
 func keyFromCid(c cid.Cid) (k string) {
@@ -187,31 +217,98 @@ func (sbs *SqlBlockstore) Put(b blocks.Block) (err error) {
 	return
 }
 
+// PutMany puts a slice of blocks in a single round trip to the database.
 func (sbs *SqlBlockstore) PutMany(blks []blocks.Block) error {
-	tx, err := sbs.db.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.ReadUncommitted})
+	if len(blks) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, b := range blks {
+		batch.Queue(
+			"INSERT INTO blocks( multiHash, initialCodecID, content ) VALUES( $1, $2, $3 ) ON CONFLICT (multiHash) DO NOTHING",
+			keyFromCid(b.Cid()),
+			b.Cid().Prefix().Codec,
+			b.RawData(),
+		)
+	}
+
+	ctx := context.Background()
+	tx, err := sbs.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	for _, b := range blks {
-		if err := sbs.Put(b); err != nil {
+	defer tx.Rollback(ctx)
+
+	br := tx.SendBatch(ctx, batch)
+	for range blks {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
 			return err
 		}
 	}
-	return tx.Commit(context.Background())
+	if err := br.Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetMany fetches a slice of blocks in a single query. Blocks that are not found are omitted from the result.
+func (sbs *SqlBlockstore) GetMany(ctx context.Context, cids []cid.Cid) ([]blocks.Block, error) {
+	if len(cids) == 0 {
+		return nil, nil
+	}
+
+	byKey := make(map[string]cid.Cid, len(cids))
+	keys := make([]string, 0, len(cids))
+	for _, c := range cids {
+		k := keyFromCid(c)
+		if _, exists := byKey[k]; exists {
+			continue
+		}
+		byKey[k] = c
+		keys = append(keys, k)
+	}
+
+	rows, err := sbs.db.Query(ctx, "SELECT multiHash, content FROM blocks WHERE multiHash = ANY($1)", keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]blocks.Block, 0, len(keys))
+	for rows.Next() {
+		var k string
+		var data []byte
+		if err := rows.Scan(&k, &data); err != nil {
+			return nil, err
+		}
+		b, err := blocks.NewBlockWithCid(data, byKey[k])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+
+	return out, rows.Err()
 }
 
+// getMasterTsKey returns the key of the most recently recorded tipset that is at least lookback epochs below the
+// highest tipset in the heads table. The highest tipset below that height is used when it falls on a null round.
 func (sbs *SqlBlockstore) getMasterTsKey(ctx context.Context, lookback int) (*types.TipSetKey, error) {
 
 	var headCids string
 	if err := sbs.db.QueryRow(
 		ctx,
-		"SELECT blockcids FROM heads WHERE height = -5 + ( SELECT MAX(height) FROM heads ) ORDER BY seq DESC LIMIT 1",
+		"SELECT blockcids FROM heads WHERE height <= ( SELECT MAX(height) FROM heads ) - $1 ORDER BY height DESC, seq DESC LIMIT 1",
+		lookback,
 	).Scan(&headCids); err != nil {
 		return nil, err
 	}
 
 	cidStrs := strings.Split(headCids, " ")
-	cids := make([]cid.Cid, len(cidStrs))
+	cids := make([]cid.Cid, 0, len(cidStrs))
 	for _, cs := range cidStrs {
 		c, err := cid.Parse(cs)
 		if err != nil {
@@ -0,0 +1,92 @@
+package sqlrepo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/lib/blockstore"
+	"github.com/go-pg/pg/v10"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+// testSchema needs quoting so it checks the schema is used as an identifier everywhere
+const testSchema = "Sqlrepo Test"
+
+func TestSqlBlockstore(t *testing.T) {
+	if testing.Short() || !testutil.DatabaseAvailable() {
+		t.Skip("short testing requested or VISOR_TEST_DB not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	dropSchema := func() {
+		_, err := db.ExecContext(ctx, `DROP SCHEMA IF EXISTS "`+testSchema+`" CASCADE`)
+		require.NoError(t, err)
+	}
+	dropSchema()
+	defer dropSchema()
+
+	cfg := Config{
+		ConnString: testutil.DatabaseOptions(),
+		PoolSize:   2,
+		Schema:     testSchema,
+	}
+
+	sbs, err := NewBlockStore(ctx, cfg)
+	require.NoError(t, err)
+	defer sbs.Close()
+
+	t.Run("tables in schema", func(t *testing.T) {
+		var count int
+		_, err := db.QueryOneContext(ctx, pg.Scan(&count), `SELECT count(*) FROM information_schema.tables WHERE table_schema = ? AND table_name IN ('blocks', 'heads')`, testSchema)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		// Opening again must not fail on the existing tables
+		again, err := NewBlockStore(ctx, cfg)
+		require.NoError(t, err)
+		again.Close()
+	})
+
+	b1 := blocks.NewBlock([]byte("block one"))
+	b2 := blocks.NewBlock([]byte("block two"))
+	b3 := blocks.NewBlock([]byte("block three"))
+	missing := blocks.NewBlock([]byte("not stored"))
+
+	t.Run("put many", func(t *testing.T) {
+		require.NoError(t, sbs.PutMany(nil))
+		require.NoError(t, sbs.PutMany([]blocks.Block{b1, b2}))
+		// Blocks that are already stored are ignored
+		require.NoError(t, sbs.PutMany([]blocks.Block{b2, b3}))
+
+		for _, b := range []blocks.Block{b1, b2, b3} {
+			got, err := sbs.Get(b.Cid())
+			require.NoError(t, err)
+			assert.Equal(t, b.RawData(), got.RawData())
+		}
+
+		_, err := sbs.Get(missing.Cid())
+		assert.Equal(t, blockstore.ErrNotFound, err)
+	})
+
+	t.Run("get many", func(t *testing.T) {
+		got, err := sbs.GetMany(ctx, nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+
+		got, err = sbs.GetMany(ctx, []cid.Cid{b1.Cid(), missing.Cid(), b3.Cid(), b1.Cid()})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []blocks.Block{b1, b3}, got)
+	})
+}
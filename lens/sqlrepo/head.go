@@ -0,0 +1,72 @@
+package sqlrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"golang.org/x/xerrors"
+)
+
+// headFollower polls the heads table and advances the head of a chainstore as new tipsets are recorded.
+// Setting the head of the chainstore emits head change notifications to any ChainNotify subscribers.
+type headFollower struct {
+	sbs      *SqlBlockstore
+	cs       *store.ChainStore
+	lookback int           // number of epochs below the highest recorded tipset to use as the head
+	interval time.Duration // time to wait between polls of the heads table
+}
+
+// advance moves the chainstore head to the highest tipset at least lookback epochs behind the highest tipset in the
+// heads table, if it differs from the current head. It reports whether the head was changed.
+func (hf *headFollower) advance(ctx context.Context) (bool, error) {
+	headKey, err := hf.sbs.getMasterTsKey(ctx, hf.lookback)
+	if err != nil {
+		return false, xerrors.Errorf("get head key: %w", err)
+	}
+
+	if current := hf.cs.GetHeaviestTipSet(); current != nil && current.Key() == *headKey {
+		return false, nil
+	}
+
+	headTs, err := hf.cs.LoadTipSet(*headKey)
+	if err != nil {
+		return false, xerrors.Errorf("load head tipset: %w", err)
+	}
+
+	if err := hf.cs.SetHead(headTs); err != nil {
+		return false, xerrors.Errorf("set head: %w", err)
+	}
+
+	return true, nil
+}
+
+// run polls for new heads until the context is done.
+func (hf *headFollower) run(ctx context.Context) {
+	ticker := time.NewTicker(hf.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := hf.advance(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Errorw("failed to advance sql lens head", "error", err.Error())
+				continue
+			}
+			if changed {
+				log.Debugw("advanced sql lens head", "height", int64(hf.head().Height()))
+			}
+		}
+	}
+}
+
+func (hf *headFollower) head() *types.TipSet {
+	return hf.cs.GetHeaviestTipSet()
+}
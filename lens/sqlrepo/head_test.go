@@ -0,0 +1,112 @@
+package sqlrepo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/lib/blockstore"
+	"github.com/go-pg/pg/v10"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestHeadFollowerAdvance(t *testing.T) {
+	if testing.Short() || !testutil.DatabaseAvailable() {
+		t.Skip("short testing requested or VISOR_TEST_DB not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	dropSchema := func() {
+		_, err := db.ExecContext(ctx, `DROP SCHEMA IF EXISTS "`+testSchema+`" CASCADE`)
+		require.NoError(t, err)
+	}
+	dropSchema()
+	defer dropSchema()
+
+	sbs, err := NewBlockStore(ctx, Config{
+		ConnString: testutil.DatabaseOptions(),
+		PoolSize:   2,
+		Schema:     testSchema,
+	})
+	require.NoError(t, err)
+	defer sbs.Close()
+
+	// recordHead stores the blocks of a tipset and records it in the heads table as a watcher of the chain would
+	recordHead := func(ts *types.TipSet) {
+		var blks []blocks.Block
+		var cids []string
+		for _, bh := range ts.Blocks() {
+			sb, err := bh.ToStorageBlock()
+			require.NoError(t, err)
+			blks = append(blks, sb)
+			cids = append(cids, bh.Cid().String())
+		}
+		require.NoError(t, sbs.PutMany(blks))
+
+		_, err := db.ExecContext(ctx, `INSERT INTO ?.heads (height, blockcids) VALUES (?, ?)`, pg.Ident(testSchema), int64(ts.Height()), strings.Join(cids, " "))
+		require.NoError(t, err)
+	}
+
+	genesis := mock.TipSet(mock.MkBlock(nil, 1, 1))
+	ts1 := mock.TipSet(mock.MkBlock(genesis, 1, 1))
+	// Height 2 is a null round
+	b3 := mock.MkBlock(ts1, 1, 1)
+	b3.Height = 3
+	ts3 := mock.TipSet(b3)
+
+	recordHead(genesis)
+	recordHead(ts1)
+
+	cs := store.NewChainStore(blockstore.WrapIDStore(sbs), dssync.MutexWrap(datastore.NewMapDatastore()), vm.Syscalls(&fakeVerifier{}), journal.NilJournal())
+	hf := &headFollower{
+		sbs:      sbs,
+		cs:       cs,
+		lookback: 1,
+	}
+
+	t.Run("advance", func(t *testing.T) {
+		changed, err := hf.advance(ctx)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, genesis.Key(), hf.head().Key())
+	})
+
+	t.Run("unchanged head", func(t *testing.T) {
+		changed, err := hf.advance(ctx)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, genesis.Key(), hf.head().Key())
+	})
+
+	t.Run("null round", func(t *testing.T) {
+		recordHead(ts3)
+
+		// The lookback height of 2 is a null round so the tipset below it is used
+		changed, err := hf.advance(ctx)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, ts1.Key(), hf.head().Key())
+
+		changed, err = hf.advance(ctx)
+		require.NoError(t, err)
+		assert.False(t, changed)
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/filecoin-project/lotus/chain/vm"
 	"github.com/filecoin-project/lotus/extern/sector-storage/ffiwrapper"
 	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/lib/blockstore"
 	"github.com/filecoin-project/lotus/lib/cachebs"
 	"github.com/filecoin-project/lotus/lib/ulimit"
 	marketevents "github.com/filecoin-project/lotus/markets/loggers"
@@ -42,35 +43,53 @@ func NewAPIOpener(c *cli.Context) (*APIOpener, lens.APICloser, error) {
 		return nil, nil, fmt.Errorf("setting file descriptor limit: %s", err)
 	}
 
-	bs, err := NewBlockStore(c.String("repo"))
+	if c.Int("lens-sql-lookback") < 0 {
+		return nil, nil, fmt.Errorf("lens-sql-lookback must not be negative")
+	}
+
+	if c.Duration("lens-sql-poll-interval") <= 0 {
+		return nil, nil, fmt.Errorf("lens-sql-poll-interval must be positive")
+	}
+
+	sbs, err := NewBlockStore(c.Context, Config{
+		ConnString: c.String("repo"),
+		PoolSize:   c.Int("lens-sql-pool-size"),
+		Schema:     c.String("lens-sql-schema"),
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("new sql blockstore: %w", err)
 	}
 
+	// we do not currently use the Identity codec, but just in case...
+	bs := blockstore.WrapIDStore(sbs)
+
 	r := repo.NewMemory(nil)
 
 	lr, err := r.Lock(repo.FullNode)
 	if err != nil {
+		sbs.Close()
 		return nil, nil, err
 	}
 
 	mds, err := lr.Datastore("/metadata")
 	if err != nil {
+		lr.Close()
+		sbs.Close()
 		return nil, nil, err
 	}
 
 	cs := store.NewChainStore(bs, mds, vm.Syscalls(&fakeVerifier{}), journal.NilJournal())
 
-	headKey, err := bs.(*SqlBlockstore).getMasterTsKey(c.Context, safetyLookBack)
-	if err != nil {
-		return nil, nil, err
+	hf := &headFollower{
+		sbs:      sbs,
+		cs:       cs,
+		lookback: c.Int("lens-sql-lookback"),
+		interval: c.Duration("lens-sql-poll-interval"),
 	}
 
-	headTs, err := cs.LoadTipSet(*headKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load our own chainhead: %w", err)
-	}
-	if err := cs.SetHead(headTs); err != nil {
+	if _, err := hf.advance(c.Context); err != nil {
+		lr.Close()
+		sbs.Close()
 		return nil, nil, fmt.Errorf("failed to set our own chainhead: %w", err)
 	}
 
@@ -82,8 +101,13 @@ func NewAPIOpener(c *cli.Context) (*APIOpener, lens.APICloser, error) {
 	rapi.FullNodeAPI.StateAPI.StateManager = sm
 	rapi.FullNodeAPI.StateAPI.StateModuleAPI = &full.StateModule{Chain: cs, StateManager: sm}
 
+	followCtx, cancelFollow := context.WithCancel(c.Context)
+	go hf.run(followCtx)
+
 	sf := func() {
+		cancelFollow()
 		lr.Close()
+		sbs.Close()
 	}
 
 	rapi.Context = c.Context
@@ -193,8 +217,6 @@ func (ra *SQLAPI) ClientRetrieveTryRestartInsufficientFunds(ctx context.Context,
 	return fmt.Errorf("unsupported")
 }
 
-const safetyLookBack = 5
-
 // From https://github.com/ribasushi/ltsh/blob/5b0211033020570217b0ae37b50ee304566ac218/cmd/lotus-shed/deallifecycles.go#L41-L171
 type fakeVerifier struct{}
 
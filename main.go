@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/urfave/cli/v2"
@@ -26,11 +27,13 @@ func main() {
 				Name:    "lens",
 				EnvVars: []string{"VISOR_LENS"},
 				Value:   "lotus",
+				Usage:   "Source of chain data, one of lotus, gateway, lotusrepo, carrepo or sql",
 			},
 			&cli.StringFlag{
 				Name:    "repo",
 				EnvVars: []string{"LOTUS_PATH"},
 				Value:   "~/.lotus", // TODO: Consider XDG_DATA_HOME
+				Usage:   "Path to the lotus repo, or a comma separated list of car files or glob patterns when using the carrepo lens",
 			},
 			&cli.StringFlag{
 				Name:    "api",
@@ -47,11 +50,47 @@ func main() {
 				EnvVars: []string{"LOTUS_DB_POOL_SIZE"},
 				Value:   75,
 			},
+			&cli.StringFlag{
+				Name:    "db-schema",
+				EnvVars: []string{"LOTUS_DB_SCHEMA"},
+				Value:   "public",
+				Usage:   "Postgres schema to use for all tables, migrations and locks, allowing several networks to share a database. Must be a lowercase identifier of letters, digits and underscores",
+			},
 			&cli.IntFlag{
 				Name:    "lens-cache-hint",
 				EnvVars: []string{"VISOR_LENS_CACHE_HINT"},
 				Value:   1024 * 1024,
 			},
+			&cli.IntFlag{
+				Name:    "lens-write-cache",
+				EnvVars: []string{"VISOR_LENS_WRITE_CACHE"},
+				Value:   16 * 1024,
+				Usage:   "Maximum number of recently written blocks the carrepo lens holds in memory, older writes are kept on disk",
+			},
+			&cli.IntFlag{
+				Name:    "lens-sql-lookback",
+				EnvVars: []string{"VISOR_LENS_SQL_LOOKBACK"},
+				Value:   5,
+				Usage:   "Number of epochs behind the highest recorded tipset that the sql lens uses as its head",
+			},
+			&cli.IntFlag{
+				Name:    "lens-sql-pool-size",
+				EnvVars: []string{"VISOR_LENS_SQL_POOL_SIZE"},
+				Value:   0,
+				Usage:   "Maximum number of database connections used by the sql lens, zero uses the driver default",
+			},
+			&cli.StringFlag{
+				Name:    "lens-sql-schema",
+				EnvVars: []string{"VISOR_LENS_SQL_SCHEMA"},
+				Value:   "",
+				Usage:   "Database schema holding the blocks and heads tables read by the sql lens",
+			},
+			&cli.DurationFlag{
+				Name:    "lens-sql-poll-interval",
+				EnvVars: []string{"VISOR_LENS_SQL_POLL_INTERVAL"},
+				Value:   5 * time.Second,
+				Usage:   "Time to wait between checks for new heads by the sql lens",
+			},
 			&cli.StringFlag{
 				Name:    "log-level",
 				EnvVars: []string{"GOLOG_LOG_LEVEL"},
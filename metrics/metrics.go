@@ -17,6 +17,7 @@ var (
 	ConnState, _ = tag.NewKey("conn_state")
 	State, _     = tag.NewKey("state")
 	API, _       = tag.NewKey("api")
+	Table, _     = tag.NewKey("table")
 )
 
 var (
@@ -29,6 +30,8 @@ var (
 	EpochsToSync            = stats.Int64("epochs_to_sync", "Epochs yet to sync", stats.UnitDimensionless)
 	LensRequestDuration     = stats.Float64("lens_request_duration_ms", "Duration of lotus api requets", stats.UnitMilliseconds)
 	TipsetHeight            = stats.Int64("tipset_height", "The height of the tipset being processed", stats.UnitDimensionless)
+	RetentionRowsDeleted    = stats.Int64("retention_rows_deleted", "Rows deleted by retention policies", stats.UnitDimensionless)
+	RetentionChunksDropped  = stats.Int64("retention_chunks_dropped", "Hypertable chunks dropped by retention policies", stats.UnitDimensionless)
 )
 
 var (
@@ -75,6 +78,16 @@ var (
 		Aggregation: view.LastValue(),
 		TagKeys:     []tag.Key{TaskType},
 	}
+	RetentionRowsDeletedView = &view.View{
+		Measure:     RetentionRowsDeleted,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{Table},
+	}
+	RetentionChunksDroppedView = &view.View{
+		Measure:     RetentionChunksDropped,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{Table},
+	}
 )
 
 var DefaultViews = append([]*view.View{
@@ -87,6 +100,8 @@ var DefaultViews = append([]*view.View{
 	EpochsToSyncView,
 	LensRequestDurationView,
 	TipsetHeightView,
+	RetentionRowsDeletedView,
+	RetentionChunksDroppedView,
 })
 
 // SinceInMilliseconds returns the duration of time since the provide time as a float64.
@@ -45,3 +45,53 @@ func (s *ActorState) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
 	}
 	return nil
 }
+
+// MaxStateDiffDepth is the greatest number of diffs that must be applied to a full state to obtain an actor state
+// stored as a diff. A state that would exceed it is stored in full.
+const MaxStateDiffDepth = 32
+
+// ActorStateContent is the raw state of an actor stored once for each distinct head. When Diff is set the state may
+// be stored as a diff against the state of BaseHead instead of in full.
+type ActorStateContent struct {
+	Head      string `pg:",pk,notnull"`
+	Code      string `pg:",notnull"`
+	Height    int64  `pg:",notnull,use_zero"`
+	State     string `pg:",type:jsonb"`
+	BaseHead  string
+	Diff      string `pg:",type:jsonb"`
+	DiffDepth int64  `pg:",notnull,use_zero"`
+}
+
+func (s *ActorStateContent) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	ctx, span := global.Tracer("").Start(ctx, "ActorStateContent.PersistWithTx")
+	defer span.End()
+
+	// The diff can only be stored if the base state is already stored and the chain of diffs is not too long
+	if s.Diff != "" && s.BaseHead != "" {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO actor_state_contents (head, code, height, base_head, diff, diff_depth)
+SELECT ?, ?, ?, head, ?, diff_depth + 1
+FROM actor_state_contents
+WHERE head = ? AND diff_depth < ?
+ON CONFLICT DO NOTHING`, s.Head, s.Code, s.Height, s.Diff, s.BaseHead, MaxStateDiffDepth)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() > 0 {
+			return nil
+		}
+	}
+
+	full := &ActorStateContent{
+		Head:   s.Head,
+		Code:   s.Code,
+		Height: s.Height,
+		State:  s.State,
+	}
+	if _, err := tx.ModelContext(ctx, full).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return err
+	}
+	return nil
+}
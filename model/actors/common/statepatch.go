@@ -0,0 +1,149 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"golang.org/x/xerrors"
+)
+
+// CreateStatePatch returns a JSON merge patch (RFC 7386) that transforms the JSON state from into the state to. It
+// returns false if the states are not both objects or if the change cannot be expressed as a merge patch, which
+// happens when a member of an object is set to null since null removes members in a merge patch.
+func CreateStatePatch(from, to []byte) ([]byte, bool, error) {
+	fromValue, err := decodeState(from)
+	if err != nil {
+		return nil, false, xerrors.Errorf("decode from state: %w", err)
+	}
+	toValue, err := decodeState(to)
+	if err != nil {
+		return nil, false, xerrors.Errorf("decode to state: %w", err)
+	}
+
+	fromObj, ok := fromValue.(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	toObj, ok := toValue.(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	patch, ok := objectPatch(fromObj, toObj)
+	if !ok {
+		return nil, false, nil
+	}
+
+	out, err := json.Marshal(patch)
+	if err != nil {
+		return nil, false, xerrors.Errorf("encode patch: %w", err)
+	}
+	return out, true, nil
+}
+
+// ApplyStatePatch applies a JSON merge patch created by CreateStatePatch to a JSON state.
+func ApplyStatePatch(state, patch []byte) ([]byte, error) {
+	stateValue, err := decodeState(state)
+	if err != nil {
+		return nil, xerrors.Errorf("decode state: %w", err)
+	}
+	patchValue, err := decodeState(patch)
+	if err != nil {
+		return nil, xerrors.Errorf("decode patch: %w", err)
+	}
+
+	out, err := json.Marshal(mergePatch(stateValue, patchValue))
+	if err != nil {
+		return nil, xerrors.Errorf("encode state: %w", err)
+	}
+	return out, nil
+}
+
+// decodeState decodes JSON keeping numbers in their original form so large integers are not rounded
+func decodeState(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// objectPatch returns the patch transforming object from into object to and false if that requires setting a member
+// to null.
+func objectPatch(from, to map[string]interface{}) (map[string]interface{}, bool) {
+	patch := map[string]interface{}{}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			patch[k] = nil
+		}
+	}
+
+	for k, tv := range to {
+		fv, exists := from[k]
+		if exists && reflect.DeepEqual(fv, tv) {
+			continue
+		}
+
+		tObj, tIsObj := tv.(map[string]interface{})
+		fObj, fIsObj := fv.(map[string]interface{})
+		if exists && tIsObj && fIsObj {
+			sub, ok := objectPatch(fObj, tObj)
+			if !ok {
+				return nil, false
+			}
+			patch[k] = sub
+			continue
+		}
+
+		// Replaced values are merged into the target as patches so they must not contain null members
+		if hasNullMember(tv) {
+			return nil, false
+		}
+		patch[k] = tv
+	}
+
+	return patch, true
+}
+
+// hasNullMember reports whether v is null or an object with a null member at any depth. Arrays replace the target
+// verbatim so nulls within them are preserved.
+func hasNullMember(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, mv := range obj {
+		if hasNullMember(mv) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatch applies patch to target as described in RFC 7386
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for k, pv := range patchObj {
+		if pv == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], pv)
+	}
+	return targetObj
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePatch(t *testing.T) {
+	testCases := []struct {
+		name  string
+		from  string
+		to    string
+		patch string // expected patch, empty if the change cannot be expressed as a patch
+	}{
+		{
+			name:  "unchanged",
+			from:  `{"a":1,"b":{"c":"x"}}`,
+			to:    `{"a":1,"b":{"c":"x"}}`,
+			patch: `{}`,
+		},
+		{
+			name:  "changed member",
+			from:  `{"a":1,"b":"x"}`,
+			to:    `{"a":2,"b":"x"}`,
+			patch: `{"a":2}`,
+		},
+		{
+			name:  "nested member",
+			from:  `{"a":{"b":{"c":1,"d":2}},"e":3}`,
+			to:    `{"a":{"b":{"c":1,"d":5}},"e":3}`,
+			patch: `{"a":{"b":{"d":5}}}`,
+		},
+		{
+			name:  "added and removed members",
+			from:  `{"a":1,"b":2}`,
+			to:    `{"a":1,"c":3}`,
+			patch: `{"b":null,"c":3}`,
+		},
+		{
+			name:  "array replaced",
+			from:  `{"a":[1,2,3]}`,
+			to:    `{"a":[1,null,3]}`,
+			patch: `{"a":[1,null,3]}`,
+		},
+		{
+			name:  "large integer",
+			from:  `{"a":"1","b":123456789012345678901234567890}`,
+			to:    `{"a":"1","b":123456789012345678901234567891}`,
+			patch: `{"b":123456789012345678901234567891}`,
+		},
+		{
+			name: "member set to null",
+			from: `{"a":{"b":1}}`,
+			to:   `{"a":null}`,
+		},
+		{
+			name: "object with null replaces scalar",
+			from: `{"a":1}`,
+			to:   `{"a":{"b":null}}`,
+		},
+		{
+			name: "not an object",
+			from: `{"a":1}`,
+			to:   `[1]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, ok, err := CreateStatePatch([]byte(tc.from), []byte(tc.to))
+			require.NoError(t, err)
+			if tc.patch == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.JSONEq(t, tc.patch, string(patch))
+
+			// Applying the patch recovers the new state
+			state, err := ApplyStatePatch([]byte(tc.from), patch)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.to, string(state))
+		})
+	}
+}
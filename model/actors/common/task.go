@@ -10,8 +10,9 @@ import (
 )
 
 type ActorTaskResult struct {
-	Actor *Actor
-	State *ActorState
+	Actor   *Actor
+	State   *ActorState        // raw state stored for every height, nil when stored by content
+	Content *ActorStateContent // raw state stored once per head, nil when stored for every height
 }
 
 func (a *ActorTaskResult) Persist(ctx context.Context, db *pg.DB) error {
@@ -25,8 +26,15 @@ func (a *ActorTaskResult) Persist(ctx context.Context, db *pg.DB) error {
 		if err := a.Actor.PersistWithTx(ctx, tx); err != nil {
 			return err
 		}
-		if err := a.State.PersistWithTx(ctx, tx); err != nil {
-			return err
+		if a.State != nil {
+			if err := a.State.PersistWithTx(ctx, tx); err != nil {
+				return err
+			}
+		}
+		if a.Content != nil {
+			if err := a.Content.PersistWithTx(ctx, tx); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
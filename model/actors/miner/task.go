@@ -4,15 +4,12 @@ import (
 	"context"
 
 	"github.com/go-pg/pg/v10"
-	"github.com/ipfs/go-cid"
 	"go.opentelemetry.io/otel/api/global"
 
 	"github.com/filecoin-project/sentinel-visor/metrics"
 )
 
 type MinerTaskResult struct {
-	Posts map[uint64]cid.Cid
-
 	MinerInfoModel           *MinerInfo
 	FeeDebtModel             *MinerFeeDebt
 	LockedFundsModel         *MinerLockedFund
@@ -21,6 +18,9 @@ type MinerTaskResult struct {
 	SectorsModel             MinerSectorInfoList
 	SectorEventsModel        MinerSectorEventList
 	SectorDealsModel         MinerSectorDealList
+	SectorPostsModel         MinerSectorPostList
+	PartitionPostsModel      MinerPartitionPostList
+	PartitionFaultsModel     MinerPartitionFaultList
 }
 
 func (res *MinerTaskResult) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
@@ -64,6 +64,21 @@ func (res *MinerTaskResult) PersistWithTx(ctx context.Context, tx *pg.Tx) error
 			return err
 		}
 	}
+	if len(res.SectorPostsModel) > 0 {
+		if err := res.SectorPostsModel.PersistWithTx(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if len(res.PartitionPostsModel) > 0 {
+		if err := res.PartitionPostsModel.PersistWithTx(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if len(res.PartitionFaultsModel) > 0 {
+		if err := res.PartitionFaultsModel.PersistWithTx(ctx, tx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
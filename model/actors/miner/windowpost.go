@@ -0,0 +1,77 @@
+package miner
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+)
+
+// MinerPartitionPost records a window PoSt for a partition of one of a miner's deadlines. A row is recorded at the
+// height a PoSt for the partition was successfully submitted, or at the height the deadline closed if no PoSt was
+// submitted for the partition, in which case PostMessageCID is empty and OnTime is false. A submitted PoSt is on time when
+// it proves the deadline that was open when its message was executed.
+type MinerPartitionPost struct {
+	Height         int64  `pg:",pk,notnull,use_zero"`
+	MinerID        string `pg:",pk,notnull"`
+	DeadlineIndex  uint64 `pg:",pk,notnull,use_zero"`
+	PartitionIndex uint64 `pg:",pk,notnull,use_zero"`
+	StateRoot      string `pg:",notnull"`
+
+	PeriodStart    int64 `pg:",notnull,use_zero"`
+	OnTime         bool  `pg:",notnull,use_zero"`
+	PostMessageCID string
+	ProvenSectors  int64  `pg:",notnull,use_zero"`
+	SkippedSectors string `pg:",type:jsonb"`
+}
+
+type MinerPartitionPostList []*MinerPartitionPost
+
+func (ml MinerPartitionPostList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	ctx, span := global.Tracer("").Start(ctx, "MinerPartitionPostList.PersistWithTx", trace.WithAttributes(label.Int("count", len(ml))))
+	defer span.End()
+	if len(ml) == 0 {
+		return nil
+	}
+	if _, err := tx.ModelContext(ctx, &ml).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting miner partition post list: %w", err)
+	}
+	return nil
+}
+
+// MinerPartitionFault records the sectors of a partition whose fault status changed at a height. Faults recorded when
+// DeadlineClosed is true were detected by the deadline closing rather than declared by the miner.
+type MinerPartitionFault struct {
+	Height         int64  `pg:",pk,notnull,use_zero"`
+	MinerID        string `pg:",pk,notnull"`
+	DeadlineIndex  uint64 `pg:",pk,notnull,use_zero"`
+	PartitionIndex uint64 `pg:",pk,notnull,use_zero"`
+	StateRoot      string `pg:",notnull"`
+
+	DeadlineClosed bool   `pg:",notnull,use_zero"`
+	Faulted        string `pg:",type:jsonb"`
+	Recovering     string `pg:",type:jsonb"`
+	Recovered      string `pg:",type:jsonb"`
+	Removed        string `pg:",type:jsonb"`
+}
+
+type MinerPartitionFaultList []*MinerPartitionFault
+
+func (ml MinerPartitionFaultList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	ctx, span := global.Tracer("").Start(ctx, "MinerPartitionFaultList.PersistWithTx", trace.WithAttributes(label.Int("count", len(ml))))
+	defer span.End()
+	if len(ml) == 0 {
+		return nil
+	}
+	if _, err := tx.ModelContext(ctx, &ml).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting miner partition fault list: %w", err)
+	}
+	return nil
+}
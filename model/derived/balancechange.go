@@ -0,0 +1,48 @@
+package derived
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// BalanceChange is a change to the balance of an actor between the state of the parent tipset and the state at
+// Height. MessageCid is the message that caused the change when it was the only executed message that could have.
+type BalanceChange struct {
+	tableName  struct{} `pg:"derived_balance_changes"`
+	Height     int64    `pg:",pk,use_zero,notnull"`
+	Address    string   `pg:",pk,notnull"`
+	StateRoot  string   `pg:",notnull"`
+	OldBalance string   `pg:",type:numeric,notnull"`
+	NewBalance string   `pg:",type:numeric,notnull"`
+	Delta      string   `pg:",type:numeric,notnull"`
+	MessageCid string
+}
+
+type BalanceChangeList []*BalanceChange
+
+func (l BalanceChangeList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "BalanceChangeList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "balancechanges"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting derived balance changes: %w", err)
+	}
+	return nil
+}
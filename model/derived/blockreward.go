@@ -0,0 +1,50 @@
+package derived
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// BlockReward is the reward earned by the miner of a block, made up of the block reward for its winning tickets and
+// the tips of the messages it was the first block in its tipset to include, less any penalties.
+type BlockReward struct {
+	tableName      struct{} `pg:"derived_block_rewards"`
+	Height         int64    `pg:",pk,use_zero,notnull"`
+	Cid            string   `pg:",pk,notnull"`
+	Miner          string   `pg:",notnull"`
+	StateRoot      string   `pg:",notnull"`
+	WinCount       int64    `pg:",use_zero,notnull"`
+	MessageCount   int64    `pg:",use_zero,notnull"`
+	BlockReward    string   `pg:",type:numeric,notnull"`
+	MinerTips      string   `pg:",type:numeric,notnull"`
+	MinerPenalties string   `pg:",type:numeric,notnull"`
+}
+
+type BlockRewardList []*BlockReward
+
+func (l BlockRewardList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "BlockRewardList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "blockrewards"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting derived block rewards: %w", err)
+	}
+	return nil
+}
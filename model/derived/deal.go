@@ -0,0 +1,87 @@
+package derived
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+const (
+	DealStatusPublished = "published"
+	DealStatusActive    = "active"
+	DealStatusSlashed   = "slashed"
+	DealStatusExpired   = "expired"
+)
+
+// Deal is the lifecycle of a storage deal, derived from market deal proposals and states and miner sector deals.
+// A deal that expired without ever being activated has no activation height.
+type Deal struct {
+	tableName        struct{} `pg:"derived_deals"`
+	DealID           uint64   `pg:",pk,use_zero"`
+	ClientID         string   `pg:",notnull"`
+	ProviderID       string   `pg:",notnull"`
+	PieceCID         string   `pg:",notnull"`
+	PaddedPieceSize  uint64   `pg:",use_zero,notnull"`
+	IsVerified       bool     `pg:",use_zero,notnull"`
+	StartEpoch       int64    `pg:",use_zero,notnull"`
+	EndEpoch         int64    `pg:",use_zero,notnull"`
+	Status           string   `pg:",notnull"`
+	PublishedHeight  int64    `pg:",use_zero,notnull"`
+	ActivationHeight int64
+	SectorID         *uint64
+	SlashHeight      int64
+	ExpirationHeight int64
+	LastUpdateHeight int64 `pg:",use_zero,notnull"`
+}
+
+type DealList []*Deal
+
+// PersistWithTx inserts the deals, replacing the existing state of any deal that is already known.
+func (l DealList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "DealList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "deals"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("(deal_id) DO UPDATE").
+		Set("status = EXCLUDED.status").
+		Set("activation_height = EXCLUDED.activation_height").
+		Set("sector_id = EXCLUDED.sector_id").
+		Set("slash_height = EXCLUDED.slash_height").
+		Set("expiration_height = EXCLUDED.expiration_height").
+		Set("last_update_height = EXCLUDED.last_update_height").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting derived deals: %w", err)
+	}
+	return nil
+}
+
+// ExpireDeals marks deals that have passed their end epoch, or their start epoch without being activated, at or
+// before height as expired.
+func ExpireDeals(ctx context.Context, tx *pg.Tx, height int64) (int, error) {
+	ctx, span := global.Tracer("").Start(ctx, "ExpireDeals")
+	defer span.End()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE derived_deals
+SET status = ?, expiration_height = CASE WHEN status = ? THEN end_epoch ELSE start_epoch END, last_update_height = ?
+WHERE (status = ? AND end_epoch <= ?) OR (status = ? AND start_epoch <= ?)
+`, DealStatusExpired, DealStatusActive, height, DealStatusActive, height, DealStatusPublished, height)
+	if err != nil {
+		return 0, xerrors.Errorf("expiring derived deals: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
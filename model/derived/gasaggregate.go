@@ -0,0 +1,51 @@
+package derived
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// GasAggregate is the gas used and fees paid by the messages in a tipset that were sent to one method of one
+// type of actor.
+type GasAggregate struct {
+	tableName          struct{} `pg:"derived_gas_aggregates"`
+	Height             int64    `pg:",pk,use_zero,notnull"`
+	StateRoot          string   `pg:",pk,notnull"`
+	ActorCode          string   `pg:",pk,notnull"`
+	Method             int64    `pg:",pk,use_zero,notnull"`
+	MethodName         string
+	MessageCount       int64  `pg:",use_zero,notnull"`
+	GasUsed            int64  `pg:",use_zero,notnull"`
+	BaseFeeBurn        string `pg:",type:numeric,notnull"`
+	OverEstimationBurn string `pg:",type:numeric,notnull"`
+	MinerTip           string `pg:",type:numeric,notnull"`
+}
+
+type GasAggregateList []*GasAggregate
+
+func (l GasAggregateList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "GasAggregateList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "gasaggregates"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting derived gas aggregates: %w", err)
+	}
+	return nil
+}
@@ -33,6 +33,8 @@ type GasOutputs struct {
 	Refund             string   `pg:",notnull"`
 	GasRefund          int64    `pg:",use_zero,notnull"`
 	GasBurned          int64    `pg:",use_zero,notnull"`
+	ActorCode          string
+	MethodName         string
 }
 
 func (g *GasOutputs) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
@@ -0,0 +1,71 @@
+package derived
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+const (
+	SectorStatusPreCommitted     = "precommitted"
+	SectorStatusPreCommitExpired = "precommit_expired"
+	SectorStatusActive           = "active"
+	SectorStatusFaulted          = "faulted"
+	SectorStatusRecovering       = "recovering"
+	SectorStatusExpired          = "expired"
+	SectorStatusTerminated       = "terminated"
+)
+
+// MinerSector is the current state of a miner's sector, derived from its sector events.
+type MinerSector struct {
+	tableName         struct{} `pg:"derived_miner_sectors"`
+	MinerID           string   `pg:",pk,notnull"`
+	SectorID          uint64   `pg:",pk,use_zero"`
+	Status            string   `pg:",notnull"`
+	PrecommitHeight   int64
+	ActivationHeight  int64
+	ExpirationHeight  int64
+	TerminationHeight int64
+	FaultCount        int64  `pg:",use_zero,notnull"`
+	DealIDs           string `pg:"deal_ids,type:jsonb"`
+	LastEvent         string `pg:",notnull"`
+	LastEventHeight   int64  `pg:",use_zero,notnull"`
+}
+
+type MinerSectorList []*MinerSector
+
+// PersistWithTx inserts the sectors, replacing the existing state of any sector that is already known.
+func (l MinerSectorList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "MinerSectorList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "minersectors"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("(miner_id, sector_id) DO UPDATE").
+		Set("status = EXCLUDED.status").
+		Set("precommit_height = EXCLUDED.precommit_height").
+		Set("activation_height = EXCLUDED.activation_height").
+		Set("expiration_height = EXCLUDED.expiration_height").
+		Set("termination_height = EXCLUDED.termination_height").
+		Set("fault_count = EXCLUDED.fault_count").
+		Set("deal_ids = EXCLUDED.deal_ids").
+		Set("last_event = EXCLUDED.last_event").
+		Set("last_event_height = EXCLUDED.last_event_height").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting derived miner sectors: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// InternalMessage is a message sent during the execution of another message, as recorded in the vm execution
+// trace. The message at the root of each trace is recorded with a depth of zero.
+type InternalMessage struct {
+	Height           int64  `pg:",pk,notnull,use_zero"`
+	ParentMessageCid string `pg:",pk,notnull"`          // cid of the message at the root of the execution trace
+	Idx              int64  `pg:",pk,notnull,use_zero"` // position of the message in a depth first walk of the trace
+
+	Cid   string `pg:",notnull"`
+	Depth int64  `pg:",notnull,use_zero"`
+
+	From     string `pg:",notnull"`
+	To       string `pg:",notnull"`
+	Value    string `pg:",notnull"`
+	Method   int64  `pg:",notnull,use_zero"`
+	ExitCode int64  `pg:",notnull,use_zero"`
+	GasUsed  int64  `pg:",notnull,use_zero"`
+
+	// Implicit is true when the root of the trace is an implicit message applied by the vm, such as a cron tick or block reward
+	Implicit bool `pg:",notnull,use_zero"`
+}
+
+func (im *InternalMessage) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if _, err := tx.ModelContext(ctx, im).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return fmt.Errorf("persisting internal message: %w", err)
+	}
+	return nil
+}
+
+type InternalMessageList []*InternalMessage
+
+func (l InternalMessageList) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if len(l) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "InternalMessageList.PersistWithTx", trace.WithAttributes(label.Int("count", len(l))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/internal"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ModelContext(ctx, &l).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return fmt.Errorf("persisting internal messages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package messages
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// MempoolMessage is a message seen in the mempool of the lens before it was included in a block.
+type MempoolMessage struct {
+	Cid string `pg:",pk,notnull"`
+
+	From       string `pg:",notnull"`
+	To         string `pg:",notnull"`
+	Value      string `pg:",notnull"`
+	GasFeeCap  string `pg:",notnull"`
+	GasPremium string `pg:",notnull"`
+
+	GasLimit  int64  `pg:",use_zero"`
+	SizeBytes int    `pg:",use_zero"`
+	Nonce     uint64 `pg:",use_zero"`
+	Method    uint64 `pg:",use_zero"`
+
+	// FirstSeen is the time the message was first seen in the mempool
+	FirstSeen time.Time `pg:",notnull"`
+	// RemovedAt is the time the message was last removed from the mempool, either by inclusion or replacement
+	RemovedAt time.Time
+	// Replaces is the cid of a message with the same sender and nonce that this message replaced
+	Replaces string
+	// ReplacedBy is the cid of a message with the same sender and nonce that replaced this message
+	ReplacedBy string
+	// IncludedHeight is the height of the first tipset that included this message
+	IncludedHeight int64
+}
+
+// PersistWithTx records the message, linking it to any message it replaces. Messages that have already been seen
+// are left untouched so the time they were first seen is preserved.
+func (mm *MempoolMessage) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	ctx, span := global.Tracer("").Start(ctx, "MempoolMessage.PersistWithTx")
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/mempool"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	// Any pending message with the same sender and nonce is replaced by this one
+	var replaced []string
+	if _, err := tx.QueryOneContext(ctx, pg.Scan(pg.Array(&replaced)), `
+WITH replaced AS (
+    UPDATE mempool_messages
+    SET replaced_by = ?, removed_at = COALESCE(removed_at, ?)
+    WHERE "from" = ? AND nonce = ? AND cid <> ? AND replaced_by IS null AND included_height IS null
+    RETURNING cid
+)
+SELECT COALESCE(array_agg(cid), '{}') FROM replaced;
+`, mm.Cid, mm.FirstSeen, mm.From, mm.Nonce, mm.Cid); err != nil {
+		return xerrors.Errorf("replacing mempool messages: %w", err)
+	}
+	if len(replaced) > 0 {
+		mm.Replaces = replaced[0]
+	}
+
+	if _, err := tx.ModelContext(ctx, mm).
+		OnConflict("do nothing").
+		Insert(); err != nil {
+		return xerrors.Errorf("persisting mempool message: %w", err)
+	}
+	return nil
+}
+
+// MempoolRemoval records that a message was removed from the mempool.
+type MempoolRemoval struct {
+	Cid       string
+	RemovedAt time.Time
+}
+
+func (mr *MempoolRemoval) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE mempool_messages SET removed_at = ? WHERE cid = ?`, mr.RemovedAt, mr.Cid); err != nil {
+		return xerrors.Errorf("persisting mempool removal: %w", err)
+	}
+	return nil
+}
+
+// MempoolInclusions links messages seen in the mempool to the height of the tipset that included them.
+type MempoolInclusions struct {
+	Height   int64
+	Messages []string
+}
+
+func (mi *MempoolInclusions) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
+	if mi == nil || len(mi.Messages) == 0 {
+		return nil
+	}
+	ctx, span := global.Tracer("").Start(ctx, "MempoolInclusions.PersistWithTx", trace.WithAttributes(label.Int("count", len(mi.Messages))))
+	defer span.End()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/mempool"))
+	stop := metrics.Timer(ctx, metrics.PersistDuration)
+	defer stop()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE mempool_messages
+SET included_height = ?
+WHERE cid IN (?) AND (included_height IS null OR included_height > ?)
+`, mi.Height, pg.In(mi.Messages), mi.Height); err != nil {
+		return xerrors.Errorf("persisting mempool inclusions: %w", err)
+	}
+	return nil
+}
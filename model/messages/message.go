@@ -29,6 +29,11 @@ type Message struct {
 	Method    uint64 `pg:",use_zero"`
 
 	Params []byte
+
+	// ActorCode is the name of the destination actor's code, null if the actor did not exist before the message was executed
+	ActorCode string
+	// MethodName is the name of the method invoked by the message, null if it is not known
+	MethodName string
 }
 
 func (m *Message) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
@@ -22,6 +22,9 @@ type ParsedMessage struct {
 	Method string `pg:",notnull"`
 
 	Params string `pg:",type:jsonb,notnull"`
+
+	// Return is the decoded return value of the message, null if the message failed or its return type is not known
+	Return string `pg:",type:jsonb"`
 }
 
 func (bm *ParsedMessage) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
@@ -11,10 +11,10 @@ import (
 
 type MessageTaskResult struct {
 	Messages          Messages
-	ParsedMessages    ParsedMessages
 	BlockMessages     BlockMessages
 	Receipts          Receipts
 	MessageGasEconomy *MessageGasEconomy
+	MempoolInclusions *MempoolInclusions
 }
 
 func (mtr *MessageTaskResult) Persist(ctx context.Context, db *pg.DB) error {
@@ -46,7 +46,7 @@ func (mtr *MessageTaskResult) PersistWithTx(ctx context.Context, tx *pg.Tx) erro
 	if err := mtr.MessageGasEconomy.PersistWithTx(ctx, tx); err != nil {
 		return err
 	}
-	if err := mtr.ParsedMessages.PersistWithTx(ctx, tx); err != nil {
+	if err := mtr.MempoolInclusions.PersistWithTx(ctx, tx); err != nil {
 		return err
 	}
 
@@ -68,6 +68,83 @@ type ProcessingTipSet struct {
 
 	// EconomicsErrorsDetected contains any error encountered when reading the tipset's chain economics
 	EconomicsErrorsDetected string
+
+	// Internal message processing
+
+	// InternalMessagesClaimedUntil marks the tipset as claimed for internal message processing until the set time
+	InternalMessagesClaimedUntil time.Time
+
+	// InternalMessagesCompletedAt is the time the tipset's messages were replayed and their execution traces read
+	InternalMessagesCompletedAt time.Time
+
+	// InternalMessagesErrorsDetected contains any error encountered when reading the tipset's execution traces
+	InternalMessagesErrorsDetected string
+
+	// Parsed message processing
+
+	// ParsedMessagesClaimedUntil marks the tipset as claimed for parsed message processing until the set time
+	ParsedMessagesClaimedUntil time.Time
+
+	// ParsedMessagesCompletedAt is the time the tipset's messages were parsed
+	ParsedMessagesCompletedAt time.Time
+
+	// ParsedMessagesErrorsDetected contains any error encountered when parsing the tipset's messages
+	ParsedMessagesErrorsDetected string
+
+	// Gas aggregate processing
+
+	// GasAggregatesClaimedUntil marks the tipset as claimed for gas aggregate processing until the set time
+	GasAggregatesClaimedUntil time.Time
+
+	// GasAggregatesCompletedAt is the time the gas used by the tipset's messages was aggregated
+	GasAggregatesCompletedAt time.Time
+
+	// GasAggregatesErrorsDetected contains any error encountered when aggregating the gas used by the tipset's messages
+	GasAggregatesErrorsDetected string
+
+	// Block rewards processing
+
+	// BlockRewardsClaimedUntil marks the tipset as claimed for block rewards processing until the set time
+	BlockRewardsClaimedUntil time.Time
+
+	// BlockRewardsCompletedAt is the time the rewards of the tipset's blocks were attributed to their miners
+	BlockRewardsCompletedAt time.Time
+
+	// BlockRewardsErrorsDetected contains any error encountered when attributing the rewards of the tipset's blocks
+	BlockRewardsErrorsDetected string
+
+	// Balance changes processing
+
+	// BalanceChangesClaimedUntil marks the tipset as claimed for balance changes processing until the set time
+	BalanceChangesClaimedUntil time.Time
+
+	// BalanceChangesCompletedAt is the time the changes to actor balances in the tipset's state were recorded
+	BalanceChangesCompletedAt time.Time
+
+	// BalanceChangesErrorsDetected contains any error encountered when recording the changes to actor balances
+	BalanceChangesErrorsDetected string
+
+	// Miner sector summary processing
+
+	// MinerSectorsClaimedUntil marks the tipset as claimed for miner sector summary processing until the set time
+	MinerSectorsClaimedUntil time.Time
+
+	// MinerSectorsCompletedAt is the time the sectors touched by the tipset's sector events were summarized
+	MinerSectorsCompletedAt time.Time
+
+	// MinerSectorsErrorsDetected contains any error encountered when summarizing the sectors
+	MinerSectorsErrorsDetected string
+
+	// Deal lifecycle processing
+
+	// DealsClaimedUntil marks the tipset as claimed for deal lifecycle processing until the set time
+	DealsClaimedUntil time.Time
+
+	// DealsCompletedAt is the time the lifecycles of the deals touched by the tipset were updated
+	DealsCompletedAt time.Time
+
+	// DealsErrorsDetected contains any error encountered when updating the lifecycles of the deals
+	DealsErrorsDetected string
 }
 
 func (p *ProcessingTipSet) PersistWithTx(ctx context.Context, tx *pg.Tx) error {
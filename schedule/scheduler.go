@@ -8,6 +8,7 @@ import (
 	logging "github.com/ipfs/go-log/v2"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/sentinel-visor/lens"
 	"github.com/filecoin-project/sentinel-visor/storage"
 	"github.com/filecoin-project/sentinel-visor/wait"
 )
@@ -42,7 +43,9 @@ type TaskConfig struct {
 
 // Locker represents a general lock that a task may need to take before operating.
 type Locker interface {
-	Lock(context.Context) error
+	// Lock acquires the lock and returns a context derived from the given context that is cancelled if the lock
+	// is lost.
+	Lock(context.Context) (context.Context, error)
 	Unlock(context.Context) error
 }
 
@@ -86,64 +89,18 @@ func (s *Scheduler) Run(ctx context.Context) error {
 				taskComplete <- struct{}{}
 			}()
 
-			// Attempt to get the task lock if specified
-			if tc.Locker != nil {
-				if err := tc.Locker.Lock(ctx); err != nil {
-					if errors.Is(err, storage.ErrLockNotAcquired) {
-						log.Infow("task not started: lock not acquired", "task", tc.Name)
-						return
-					}
-					log.Errorw("task not started: lock not acquired", "task", tc.Name, "error", err.Error())
-					return
-				}
-				defer func() {
-					if err := tc.Locker.Unlock(ctx); err != nil {
-						if !errors.Is(err, context.Canceled) {
-							log.Errorw("failed to unlock task", "task", tc.Name, "error", err.Error())
-						}
-					}
-				}()
+			if tc.Locker == nil {
+				runTask(ctx, tc)
+				return
 			}
 
-			// Keep this task running forever
-			doneFirstRun := false
-			for {
-
-				// Is the context done?
+			// Keep trying to take the task lock so the task runs here whenever it is not running elsewhere
+			for runLocked(ctx, tc) {
+				log.Infow("retrying task lock", "task", tc.Name, "delay", lockRetryDelay(tc))
 				select {
 				case <-ctx.Done():
 					return
-				default:
-				}
-
-				if doneFirstRun {
-					log.Infow("restarting task", "task", tc.Name, "delay", tc.RestartDelay)
-					if tc.RestartDelay > 0 {
-						time.Sleep(tc.RestartDelay)
-					}
-				} else {
-					log.Infow("running task", "task", tc.Name)
-					doneFirstRun = true
-				}
-
-				err := tc.Task.Run(ctx)
-				if err != nil {
-					if errors.Is(err, context.Canceled) {
-						break
-					}
-					log.Errorw("task exited with failure", "task", tc.Name, "error", err.Error())
-
-					if !tc.RestartOnFailure {
-						// Exit the task
-						break
-					}
-				} else {
-					log.Infow("task exited cleanly", "task", tc.Name)
-
-					if !tc.RestartOnCompletion {
-						// Exit the task
-						break
-					}
+				case <-time.After(lockRetryDelay(tc)):
 				}
 			}
 		}(tc)
@@ -172,3 +129,95 @@ func (s *Scheduler) Run(ctx context.Context) error {
 		}
 	}
 }
+
+// minLockRetryDelay is the shortest time to wait before trying to take a task lock again
+const minLockRetryDelay = time.Second
+
+func lockRetryDelay(tc TaskConfig) time.Duration {
+	if tc.RestartDelay < minLockRetryDelay {
+		return minLockRetryDelay
+	}
+	return tc.RestartDelay
+}
+
+// runLocked takes the task lock and runs the task while the lock is held. It returns true if the task should try to
+// take the lock again, which is when the lock could not be taken or was lost before the task stopped.
+func runLocked(ctx context.Context, tc TaskConfig) bool {
+	lockCtx, err := tc.Locker.Lock(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrLockNotAcquired) {
+			log.Infow("task not started: lock not acquired", "task", tc.Name)
+		} else {
+			log.Errorw("task not started: lock not acquired", "task", tc.Name, "error", err.Error())
+		}
+		return ctx.Err() == nil
+	}
+
+	defer func() {
+		if err := tc.Locker.Unlock(ctx); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Errorw("failed to unlock task", "task", tc.Name, "error", err.Error())
+			}
+		}
+	}()
+
+	runTask(lockCtx, tc)
+
+	if ctx.Err() == nil && lockCtx.Err() != nil {
+		log.Errorw("task stopped: lock lost", "task", tc.Name)
+		return true
+	}
+	return false
+}
+
+// runTask runs the task, restarting it according to its configuration, until the context is done or the task stops
+// and should not be restarted.
+func runTask(ctx context.Context, tc TaskConfig) {
+	// Keep this task running forever
+	doneFirstRun := false
+	for {
+
+		// Is the context done?
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if doneFirstRun {
+			log.Infow("restarting task", "task", tc.Name, "delay", tc.RestartDelay)
+			if tc.RestartDelay > 0 {
+				time.Sleep(tc.RestartDelay)
+			}
+		} else {
+			log.Infow("running task", "task", tc.Name)
+			doneFirstRun = true
+		}
+
+		err := tc.Task.Run(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Errorw("task exited with failure", "task", tc.Name, "error", err.Error())
+
+			if errors.Is(err, lens.ErrMethodUnavailable) {
+				// The lens will never be able to support this task so there is no point restarting it
+				log.Errorw("task cannot run with the configured lens", "task", tc.Name)
+				return
+			}
+
+			if !tc.RestartOnFailure {
+				// Exit the task
+				return
+			}
+		} else {
+			log.Infow("task exited cleanly", "task", tc.Name)
+
+			if !tc.RestartOnCompletion {
+				// Exit the task
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/storage"
+)
+
+// fakeLocker refuses the lock a number of times before granting it. Each granted lock is lost when lose is closed.
+type fakeLocker struct {
+	mu       sync.Mutex
+	refusals int
+	attempts int
+	lose     chan struct{}
+}
+
+func (l *fakeLocker) Lock(ctx context.Context) (context.Context, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts++
+	if l.refusals > 0 {
+		l.refusals--
+		return nil, storage.ErrLockNotAcquired
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	lose := l.lose
+	go func() {
+		select {
+		case <-lose:
+			cancel()
+		case <-lockCtx.Done():
+		}
+	}()
+	return lockCtx, nil
+}
+
+func (l *fakeLocker) Unlock(context.Context) error {
+	return nil
+}
+
+func (l *fakeLocker) Attempts() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attempts
+}
+
+// blockingTask runs until its context is done, reporting each run on started.
+type blockingTask struct {
+	started chan struct{}
+}
+
+func (t *blockingTask) Run(ctx context.Context) error {
+	t.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSchedulerRetriesLock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	locker := &fakeLocker{refusals: 1, lose: make(chan struct{})}
+	task := &blockingTask{started: make(chan struct{}, 2)}
+
+	s := NewScheduler(time.Millisecond)
+	require.NoError(t, s.Add(TaskConfig{
+		Name:             "test",
+		Task:             task,
+		Locker:           locker,
+		RestartOnFailure: true,
+		RestartDelay:     time.Millisecond,
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// The task starts once the lock is acquired after being refused
+	select {
+	case <-task.started:
+	case <-ctx.Done():
+		t.Fatal("task not started after lock was refused")
+	}
+	assert.Equal(t, 2, locker.Attempts())
+
+	// The task starts again once the lock is acquired after being lost
+	locker.mu.Lock()
+	close(locker.lose)
+	locker.lose = make(chan struct{})
+	locker.mu.Unlock()
+
+	select {
+	case <-task.started:
+	case <-ctx.Done():
+		t.Fatal("task not restarted after lock was lost")
+	}
+	assert.Equal(t, 3, locker.Attempts())
+
+	cancel()
+	<-done
+}
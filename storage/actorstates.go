@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/model/actors/common"
+)
+
+// GetActorStateByHead returns the raw state of an actor by its head cid from the content addressed actor state
+// storage, applying any diffs needed to obtain the full state.
+func (d *Database) GetActorStateByHead(ctx context.Context, head string) (string, error) {
+	if len(head) == 0 {
+		return "", xerrors.Errorf("lookup actor state head was empty")
+	}
+
+	// Follow the chain of diffs back to the first full state
+	var chain []*common.ActorStateContent
+	if _, err := d.DB.QueryContext(ctx, &chain, `
+WITH RECURSIVE chain AS (
+	SELECT head, code, height, state, base_head, diff, diff_depth
+	FROM actor_state_contents
+	WHERE head = ?
+	UNION ALL
+	SELECT c.head, c.code, c.height, c.state, c.base_head, c.diff, c.diff_depth
+	FROM actor_state_contents c
+	JOIN chain ON c.head = chain.base_head
+	WHERE chain.state IS NULL
+)
+SELECT * FROM chain ORDER BY diff_depth
+`, head); err != nil {
+		return "", xerrors.Errorf("get actor state %s: %w", head, err)
+	}
+
+	if len(chain) == 0 {
+		return "", xerrors.Errorf("actor state %s not found", head)
+	}
+	if chain[0].State == "" {
+		return "", xerrors.Errorf("actor state %s has no full state at the base of its diffs", head)
+	}
+
+	state := []byte(chain[0].State)
+	for _, c := range chain[1:] {
+		var err error
+		state, err = common.ApplyStatePatch(state, []byte(c.Diff))
+		if err != nil {
+			return "", xerrors.Errorf("apply diff for actor state %s: %w", c.Head, err)
+		}
+	}
+
+	return string(state), nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+)
+
+// A ChainAggregate is a TimescaleDB continuous aggregate of a hypertable partitioned by height, grouped into buckets of
+// BucketWidth epochs.
+type ChainAggregate struct {
+	Name        string
+	Source      string
+	BucketWidth int64
+}
+
+// ChainAggregates lists every aggregate of chain metrics that is refreshed by a ChainAggregateRefresher
+var ChainAggregates = []ChainAggregate{
+	{Name: "chain_miner_blocks_daily", Source: "block_headers", BucketWidth: 2880},
+	{Name: "chain_gas_economy_hourly", Source: "message_gas_economy", BucketWidth: 120},
+	{Name: "chain_power_daily", Source: "chain_powers", BucketWidth: 2880},
+}
+
+// An AggregateRefresh reports the range of heights of an aggregate that were refreshed.
+type AggregateRefresh struct {
+	Aggregate ChainAggregate
+	From      int64 // first height refreshed, inclusive
+	To        int64 // last height refreshed, exclusive, zero if nothing was refreshed
+	Full      bool  // whether the whole aggregate was refreshed
+}
+
+// refreshWindow returns the range of heights to refresh so that every bucket containing one of the most recent window
+// epochs up to maxHeight is recomputed. The range covers the whole aggregate when full is true or window is zero.
+func (a ChainAggregate) refreshWindow(maxHeight, window int64, full bool) (int64, int64) {
+	to := (maxHeight/a.BucketWidth + 1) * a.BucketWidth
+	if full || window == 0 {
+		return 0, to
+	}
+	from := maxHeight - window + 1
+	if from <= 0 {
+		return 0, to
+	}
+	return (from / a.BucketWidth) * a.BucketWidth, to
+}
+
+// RefreshChainAggregate recomputes the buckets of an aggregate that contain the most recent window epochs of its source
+// table. Earlier buckets are assumed not to change once the chain has progressed beyond them. The whole aggregate is
+// refreshed when it is empty or window is zero. Continuous aggregates created with timescaledb 1.x are refreshed
+// according to their own refresh lag so the window is ignored.
+func (d *Database) RefreshChainAggregate(ctx context.Context, a ChainAggregate, window int64) (*AggregateRefresh, error) {
+	res := &AggregateRefresh{Aggregate: a}
+
+	var exists bool
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&exists), `
+SELECT EXISTS (
+	SELECT 1 FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = ? AND c.relname = ?
+)`, d.Schema(), a.Name); err != nil {
+		return nil, xerrors.Errorf("check %s: %w", a.Name, err)
+	}
+	if !exists {
+		return nil, xerrors.Errorf("aggregate %s does not exist", a.Name)
+	}
+
+	version, err := d.timescaleVersion(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("get timescaledb version: %w", err)
+	}
+
+	// Continuous aggregates in timescaledb 1.x track invalidated buckets themselves
+	if strings.HasPrefix(version, "1.") {
+		if _, err := d.DB.ExecContext(ctx, `REFRESH MATERIALIZED VIEW ?`, pg.Ident(a.Name)); err != nil {
+			return nil, xerrors.Errorf("refresh %s: %w", a.Name, err)
+		}
+		res.Full = true
+		return res, nil
+	}
+
+	var maxHeight int64
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&maxHeight), `SELECT COALESCE(max(height), -1) FROM ?`, pg.Ident(a.Source)); err != nil {
+		return nil, xerrors.Errorf("get %s height: %w", a.Source, err)
+	}
+	if maxHeight < 0 {
+		return res, nil
+	}
+
+	var empty bool
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&empty), `SELECT NOT EXISTS (SELECT 1 FROM ?)`, pg.Ident(a.Name)); err != nil {
+		return nil, xerrors.Errorf("check %s is empty: %w", a.Name, err)
+	}
+
+	res.Full = empty || window == 0
+	res.From, res.To = a.refreshWindow(maxHeight, window, res.Full)
+
+	if _, err := d.DB.ExecContext(ctx, `CALL refresh_continuous_aggregate(format('%I.%I', ?, ?)::regclass, ?::bigint, ?::bigint)`, d.Schema(), a.Name, res.From, res.To); err != nil {
+		return nil, xerrors.Errorf("refresh %s: %w", a.Name, err)
+	}
+	return res, nil
+}
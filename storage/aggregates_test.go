@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainAggregateRefreshWindow(t *testing.T) {
+	a := ChainAggregate{Name: "chain_gas_economy_hourly", Source: "message_gas_economy", BucketWidth: 120}
+
+	testCases := []struct {
+		name      string
+		maxHeight int64
+		window    int64
+		full      bool
+		from      int64
+		to        int64
+	}{
+		{name: "full", maxHeight: 1000, window: 240, full: true, from: 0, to: 1080},
+		{name: "no window", maxHeight: 1000, window: 0, from: 0, to: 1080},
+		{name: "window before genesis", maxHeight: 100, window: 240, from: 0, to: 120},
+		{name: "window within bucket", maxHeight: 1000, window: 20, from: 960, to: 1080},
+		{name: "window spans buckets", maxHeight: 1000, window: 240, from: 720, to: 1080},
+		{name: "max height at bucket end", maxHeight: 1079, window: 120, from: 960, to: 1080},
+		{name: "max height at bucket start", maxHeight: 1080, window: 1, from: 1080, to: 1200},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			from, to := a.refreshWindow(tc.maxHeight, tc.window, tc.full)
+			assert.Equal(t, tc.from, from, "from")
+			assert.Equal(t, tc.to, to, "to")
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+)
+
+// BalancesAtHeight returns the balance of each of the addresses at a height, taken from the most recent recorded
+// balance change at or before the height. Addresses must be ID addresses. Addresses without a recorded change are
+// omitted.
+func (d *Database) BalancesAtHeight(ctx context.Context, addresses []string, height int64) (map[string]string, error) {
+	if len(addresses) == 0 {
+		return map[string]string{}, nil
+	}
+
+	var rows []struct {
+		Address string
+		Balance string
+	}
+	// Each address is looked up separately so the index on address and height is used for every one
+	if _, err := d.DB.QueryContext(ctx, &rows, `
+SELECT a.address, b.balance::text
+FROM unnest(?::text[]) AS a(address)
+CROSS JOIN LATERAL (
+	SELECT new_balance AS balance
+	FROM derived_balance_changes
+	WHERE address = a.address AND height <= ?
+	ORDER BY height DESC
+	LIMIT 1
+) b
+`, pg.Array(addresses), height); err != nil {
+		return nil, xerrors.Errorf("get balances at height %d: %w", height, err)
+	}
+
+	balances := make(map[string]string, len(rows))
+	for _, r := range rows {
+		balances[r.Address] = r.Balance
+	}
+	return balances, nil
+}
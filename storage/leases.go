@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/raulk/clock"
+	"golang.org/x/xerrors"
+)
+
+// leaseRenewalsPerLength is the number of times a lease is renewed within its length so a single failed renewal
+// does not cause it to expire
+const leaseRenewalsPerLength = 3
+
+// RenewLeases extends the claim on the incomplete items of a queue that are claimed until claimedUntil, which is
+// the claim made by a single lease, returning the number of items that were renewed. Items that have completed or
+// whose claim expired and was taken by another processor are not renewed.
+func (d *Database) RenewLeases(ctx context.Context, q *WorkQueue, claimedUntil, claimUntil time.Time) (int, error) {
+	res, err := d.DB.ExecContext(ctx, fmt.Sprintf(`
+UPDATE %[1]s
+SET %[2]s = ?
+WHERE %[2]s = ? AND %[3]s IS null
+`, q.Table, q.ClaimedColumn(), q.CompletedColumn()), claimUntil, claimedUntil)
+	if err != nil {
+		return 0, xerrors.Errorf("renew %s leases: %w", q.Name, err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// ReapExpiredLeases clears the claims in a queue that have expired without the item being completed so they are no
+// longer counted as claimed, returning the number of claims cleared. Expired items can be leased again whether or
+// not their claim has been cleared.
+func (d *Database) ReapExpiredLeases(ctx context.Context, q *WorkQueue) (int, error) {
+	res, err := d.DB.ExecContext(ctx, fmt.Sprintf(`
+UPDATE %[1]s
+SET %[2]s = null
+WHERE %[2]s < ?
+`, q.Table, q.ClaimedColumn()), d.Clock.Now())
+	if err != nil {
+		return 0, xerrors.Errorf("reap %s leases: %w", q.Name, err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// A Lease is the claim made on a batch of items by a single lease. It is renewed in the background by KeepLeases for as
+// long as the processor holds it, unless the processor stops reporting progress on the items.
+type Lease struct {
+	clock  clock.Clock
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	lastProgress time.Time
+}
+
+// Progress reports that an item in the batch has been processed, restarting the time allowed before the lease is
+// considered stalled. It is safe to call on a nil Lease.
+func (l *Lease) Progress() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastProgress = l.clock.Now()
+}
+
+// Release stops renewing the lease. It must be called once the leased items have been processed and is safe to call
+// on a nil Lease.
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.cancel()
+}
+
+// sinceProgress returns the time since progress was last reported, or since the lease was kept if there has been none.
+func (l *Lease) sinceProgress() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.clock.Now().Sub(l.lastProgress)
+}
+
+// KeepLeases renews the lease made until claimUntil in the background while the items are being processed, extending
+// it by leaseLength several times within each lease length. The lease is renewed until it is released, so a single
+// item may take longer than the lease length to process. When the database has a LeaseStallLimit the lease stops
+// being renewed once no item has been processed within that limit so a stuck processor does not hold its items
+// forever. The returned context is cancelled when the lease expires without being renewed. The returned lease must
+// be released once the leased items have been processed.
+func (d *Database) KeepLeases(ctx context.Context, q *WorkQueue, claimUntil time.Time, leaseLength time.Duration) (context.Context, *Lease) {
+	ctx, cancel := context.WithCancel(ctx)
+	lease := &Lease{
+		clock:        d.Clock,
+		cancel:       cancel,
+		lastProgress: d.Clock.Now(),
+	}
+
+	ticker := d.Clock.Ticker(leaseLength / time.Duration(leaseRenewalsPerLength))
+	go func() {
+		defer ticker.Stop()
+
+		claimedUntil := claimUntil
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if d.LeaseStallLimit > 0 && lease.sinceProgress() >= d.LeaseStallLimit {
+				log.Errorw("lease not renewed: no items processed within the stall limit", "queue", q.Name, "stall_limit", d.LeaseStallLimit, "claimed_until", claimedUntil)
+				d.expireLease(ctx, cancel, claimedUntil)
+				return
+			}
+
+			next := d.Clock.Now().Add(leaseLength)
+			renewed, err := d.RenewLeases(ctx, q, claimedUntil, next)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !d.Clock.Now().Before(claimedUntil) {
+					log.Errorw("lease expired before it could be renewed", "queue", q.Name, "error", err.Error())
+					cancel()
+					return
+				}
+				log.Warnw("failed to renew lease", "queue", q.Name, "error", err.Error())
+				continue
+			}
+
+			log.Debugw("renewed lease", "queue", q.Name, "items", renewed, "claimed_until", next)
+			claimedUntil = next
+		}
+	}()
+
+	return ctx, lease
+}
+
+// expireLease waits until the claim made until claimedUntil has expired and then cancels the lease's context.
+func (d *Database) expireLease(ctx context.Context, cancel context.CancelFunc, claimedUntil time.Time) {
+	remaining := claimedUntil.Sub(d.Clock.Now())
+	if remaining > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.Clock.After(remaining):
+		}
+	}
+	cancel()
+}
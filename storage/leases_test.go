@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestRenewAndReapLeases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	truncateVisorProcessingTables(t, db)
+
+	claimUntil := testutil.KnownTime.Add(time.Minute * 10)
+
+	indexedActors := visor.ProcessingActorList{
+		// Claimed by our lease
+		{
+			Head:         "head0",
+			Code:         "codeA",
+			Height:       0,
+			AddedAt:      testutil.KnownTime,
+			ClaimedUntil: claimUntil,
+		},
+
+		// Claimed by our lease and completed
+		{
+			Head:         "head1",
+			Code:         "codeA",
+			Height:       1,
+			AddedAt:      testutil.KnownTime,
+			ClaimedUntil: claimUntil,
+			CompletedAt:  testutil.KnownTime,
+		},
+
+		// Claimed by another process
+		{
+			Head:         "head2",
+			Code:         "codeA",
+			Height:       2,
+			AddedAt:      testutil.KnownTime,
+			ClaimedUntil: testutil.KnownTime.Add(time.Minute * 5),
+		},
+
+		// Claimed by another process that has expired
+		{
+			Head:         "head3",
+			Code:         "codeA",
+			Height:       3,
+			AddedAt:      testutil.KnownTime,
+			ClaimedUntil: testutil.KnownTime.Add(-time.Minute * 5),
+		},
+	}
+
+	if err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return indexedActors.PersistWithTx(ctx, tx)
+	}); err != nil {
+		t.Fatalf("persisting indexed actors: %v", err)
+	}
+
+	d := &Database{
+		DB:    db,
+		Clock: testutil.NewMockClock(),
+	}
+
+	renewUntil := claimUntil.Add(time.Minute * 10)
+	renewed, err := d.RenewLeases(ctx, ActorQueue, claimUntil, renewUntil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, renewed, "number of renewed actors")
+
+	var count int
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_actors WHERE claimed_until=?`, renewUntil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "actors claimed until renewal")
+
+	reaped, err := d.ReapExpiredLeases(ctx, ActorQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reaped, "number of reaped actors")
+
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_actors WHERE claimed_until IS NOT NULL`)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count, "actors still claimed")
+}
+
+func TestKeepLeases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	truncateVisorProcessingTables(t, db)
+
+	leaseLength := time.Minute * 3
+	mock := testutil.NewMockClock()
+	d := &Database{
+		DB:              db,
+		Clock:           mock,
+		LeaseStallLimit: 2 * leaseLength,
+	}
+
+	start := mock.Now()
+	claimUntil := start.Add(leaseLength)
+
+	indexedTipsets := visor.ProcessingTipSetList{
+		{TipSet: "cid0", Height: 0, AddedAt: testutil.KnownTime, EconomicsClaimedUntil: claimUntil},
+		{TipSet: "cid1", Height: 1, AddedAt: testutil.KnownTime, EconomicsClaimedUntil: claimUntil},
+	}
+	if err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return indexedTipsets.PersistWithTx(ctx, tx)
+	}); err != nil {
+		t.Fatalf("persisting indexed tipsets: %v", err)
+	}
+
+	claimedUntil := func() time.Time {
+		var until time.Time
+		_, err := db.QueryOne(pg.Scan(&until), `SELECT MAX(economics_claimed_until) FROM visor_processing_tipsets`)
+		require.NoError(t, err)
+		return until
+	}
+
+	// advance moves the clock by one renewal interval and waits for the lease to be claimed until want
+	advance := func(want time.Time) {
+		mock.Add(leaseLength / leaseRenewalsPerLength)
+		require.Eventually(t, func() bool {
+			return claimedUntil().Equal(want)
+		}, time.Second*2, time.Millisecond*10, "claimed until %s", want)
+	}
+
+	leaseCtx, lease := d.KeepLeases(ctx, EconomicsQueue, claimUntil, leaseLength)
+	defer lease.Release()
+
+	// The lease is renewed while progress is reported
+	advance(start.Add(time.Minute + leaseLength))
+	lease.Progress()
+	advance(start.Add(2*time.Minute + leaseLength))
+	advance(start.Add(3*time.Minute + leaseLength))
+
+	// An item that takes longer than the lease length to process keeps the lease
+	advance(start.Add(4*time.Minute + leaseLength))
+	advance(start.Add(5*time.Minute + leaseLength))
+	advance(start.Add(6*time.Minute + leaseLength))
+	assert.NoError(t, leaseCtx.Err(), "lease context cancelled while the item was being processed")
+
+	// No progress has been reported within the stall limit so the lease is no longer renewed
+	advance(start.Add(6*time.Minute + leaseLength))
+	assert.NoError(t, leaseCtx.Err(), "lease context cancelled before the claim expired")
+
+	// The lease context is cancelled once the claim expires
+	require.Eventually(t, func() bool {
+		mock.Add(leaseLength / leaseRenewalsPerLength)
+		return leaseCtx.Err() != nil
+	}, time.Second*2, time.Millisecond*10, "lease context not cancelled")
+	assert.Equal(t, start.Add(6*time.Minute+leaseLength), claimedUntil())
+}
@@ -3,23 +3,37 @@ package storage
 import (
 	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"golang.org/x/xerrors"
 )
 
 var ErrLockNotAcquired = errors.New("lock not acquired")
 var ErrLockNotReleased = errors.New("lock not released")
+var ErrLockLost = errors.New("lock lost")
 
 // An AdvisoryLock is a lock that is managed by Postgres but is only enforced by the application. Advisory
 // locks are automatically released at the end of a session. It is safe to hold both a shared and exclusive
 // lock within a single session.
+//
+// Locks are namespaced by the schema the session is using so instances using different schemas in the same database
+// do not contend for locks. Locks in the public schema use the lock id directly for compatibility with earlier
+// versions of visor.
+//
+// A session is a single database connection, so locking and unlocking through a connection pool may take and release
+// the lock on different connections. Use HoldExclusive to hold a lock on a connection dedicated to it.
 type AdvisoryLock int64
 
-// LockShared tries to acquire a session scoped exclusive advisory lock.
-func (l AdvisoryLock) LockExclusive(ctx context.Context, db *pg.DB) error {
+// lockKey is the expression used to derive the key of an advisory lock from its id and the current schema
+const lockKey = `CASE WHEN current_schema() = 'public' THEN ?::bigint ELSE (hashtext(current_schema())::bigint << 32) | ?::bigint END`
+
+// LockExclusive tries to acquire a session scoped exclusive advisory lock.
+func (l AdvisoryLock) LockExclusive(ctx context.Context, db orm.DB) error {
 	var acquired bool
-	_, err := db.QueryOneContext(ctx, pg.Scan(&acquired), `SELECT pg_try_advisory_lock(?);`, int64(l))
+	_, err := db.QueryOneContext(ctx, pg.Scan(&acquired), `SELECT pg_try_advisory_lock(`+lockKey+`);`, int64(l), int64(l))
 	if err != nil {
 		return xerrors.Errorf("acquiring exclusive lock: %w", err)
 	}
@@ -30,9 +44,9 @@ func (l AdvisoryLock) LockExclusive(ctx context.Context, db *pg.DB) error {
 }
 
 // UnlockExclusive releases an exclusive advisory lock.
-func (l AdvisoryLock) UnlockExclusive(ctx context.Context, db *pg.DB) error {
+func (l AdvisoryLock) UnlockExclusive(ctx context.Context, db orm.DB) error {
 	var released bool
-	_, err := db.QueryOneContext(ctx, pg.Scan(&released), `SELECT pg_advisory_unlock(?);`, int64(l))
+	_, err := db.QueryOneContext(ctx, pg.Scan(&released), `SELECT pg_advisory_unlock(`+lockKey+`);`, int64(l), int64(l))
 	if err != nil {
 		return xerrors.Errorf("unlocking exclusive lock: %w", err)
 	}
@@ -43,9 +57,9 @@ func (l AdvisoryLock) UnlockExclusive(ctx context.Context, db *pg.DB) error {
 }
 
 // LockShared tries to acquire a session scoped shared advisory lock.
-func (l AdvisoryLock) LockShared(ctx context.Context, db *pg.DB) error {
+func (l AdvisoryLock) LockShared(ctx context.Context, db orm.DB) error {
 	var acquired bool
-	_, err := db.QueryOneContext(ctx, pg.Scan(&acquired), `SELECT pg_try_advisory_lock_shared(?);`, int64(l))
+	_, err := db.QueryOneContext(ctx, pg.Scan(&acquired), `SELECT pg_try_advisory_lock_shared(`+lockKey+`);`, int64(l), int64(l))
 	if err != nil {
 		return xerrors.Errorf("acquiring exclusive lock: %w", err)
 	}
@@ -56,9 +70,9 @@ func (l AdvisoryLock) LockShared(ctx context.Context, db *pg.DB) error {
 }
 
 // UnlockShared releases a shared advisory lock.
-func (l AdvisoryLock) UnlockShared(ctx context.Context, db *pg.DB) error {
+func (l AdvisoryLock) UnlockShared(ctx context.Context, db orm.DB) error {
 	var released bool
-	_, err := db.QueryOneContext(ctx, pg.Scan(&released), `SELECT pg_advisory_unlock_shared(?);`, int64(l))
+	_, err := db.QueryOneContext(ctx, pg.Scan(&released), `SELECT pg_advisory_unlock_shared(`+lockKey+`);`, int64(l), int64(l))
 	if err != nil {
 		return xerrors.Errorf("unlocking shared lock: %w", err)
 	}
@@ -67,3 +81,127 @@ func (l AdvisoryLock) UnlockShared(ctx context.Context, db *pg.DB) error {
 	}
 	return nil
 }
+
+// HoldExclusive tries to acquire a session scoped exclusive advisory lock on a connection taken from the pool and
+// dedicated to the lock until it is released. The lock is verified every checkInterval and the context of the held
+// lock is cancelled if the lock is no longer held, such as when the connection to the database drops.
+func (l AdvisoryLock) HoldExclusive(ctx context.Context, db *pg.DB, checkInterval time.Duration) (*HeldLock, error) {
+	conn := db.Conn()
+
+	if err := l.LockExclusive(ctx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// The backend process id identifies the session holding the lock so we can detect if the connection was
+	// silently replaced
+	var pid int
+	if _, err := conn.QueryOneContext(ctx, pg.Scan(&pid), `SELECT pg_backend_pid();`); err != nil {
+		_ = conn.Close()
+		return nil, xerrors.Errorf("get backend pid: %w", err)
+	}
+
+	hctx, cancel := context.WithCancel(ctx)
+	h := &HeldLock{
+		lock:   l,
+		conn:   conn,
+		pid:    pid,
+		ctx:    hctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go h.monitor(checkInterval)
+
+	return h, nil
+}
+
+// A HeldLock is an exclusive advisory lock held on a dedicated connection.
+type HeldLock struct {
+	lock   AdvisoryLock
+	conn   *pg.Conn
+	pid    int
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the monitor exits
+
+	mu  sync.Mutex
+	err error // reason the lock was lost
+}
+
+// Context returns a context that is cancelled when the lock is lost or released.
+func (h *HeldLock) Context() context.Context {
+	return h.ctx
+}
+
+// Err returns ErrLockLost, wrapping the cause, if the lock has been lost, or nil otherwise.
+func (h *HeldLock) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Release releases the lock and returns its connection to the pool.
+func (h *HeldLock) Release(ctx context.Context) error {
+	// Stop the monitor first so it does not use the connection concurrently or report the lock as lost
+	h.cancel()
+	<-h.done
+
+	defer h.conn.Close()
+
+	if h.Err() != nil {
+		// The session that held the lock has gone, so has the lock
+		return nil
+	}
+
+	return h.lock.UnlockExclusive(ctx, h.conn)
+}
+
+func (h *HeldLock) monitor(checkInterval time.Duration) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := h.check(checkInterval); err != nil {
+			if h.ctx.Err() != nil {
+				// Released or parent context cancelled while checking
+				return
+			}
+			h.mu.Lock()
+			h.err = xerrors.Errorf("%w: %v", ErrLockLost, err)
+			h.mu.Unlock()
+			log.Errorw("advisory lock lost", "lock", int64(h.lock), "error", err.Error())
+			h.cancel()
+			return
+		}
+	}
+}
+
+// check verifies that the lock is still held by the session that acquired it.
+func (h *HeldLock) check(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+
+	var held bool
+	_, err := h.conn.QueryOneContext(ctx, pg.Scan(&held), `
+SELECT pg_backend_pid() = ? AND EXISTS (
+	SELECT 1 FROM pg_locks
+	WHERE locktype = 'advisory' AND granted AND mode = 'ExclusiveLock' AND pid = pg_backend_pid()
+	AND ((classid::bigint << 32) | objid::bigint) = (`+lockKey+`)
+);`, h.pid, int64(h.lock), int64(h.lock))
+	if err != nil {
+		return xerrors.Errorf("verify lock: %w", err)
+	}
+	if !held {
+		return xerrors.Errorf("lock no longer held by session %d", h.pid)
+	}
+	return nil
+}
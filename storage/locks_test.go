@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestHoldExclusive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	const lock AdvisoryLock = 98989898
+	const checkInterval = 10 * time.Millisecond
+
+	held, err := lock.HoldExclusive(ctx, db, checkInterval)
+	require.NoError(t, err, "hold lock")
+
+	// The lock is held on a dedicated connection so other connections in the pool cannot take it
+	_, err = lock.HoldExclusive(ctx, db, checkInterval)
+	assert.True(t, errors.Is(err, ErrLockNotAcquired), "second hold: %v", err)
+
+	// Dropping the connection holding the lock cancels the context of the held lock
+	_, err = db.ExecContext(ctx, `SELECT pg_terminate_backend(?)`, held.pid)
+	require.NoError(t, err, "terminate backend")
+
+	select {
+	case <-held.Context().Done():
+	case <-ctx.Done():
+		t.Fatalf("held lock context was not cancelled after connection dropped")
+	}
+	assert.True(t, errors.Is(held.Err(), ErrLockLost), "lock error: %v", held.Err())
+	require.NoError(t, held.Release(ctx), "release lost lock")
+
+	// Once lost the lock can be taken again
+	held, err = lock.HoldExclusive(ctx, db, checkInterval)
+	require.NoError(t, err, "hold lock again")
+	assert.NoError(t, held.Err())
+	require.NoError(t, held.Release(ctx), "release lock")
+}
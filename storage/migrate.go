@@ -15,28 +15,30 @@ import (
 func (d *Database) GetSchemaVersions(ctx context.Context) (int, int, error) {
 	// If we're already connected then use that connection
 	if d.DB != nil {
-		return getSchemaVersions(ctx, d.DB)
+		return getSchemaVersions(ctx, d.DB, d.Schema())
 	}
 
 	// Temporarily connect
-	db, err := connect(ctx, d.opt)
+	db, err := connect(ctx, d.opt, d.Schema())
 	if err != nil {
 		return 0, 0, xerrors.Errorf("connect: %w", err)
 	}
 	defer db.Close()
-	return getSchemaVersions(ctx, db)
+	return getSchemaVersions(ctx, db, d.Schema())
 }
 
 // getSchemaVersions returns the schema version in the database and the schema version defined by the available
 // migrations.
-func getSchemaVersions(ctx context.Context, db *pg.DB) (int, int, error) {
+func getSchemaVersions(ctx context.Context, db *pg.DB, schema string) (int, int, error) {
+	coll := migrationCollection(schema)
+
 	// Run the migration init to ensure we always have a migrations table
-	_, _, err := migrations.Run(db, "init")
+	_, _, err := coll.Run(db, "init")
 	if err != nil {
 		return 0, 0, xerrors.Errorf("migration table init: %w", err)
 	}
 
-	dbVersion, err := migrations.Version(db)
+	dbVersion, err := coll.Version(db)
 	if err != nil {
 		return 0, 0, xerrors.Errorf("unable to determine schema version: %w", err)
 	}
@@ -45,6 +47,20 @@ func getSchemaVersions(ctx context.Context, db *pg.DB) (int, int, error) {
 	return int(dbVersion), latestVersion, nil
 }
 
+// migrationCollection returns the registered migrations configured to record the schema version in a migrations
+// table within schema.
+func migrationCollection(schema string) *migrations.Collection {
+	coll := migrations.NewCollection(migrations.DefaultCollection.Migrations()...)
+	coll.DisableSQLAutodiscover(true)
+	coll.SetTableName(schema + ".gopg_migrations")
+	return coll
+}
+
+// LatestSchemaVersion returns the latest schema version available for migration.
+func LatestSchemaVersion() int {
+	return getLatestSchemaVersion()
+}
+
 // Latest schema version is based on the highest migration version
 func getLatestSchemaVersion() int {
 	var latestVersion int64
@@ -65,17 +81,19 @@ func (d *Database) MigrateSchema(ctx context.Context) error {
 // MigrateSchema migrates the database schema to a specific version. Note that downgrading a schema to an earlier
 // version is destructive and may result in the loss of data.
 func (d *Database) MigrateSchemaTo(ctx context.Context, target int) error {
-	db, err := connect(ctx, d.opt)
+	db, err := connect(ctx, d.opt, d.Schema())
 	if err != nil {
 		return xerrors.Errorf("connect: %w", err)
 	}
 	defer db.Close()
 
-	dbVersion, latestVersion, err := getSchemaVersions(ctx, db)
+	coll := migrationCollection(d.Schema())
+
+	dbVersion, latestVersion, err := getSchemaVersions(ctx, db, d.Schema())
 	if err != nil {
 		return xerrors.Errorf("get schema versions: %w", err)
 	}
-	log.Infof("current database schema is version %d", dbVersion)
+	log.Infof("current database schema %s is version %d", d.Schema(), dbVersion)
 
 	if latestVersion < target {
 		return xerrors.Errorf("no migrations found for version %d", target)
@@ -106,7 +124,7 @@ func (d *Database) MigrateSchemaTo(ctx context.Context, target int) error {
 	if dbVersion > target {
 		for dbVersion > target {
 			log.Warnf("running destructive schema migration from version %d to version %d", dbVersion, dbVersion-1)
-			_, newDBVersion, err := migrations.Run(db, "down")
+			_, newDBVersion, err := coll.Run(db, "down")
 			if err != nil {
 				return xerrors.Errorf("run migration: %w", err)
 			}
@@ -118,7 +136,7 @@ func (d *Database) MigrateSchemaTo(ctx context.Context, target int) error {
 
 	// Need to advance schema version
 	log.Infof("running schema migration from version %d to version %d", dbVersion, target)
-	_, newDBVersion, err := migrations.Run(db, "up", strconv.Itoa(target))
+	_, newDBVersion, err := coll.Run(db, "up", strconv.Itoa(target))
 	if err != nil {
 		return xerrors.Errorf("run migration: %w", err)
 	}
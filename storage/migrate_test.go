@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestNewDatabaseSchemaName(t *testing.T) {
+	for _, schema := range []string{"", "public", "calibration", "net_2", "_visor"} {
+		_, err := NewDatabase(context.Background(), "postgres://localhost/visor", 1, schema)
+		assert.NoError(t, err, "schema %q", schema)
+	}
+
+	for _, schema := range []string{"Calibration", "my schema", "2net", `x"y`, "x.y", "x-y"} {
+		_, err := NewDatabase(context.Background(), "postgres://localhost/visor", 1, schema)
+		assert.Error(t, err, "schema %q", schema)
+	}
+}
+
+func TestMigrateSchemaInSchema(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	const schema = "visor_migrate_test"
+
+	dropSchema := func() {
+		_, err := db.Exec(`DROP SCHEMA IF EXISTS ? CASCADE`, pg.Ident(schema))
+		require.NoError(t, err, "dropping schema")
+	}
+	dropSchema()
+	defer dropSchema()
+
+	d, err := NewDatabase(ctx, testutil.DatabaseOptions(), 1, schema)
+	require.NoError(t, err)
+
+	require.NoError(t, d.MigrateSchema(ctx))
+
+	dbVersion, latestVersion, err := d.GetSchemaVersions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latestVersion, dbVersion)
+
+	// The migrations table and the migrated tables are in the schema rather than public
+	tableInSchema := func(table string) bool {
+		var exists bool
+		_, err := db.QueryOne(pg.Scan(&exists), `SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = ? AND table_name = ?)`, schema, table)
+		require.NoError(t, err)
+		return exists
+	}
+	assert.True(t, tableInSchema("gopg_migrations"))
+	assert.True(t, tableInSchema("visor_processing_tipsets"))
+	assert.True(t, tableInSchema("derived_deals"))
+
+	// Connecting checks the version recorded in the schema's migrations table
+	require.NoError(t, d.Connect(ctx))
+	require.NoError(t, d.Close(ctx))
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/migrations/v8"
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+
+	vmigrations "github.com/filecoin-project/sentinel-visor/storage/migrations"
+)
+
+// A MigrationPlan describes the statements that would be executed to migrate a schema from one version to another.
+type MigrationPlan struct {
+	Schema string
+	From   int
+	To     int
+	Steps  []*MigrationStep
+}
+
+// A MigrationStep describes the statements executed to move the schema by a single version.
+type MigrationStep struct {
+	Version    int  // the version of the migration being run
+	Down       bool // true if the migration is being reverted
+	Statements []string
+	Tables     []*TableEstimate // existing tables the statements refer to
+	Warnings   []string
+}
+
+// A TableEstimate is the approximate size of an existing table, including any hypertable chunks.
+type TableEstimate struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+// destructiveStatement matches statements that remove tables, columns or rows
+var destructiveStatement = regexp.MustCompile(`(?i)\b(DROP\s+(TABLE|COLUMN|MATERIALIZED\s+VIEW|VIEW|SCHEMA)|TRUNCATE|DELETE\s+FROM)\b`)
+
+// PlanMigration returns the statements that would be executed to migrate the database schema to the target version
+// without making any changes to the database.
+func (d *Database) PlanMigration(ctx context.Context, target int) (*MigrationPlan, error) {
+	// Planning does not take any locks or create the schema or migrations table
+	db := pg.Connect(d.opt).WithContext(ctx)
+	defer db.Close()
+	if err := db.Ping(ctx); err != nil {
+		return nil, xerrors.Errorf("ping database: %w", err)
+	}
+
+	dbVersion, err := readSchemaVersion(ctx, db, d.Schema())
+	if err != nil {
+		return nil, xerrors.Errorf("read schema version: %w", err)
+	}
+
+	if getLatestSchemaVersion() < target {
+		return nil, xerrors.Errorf("no migrations found for version %d", target)
+	}
+
+	if err := checkMigrationSequence(ctx, dbVersion, target); err != nil {
+		return nil, xerrors.Errorf("check migration sequence: %w", err)
+	}
+
+	byVersion := map[int]*migrations.Migration{}
+	for _, m := range migrations.DefaultCollection.Migrations() {
+		byVersion[int(m.Version)] = m
+	}
+
+	tables, err := estimateTables(ctx, db, d.Schema())
+	if err != nil {
+		return nil, xerrors.Errorf("estimate table sizes: %w", err)
+	}
+
+	plan := &MigrationPlan{
+		Schema: d.Schema(),
+		From:   dbVersion,
+		To:     target,
+	}
+
+	addStep := func(version int, down bool) error {
+		m := byVersion[version]
+		fn := m.Up
+		if down {
+			fn = m.Down
+		}
+
+		stmts, err := vmigrations.Record(fn, d.Schema())
+		if err != nil {
+			return xerrors.Errorf("record migration %d: %w", version, err)
+		}
+
+		step := &MigrationStep{
+			Version:    version,
+			Down:       down,
+			Statements: stmts,
+			Tables:     referencedTables(stmts, tables),
+		}
+		if down {
+			step.Warnings = append(step.Warnings, fmt.Sprintf("reverting schema version %d is destructive and may result in the loss of data", version))
+		}
+		for _, stmt := range stmts {
+			if destructiveStatement.MatchString(stmt) {
+				step.Warnings = append(step.Warnings, "statements remove tables, columns or rows")
+				break
+			}
+		}
+
+		plan.Steps = append(plan.Steps, step)
+		return nil
+	}
+
+	for v := dbVersion; v > target; v-- {
+		if err := addStep(v, true); err != nil {
+			return nil, err
+		}
+	}
+	for v := dbVersion + 1; v <= target; v++ {
+		if err := addStep(v, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// readSchemaVersion returns the schema version recorded in the database, or zero if no migrations have been run.
+func readSchemaVersion(ctx context.Context, db *pg.DB, schema string) (int, error) {
+	var exists bool
+	if _, err := db.QueryOneContext(ctx, pg.Scan(&exists), `SELECT to_regclass(?) IS NOT NULL`, schema+".gopg_migrations"); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	version, err := migrationCollection(schema).Version(db)
+	if err != nil {
+		return 0, err
+	}
+	return int(version), nil
+}
+
+// estimateTables returns the estimated size of every table and materialized view in the schema.
+func estimateTables(ctx context.Context, db *pg.DB, schema string) (map[string]*TableEstimate, error) {
+	var estimates []*TableEstimate
+	if _, err := db.QueryContext(ctx, &estimates, `
+SELECT c.relname AS name,
+	(c.reltuples + COALESCE((SELECT SUM(ch.reltuples) FROM pg_inherits i JOIN pg_class ch ON ch.oid = i.inhrelid WHERE i.inhparent = c.oid), 0))::bigint AS rows,
+	(pg_total_relation_size(c.oid) + COALESCE((SELECT SUM(pg_total_relation_size(i.inhrelid)) FROM pg_inherits i WHERE i.inhparent = c.oid), 0))::bigint AS bytes
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = ? AND c.relkind IN ('r', 'p', 'm')
+`, schema); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]*TableEstimate, len(estimates))
+	for _, e := range estimates {
+		// Hypertable chunks appear with rows counted against their hypertable
+		if strings.HasPrefix(e.Name, "_hyper_") {
+			continue
+		}
+		out[e.Name] = e
+	}
+	return out, nil
+}
+
+var identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// referencedTables returns the tables that are named by the statements, ordered by name.
+func referencedTables(stmts []string, tables map[string]*TableEstimate) []*TableEstimate {
+	seen := map[string]bool{}
+	var out []*TableEstimate
+	for _, stmt := range stmts {
+		for _, name := range identifier.FindAllString(stmt, -1) {
+			t, ok := tables[name]
+			if !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// WriteSQL writes the plan as a SQL script, with the size estimates and warnings for each step as comments. The script
+// can be run in place of migrating the schema: it sets the search path to the schema, runs each step in its own
+// transaction and records the new schema version in the migrations table as each step completes.
+func (p *MigrationPlan) WriteSQL(w io.Writer) error {
+	schema := `"` + p.Schema + `"`
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Migration of schema %s from version %d to version %d\n", p.Schema, p.From, p.To)
+	if len(p.Steps) == 0 {
+		fmt.Fprintf(&b, "-- No migrations to run\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	fmt.Fprintf(&b, "CREATE SCHEMA IF NOT EXISTS %s;\n", schema)
+	fmt.Fprintf(&b, "SET search_path TO %s, public;\n", schema)
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s.gopg_migrations (id serial, version bigint, created_at timestamptz);\n", schema)
+
+	for _, step := range p.Steps {
+		direction := "up"
+		if step.Down {
+			direction = "down"
+		}
+		fmt.Fprintf(&b, "\n-- ----------------------------------------------------------------\n")
+		fmt.Fprintf(&b, "-- Schema version %d (%s)\n", step.Version, direction)
+		fmt.Fprintf(&b, "-- ----------------------------------------------------------------\n")
+		for _, warning := range step.Warnings {
+			fmt.Fprintf(&b, "-- WARNING: %s\n", warning)
+		}
+		for _, t := range step.Tables {
+			fmt.Fprintf(&b, "-- Affects %s: ~%d rows, ~%s\n", t.Name, t.Rows, formatBytes(t.Bytes))
+		}
+		fmt.Fprintf(&b, "BEGIN;\n")
+		for _, stmt := range step.Statements {
+			fmt.Fprintf(&b, "%s\n", strings.TrimSpace(stmt))
+		}
+		if step.Down {
+			fmt.Fprintf(&b, "DELETE FROM %s.gopg_migrations WHERE version = %d;\n", schema, step.Version)
+		} else {
+			fmt.Fprintf(&b, "INSERT INTO %s.gopg_migrations (version, created_at) VALUES (%d, now());\n", schema, step.Version)
+		}
+		fmt.Fprintf(&b, "COMMIT;\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationPlanWriteSQL(t *testing.T) {
+	tables := map[string]*TableEstimate{
+		"blocks":   {Name: "blocks", Rows: 1000, Bytes: 3 * 1024 * 1024},
+		"messages": {Name: "messages", Rows: 5000, Bytes: 2048},
+	}
+
+	stmts := []string{"\nDROP TABLE IF EXISTS public.messages;\n", "ALTER TABLE public.blocks ADD COLUMN x int;"}
+	referenced := referencedTables(stmts, tables)
+	require.Len(t, referenced, 2)
+	assert.Equal(t, "blocks", referenced[0].Name)
+	assert.Equal(t, "messages", referenced[1].Name)
+	assert.True(t, destructiveStatement.MatchString(stmts[0]))
+	assert.False(t, destructiveStatement.MatchString(stmts[1]))
+
+	plan := &MigrationPlan{
+		Schema: "public",
+		From:   3,
+		To:     2,
+		Steps: []*MigrationStep{
+			{
+				Version:    3,
+				Down:       true,
+				Statements: stmts,
+				Tables:     referenced,
+				Warnings:   []string{"destructive"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, plan.WriteSQL(&buf))
+
+	expected := `-- Migration of schema public from version 3 to version 2
+CREATE SCHEMA IF NOT EXISTS "public";
+SET search_path TO "public", public;
+CREATE TABLE IF NOT EXISTS "public".gopg_migrations (id serial, version bigint, created_at timestamptz);
+
+-- ----------------------------------------------------------------
+-- Schema version 3 (down)
+-- ----------------------------------------------------------------
+-- WARNING: destructive
+-- Affects blocks: ~1000 rows, ~3.0 MiB
+-- Affects messages: ~5000 rows, ~2.0 KiB
+BEGIN;
+DROP TABLE IF EXISTS public.messages;
+ALTER TABLE public.blocks ADD COLUMN x int;
+DELETE FROM "public".gopg_migrations WHERE version = 3;
+COMMIT;
+`
+	assert.Equal(t, expected, buf.String())
+
+	up := &MigrationPlan{
+		Schema: "calibration",
+		From:   1,
+		To:     3,
+		Steps: []*MigrationStep{
+			{Version: 2, Statements: []string{`CREATE TABLE IF NOT EXISTS "calibration".a (x int);`}},
+			{Version: 3, Statements: []string{`CREATE TABLE IF NOT EXISTS "calibration".b (x int);`}},
+		},
+	}
+
+	buf.Reset()
+	require.NoError(t, up.WriteSQL(&buf))
+
+	expected = `-- Migration of schema calibration from version 1 to version 3
+CREATE SCHEMA IF NOT EXISTS "calibration";
+SET search_path TO "calibration", public;
+CREATE TABLE IF NOT EXISTS "calibration".gopg_migrations (id serial, version bigint, created_at timestamptz);
+
+-- ----------------------------------------------------------------
+-- Schema version 2 (up)
+-- ----------------------------------------------------------------
+BEGIN;
+CREATE TABLE IF NOT EXISTS "calibration".a (x int);
+INSERT INTO "calibration".gopg_migrations (version, created_at) VALUES (2, now());
+COMMIT;
+
+-- ----------------------------------------------------------------
+-- Schema version 3 (up)
+-- ----------------------------------------------------------------
+BEGIN;
+CREATE TABLE IF NOT EXISTS "calibration".b (x int);
+INSERT INTO "calibration".gopg_migrations (version, created_at) VALUES (3, now());
+COMMIT;
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 17 adds internal messages extracted from vm execution traces
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "internal_messages" (
+	"height" bigint NOT NULL,
+	"parent_message_cid" text NOT NULL,
+	"idx" bigint NOT NULL,
+	"cid" text NOT NULL,
+	"depth" bigint NOT NULL,
+	"from" text NOT NULL,
+	"to" text NOT NULL,
+	"value" text NOT NULL,
+	"method" bigint NOT NULL,
+	"exit_code" bigint NOT NULL,
+	"gas_used" bigint NOT NULL,
+	"implicit" boolean NOT NULL,
+	PRIMARY KEY ("height", "parent_message_cid", "idx")
+);
+CREATE INDEX IF NOT EXISTS "internal_messages_from_idx" ON public.internal_messages USING HASH ("from");
+CREATE INDEX IF NOT EXISTS "internal_messages_to_idx" ON public.internal_messages USING HASH ("to");
+
+-- Convert internal_messages to a hypertable partitioned on height (time)
+-- Assume ~500 internal messages per epoch including implicit messages, ~300 bytes per table row
+-- Height chunked per day so we expect 2880*500 = ~1440000 rows per chunk, ~412MiB per chunk
+SELECT create_hypertable(
+	'internal_messages',
+	'height',
+	chunk_time_interval => 2880,
+	if_not_exists => TRUE
+);
+
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS internal_messages_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS internal_messages_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS internal_messages_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_internal_messages_idx" ON public.visor_processing_tipsets USING BTREE (height,internal_messages_claimed_until,internal_messages_completed_at);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS visor_processing_tipsets_internal_messages_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS internal_messages_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS internal_messages_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS internal_messages_errors_detected;
+
+DROP TABLE IF EXISTS public.internal_messages;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
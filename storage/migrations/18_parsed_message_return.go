@@ -0,0 +1,19 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 18 adds decoded return values to parsed messages
+
+func init() {
+	up := batch(`
+ALTER TABLE public.parsed_messages ADD COLUMN IF NOT EXISTS "return" jsonb;
+`)
+
+	down := batch(`
+ALTER TABLE public.parsed_messages DROP COLUMN IF EXISTS "return";
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 19 tracks parsed message derivation separately from message processing
+
+func init() {
+	up := batch(`
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS parsed_messages_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS parsed_messages_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS parsed_messages_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_parsed_messages_idx" ON public.visor_processing_tipsets USING BTREE (height,parsed_messages_claimed_until,parsed_messages_completed_at);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS visor_processing_tipsets_parsed_messages_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS parsed_messages_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS parsed_messages_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS parsed_messages_errors_detected;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 20 adds destination actor codes and method names to messages and gas outputs
+
+func init() {
+	up := batch(`
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS actor_code text;
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS method_name text;
+
+ALTER TABLE public.derived_gas_outputs ADD COLUMN IF NOT EXISTS actor_code text;
+ALTER TABLE public.derived_gas_outputs ADD COLUMN IF NOT EXISTS method_name text;
+`)
+
+	down := batch(`
+ALTER TABLE public.derived_gas_outputs DROP COLUMN IF EXISTS method_name;
+ALTER TABLE public.derived_gas_outputs DROP COLUMN IF EXISTS actor_code;
+
+ALTER TABLE public.messages DROP COLUMN IF EXISTS method_name;
+ALTER TABLE public.messages DROP COLUMN IF EXISTS actor_code;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
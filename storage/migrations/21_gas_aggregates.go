@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 21 adds per tipset gas usage aggregated by actor type and method
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "derived_gas_aggregates" (
+	"height" bigint NOT NULL,
+	"state_root" text NOT NULL,
+	"actor_code" text NOT NULL,
+	"method" bigint NOT NULL,
+	"method_name" text,
+	"message_count" bigint NOT NULL,
+	"gas_used" bigint NOT NULL,
+	"base_fee_burn" numeric NOT NULL,
+	"over_estimation_burn" numeric NOT NULL,
+	"miner_tip" numeric NOT NULL,
+	PRIMARY KEY ("height", "state_root", "actor_code", "method")
+);
+
+-- Convert derived_gas_aggregates to a hypertable partitioned on height (time)
+-- Assume ~30 actor and method combinations per epoch, ~150 bytes per table row
+-- Height chunked per week so we expect 20160*30 = ~604800 rows per chunk, ~87MiB per chunk
+SELECT create_hypertable(
+	'derived_gas_aggregates',
+	'height',
+	chunk_time_interval => 20160,
+	if_not_exists => TRUE
+);
+
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS gas_aggregates_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS gas_aggregates_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS gas_aggregates_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_gas_aggregates_idx" ON public.visor_processing_tipsets USING BTREE (height,gas_aggregates_claimed_until,gas_aggregates_completed_at);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS visor_processing_tipsets_gas_aggregates_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS gas_aggregates_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS gas_aggregates_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS gas_aggregates_errors_detected;
+
+DROP TABLE IF EXISTS public.derived_gas_aggregates;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 22 adds messages observed in the mempool
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "mempool_messages" (
+	"cid" text NOT NULL,
+	"from" text NOT NULL,
+	"to" text NOT NULL,
+	"value" text NOT NULL,
+	"gas_fee_cap" text NOT NULL,
+	"gas_premium" text NOT NULL,
+	"gas_limit" bigint,
+	"size_bytes" bigint,
+	"nonce" bigint,
+	"method" bigint,
+	"first_seen" timestamptz NOT NULL,
+	"removed_at" timestamptz,
+	"replaces" text,
+	"replaced_by" text,
+	"included_height" bigint,
+	PRIMARY KEY ("cid")
+);
+CREATE INDEX IF NOT EXISTS "mempool_messages_from_nonce_idx" ON public.mempool_messages USING BTREE ("from", nonce);
+CREATE INDEX IF NOT EXISTS "mempool_messages_first_seen_idx" ON public.mempool_messages USING BTREE (first_seen);
+`)
+
+	down := batch(`
+DROP TABLE IF EXISTS public.mempool_messages;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 23 adds the rewards earned by the miner of each block
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "derived_block_rewards" (
+	"height" bigint NOT NULL,
+	"cid" text NOT NULL,
+	"miner" text NOT NULL,
+	"state_root" text NOT NULL,
+	"win_count" bigint NOT NULL,
+	"message_count" bigint NOT NULL,
+	"block_reward" numeric NOT NULL,
+	"miner_tips" numeric NOT NULL,
+	"miner_penalties" numeric NOT NULL,
+	PRIMARY KEY ("height", "cid")
+);
+CREATE INDEX IF NOT EXISTS "derived_block_rewards_miner_idx" ON public.derived_block_rewards USING HASH (miner);
+
+-- Convert derived_block_rewards to a hypertable partitioned on height (time)
+-- Assume ~5 blocks per epoch, ~250 bytes per table row
+-- Height chunked per week so we expect 20160*5 = ~100800 rows per chunk, ~24MiB per chunk
+SELECT create_hypertable(
+	'derived_block_rewards',
+	'height',
+	chunk_time_interval => 20160,
+	if_not_exists => TRUE
+);
+
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS block_rewards_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS block_rewards_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS block_rewards_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_block_rewards_idx" ON public.visor_processing_tipsets USING BTREE (height,block_rewards_claimed_until,block_rewards_completed_at);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS visor_processing_tipsets_block_rewards_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS block_rewards_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS block_rewards_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS block_rewards_errors_detected;
+
+DROP TABLE IF EXISTS public.derived_block_rewards;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
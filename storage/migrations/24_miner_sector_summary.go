@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 24 adds a summary of the current state of each miner sector
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "derived_miner_sectors" (
+	"miner_id" text NOT NULL,
+	"sector_id" bigint NOT NULL,
+	"status" text NOT NULL,
+	"precommit_height" bigint,
+	"activation_height" bigint,
+	"expiration_height" bigint,
+	"termination_height" bigint,
+	"fault_count" bigint NOT NULL,
+	"deal_ids" jsonb,
+	"last_event" text NOT NULL,
+	"last_event_height" bigint NOT NULL,
+	PRIMARY KEY ("miner_id", "sector_id")
+);
+CREATE INDEX IF NOT EXISTS "derived_miner_sectors_status_idx" ON public.derived_miner_sectors USING BTREE (miner_id, status);
+
+-- Records how far each derived task that processes heights in order has progressed
+CREATE TABLE IF NOT EXISTS "visor_processing_watermarks" (
+	"name" text NOT NULL,
+	"height" bigint NOT NULL,
+	"updated_at" timestamptz NOT NULL,
+	PRIMARY KEY ("name")
+);
+`)
+
+	down := batch(`
+DROP TABLE IF EXISTS public.visor_processing_watermarks;
+DROP TABLE IF EXISTS public.derived_miner_sectors;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
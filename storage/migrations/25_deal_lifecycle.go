@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 25 adds the lifecycle of each storage deal
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "derived_deals" (
+	"deal_id" bigint NOT NULL,
+	"client_id" text NOT NULL,
+	"provider_id" text NOT NULL,
+	"piece_cid" text NOT NULL,
+	"padded_piece_size" bigint NOT NULL,
+	"is_verified" boolean NOT NULL,
+	"start_epoch" bigint NOT NULL,
+	"end_epoch" bigint NOT NULL,
+	"status" text NOT NULL,
+	"published_height" bigint NOT NULL,
+	"activation_height" bigint,
+	"sector_id" bigint,
+	"slash_height" bigint,
+	"expiration_height" bigint,
+	"last_update_height" bigint NOT NULL,
+	PRIMARY KEY ("deal_id")
+);
+CREATE INDEX IF NOT EXISTS "derived_deals_status_idx" ON public.derived_deals USING BTREE (status, end_epoch);
+CREATE INDEX IF NOT EXISTS "derived_deals_provider_idx" ON public.derived_deals USING BTREE (provider_id, status);
+CREATE INDEX IF NOT EXISTS "derived_deals_client_idx" ON public.derived_deals USING BTREE (client_id, status);
+`)
+
+	down := batch(`
+DROP TABLE IF EXISTS public.derived_deals;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 26 adds window PoSts and fault changes per miner deadline partition
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "miner_partition_posts" (
+	"height" bigint NOT NULL,
+	"miner_id" text NOT NULL,
+	"deadline_index" bigint NOT NULL,
+	"partition_index" bigint NOT NULL,
+	"state_root" text NOT NULL,
+	"period_start" bigint NOT NULL,
+	"on_time" boolean NOT NULL,
+	"post_message_cid" text,
+	"proven_sectors" bigint NOT NULL,
+	"skipped_sectors" jsonb,
+	PRIMARY KEY ("height", "miner_id", "deadline_index", "partition_index")
+);
+
+-- Convert miner_partition_posts to a hypertable partitioned on height (time)
+-- Assume ~100 per epoch, ~200 bytes per table row
+-- Height chunked per day so we expect 2880*100 = ~288000 rows per chunk, ~55MiB per chunk
+SELECT create_hypertable(
+	'miner_partition_posts',
+	'height',
+	chunk_time_interval => 2880,
+	if_not_exists => TRUE
+);
+CREATE INDEX IF NOT EXISTS "miner_partition_posts_miner_idx" ON public.miner_partition_posts USING BTREE (miner_id, height DESC);
+
+CREATE TABLE IF NOT EXISTS "miner_partition_faults" (
+	"height" bigint NOT NULL,
+	"miner_id" text NOT NULL,
+	"deadline_index" bigint NOT NULL,
+	"partition_index" bigint NOT NULL,
+	"state_root" text NOT NULL,
+	"deadline_closed" boolean NOT NULL,
+	"faulted" jsonb,
+	"recovering" jsonb,
+	"recovered" jsonb,
+	"removed" jsonb,
+	PRIMARY KEY ("height", "miner_id", "deadline_index", "partition_index")
+);
+
+-- Convert miner_partition_faults to a hypertable partitioned on height (time)
+-- Assume ~10 per epoch, ~200 bytes per table row
+-- Height chunked per 7 days so we expect 20160*10 = ~201600 rows per chunk, ~38MiB per chunk
+SELECT create_hypertable(
+	'miner_partition_faults',
+	'height',
+	chunk_time_interval => 20160,
+	if_not_exists => TRUE
+);
+CREATE INDEX IF NOT EXISTS "miner_partition_faults_miner_idx" ON public.miner_partition_faults USING BTREE (miner_id, height DESC);
+`)
+
+	down := batch(`
+DROP TABLE IF EXISTS public.miner_partition_faults;
+DROP TABLE IF EXISTS public.miner_partition_posts;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
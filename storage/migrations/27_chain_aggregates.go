@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 27 adds aggregates of block counts per miner, gas economy and chain power that can be refreshed
+// incrementally. They are TimescaleDB continuous aggregates, which schema version 1 already requires.
+
+func init() {
+	up := batch(`
+DO $$
+DECLARE
+	-- name, bucket width in epochs, source hypertable, aggregated columns, additional grouping columns
+	aggs text[][] := ARRAY[
+		ARRAY['chain_miner_blocks_daily', '2880', 'block_headers',
+			'miner, count(*) AS blocks, sum(win_count) AS win_count',
+			', miner'],
+		ARRAY['chain_gas_economy_hourly', '120', 'message_gas_economy',
+			'count(*) AS tipsets, avg(base_fee) AS base_fee_avg, min(base_fee) AS base_fee_min, max(base_fee) AS base_fee_max, '
+			'sum(gas_limit_total) AS gas_limit_total, sum(gas_limit_unique_total) AS gas_limit_unique_total, '
+			'avg(gas_fill_ratio) AS gas_fill_ratio_avg, avg(gas_capacity_ratio) AS gas_capacity_ratio_avg, avg(gas_waste_ratio) AS gas_waste_ratio_avg',
+			''],
+		ARRAY['chain_power_daily', '2880', 'chain_powers',
+			'max(total_raw_bytes_power::numeric) AS total_raw_bytes_power_max, avg(total_raw_bytes_power::numeric) AS total_raw_bytes_power_avg, '
+			'max(total_qa_bytes_power::numeric) AS total_qa_bytes_power_max, avg(total_qa_bytes_power::numeric) AS total_qa_bytes_power_avg, '
+			'max(total_pledge_collateral::numeric) AS total_pledge_collateral_max, '
+			'max(miner_count) AS miner_count_max, max(participating_miner_count) AS participating_miner_count_max',
+			'']
+	];
+	tsversion text;
+	agg text[];
+	bucket text;
+BEGIN
+	SELECT extversion INTO tsversion FROM pg_extension WHERE extname = 'timescaledb';
+
+	FOREACH agg SLICE 1 IN ARRAY aggs LOOP
+		-- Continuous aggregates over hypertables partitioned by height need a function returning the current height
+		EXECUTE format('CREATE OR REPLACE FUNCTION %I() RETURNS bigint LANGUAGE sql STABLE AS %L',
+			agg[3] || '_current_height', format('SELECT COALESCE(max(height), 0) FROM %I', agg[3]));
+		PERFORM set_integer_now_func(agg[3], agg[3] || '_current_height', replace_if_exists => true);
+
+		bucket := format('time_bucket(%s, height)', agg[2]);
+		IF tsversion LIKE '1.%' THEN
+			EXECUTE format('CREATE VIEW %I WITH (timescaledb.continuous, timescaledb.refresh_lag = %L) AS SELECT %s AS height_bucket, %s FROM %I GROUP BY %s%s',
+				agg[1], agg[2], bucket, agg[4], agg[3], bucket, agg[5]);
+		ELSE
+			EXECUTE format('CREATE MATERIALIZED VIEW %I WITH (timescaledb.continuous) AS SELECT %s AS height_bucket, %s FROM %I GROUP BY %s%s WITH NO DATA',
+				agg[1], bucket, agg[4], agg[3], bucket, agg[5]);
+		END IF;
+	END LOOP;
+END
+$$;
+`)
+
+	down := batch(`
+DO $$
+DECLARE
+	tsversion text;
+	agg text;
+BEGIN
+	SELECT extversion INTO tsversion FROM pg_extension WHERE extname = 'timescaledb';
+
+	FOREACH agg IN ARRAY ARRAY['chain_miner_blocks_daily', 'chain_gas_economy_hourly', 'chain_power_daily'] LOOP
+		-- Continuous aggregates are views that must be dropped as materialized views from timescaledb 2.0
+		IF tsversion LIKE '1.%' THEN
+			EXECUTE format('DROP VIEW IF EXISTS %I CASCADE', agg);
+		ELSE
+			EXECUTE format('DROP MATERIALIZED VIEW IF EXISTS %I', agg);
+		END IF;
+	END LOOP;
+END
+$$;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
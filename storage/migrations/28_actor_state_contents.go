@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 28 adds content addressed storage of raw actor state, holding each distinct state once keyed by its
+// head cid either in full or as a diff against a previous head
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "actor_state_contents" (
+	"head" text NOT NULL,
+	"code" text NOT NULL,
+	"height" bigint NOT NULL,
+	"state" jsonb,
+	"base_head" text,
+	"diff" jsonb,
+	"diff_depth" bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY ("head"),
+	CONSTRAINT "actor_state_contents_state_or_diff" CHECK (
+		("state" IS NOT NULL AND "diff" IS NULL AND "base_head" IS NULL AND "diff_depth" = 0) OR
+		("state" IS NULL AND "diff" IS NOT NULL AND "base_head" IS NOT NULL AND "diff_depth" > 0)
+	)
+);
+`)
+
+	down := batch(`
+DROP TABLE IF EXISTS public.actor_state_contents;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 29 adds the history of every change to an actor's balance and a lookup of balances at a height
+
+func init() {
+	up := batch(`
+CREATE TABLE IF NOT EXISTS "derived_balance_changes" (
+	"height" bigint NOT NULL,
+	"address" text NOT NULL,
+	"state_root" text NOT NULL,
+	"old_balance" numeric NOT NULL,
+	"new_balance" numeric NOT NULL,
+	"delta" numeric NOT NULL,
+	"message_cid" text,
+	PRIMARY KEY ("height", "address")
+);
+CREATE INDEX IF NOT EXISTS "derived_balance_changes_address_idx" ON public.derived_balance_changes USING BTREE (address, height DESC);
+
+-- Convert derived_balance_changes to a hypertable partitioned on height (time)
+-- Assume ~500 balance changes per epoch, ~200 bytes per table row
+-- Height chunked per day so we expect 2880*500 = ~1440000 rows per chunk, ~275MiB per chunk
+SELECT create_hypertable(
+	'derived_balance_changes',
+	'height',
+	chunk_time_interval => 2880,
+	if_not_exists => TRUE
+);
+
+-- The balance of an address at a height is the new balance of its most recent change at or before the height
+CREATE OR REPLACE FUNCTION public.balance_at_height(addr text, at_height bigint) RETURNS numeric AS $$
+	SELECT new_balance FROM public.derived_balance_changes
+	WHERE address = addr AND height <= at_height
+	ORDER BY height DESC
+	LIMIT 1
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS balance_changes_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS balance_changes_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS balance_changes_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_balance_changes_idx" ON public.visor_processing_tipsets USING BTREE (height,balance_changes_claimed_until,balance_changes_completed_at);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS visor_processing_tipsets_balance_changes_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS balance_changes_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS balance_changes_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS balance_changes_errors_detected;
+
+DROP FUNCTION IF EXISTS public.balance_at_height(text, bigint);
+DROP TABLE IF EXISTS public.derived_balance_changes;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
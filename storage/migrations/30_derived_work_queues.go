@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 30 replaces the processing watermarks of the miner sector summary and deal lifecycle with work queues
+// of tipsets so that heights indexed out of order, such as those backfilled by the history indexer, are not missed
+
+func init() {
+	up := batch(`
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS miner_sectors_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS miner_sectors_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS miner_sectors_errors_detected text;
+
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS deals_claimed_until timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS deals_completed_at timestamptz;
+ALTER TABLE public.visor_processing_tipsets ADD COLUMN IF NOT EXISTS deals_errors_detected text;
+
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_miner_sectors_idx" ON public.visor_processing_tipsets USING BTREE (height,miner_sectors_claimed_until,miner_sectors_completed_at);
+CREATE INDEX IF NOT EXISTS "visor_processing_tipsets_deals_idx" ON public.visor_processing_tipsets USING BTREE (height,deals_claimed_until,deals_completed_at);
+
+-- Sectors and deals touched by a tipset are rebuilt from all of their changes
+CREATE INDEX IF NOT EXISTS "miner_sector_events_sector_idx" ON public.miner_sector_events USING BTREE (miner_id, sector_id);
+CREATE INDEX IF NOT EXISTS "market_deal_proposals_deal_id_idx" ON public.market_deal_proposals USING BTREE (deal_id);
+CREATE INDEX IF NOT EXISTS "market_deal_states_deal_id_idx" ON public.market_deal_states USING BTREE (deal_id);
+CREATE INDEX IF NOT EXISTS "miner_sector_deals_deal_id_idx" ON public.miner_sector_deals USING BTREE (deal_id);
+
+DROP TABLE IF EXISTS public.visor_processing_watermarks;
+`)
+
+	down := batch(`
+CREATE TABLE IF NOT EXISTS "visor_processing_watermarks" (
+	"name" text NOT NULL,
+	"height" bigint NOT NULL,
+	"updated_at" timestamptz NOT NULL,
+	PRIMARY KEY ("name")
+);
+
+DROP INDEX IF EXISTS miner_sector_deals_deal_id_idx;
+DROP INDEX IF EXISTS market_deal_states_deal_id_idx;
+DROP INDEX IF EXISTS market_deal_proposals_deal_id_idx;
+DROP INDEX IF EXISTS miner_sector_events_sector_idx;
+
+DROP INDEX IF EXISTS visor_processing_tipsets_deals_idx;
+DROP INDEX IF EXISTS visor_processing_tipsets_miner_sectors_idx;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS deals_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS deals_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS deals_errors_detected;
+
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS miner_sectors_claimed_until;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS miner_sectors_completed_at;
+ALTER TABLE public.visor_processing_tipsets DROP COLUMN IF EXISTS miner_sectors_errors_detected;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// Schema version 31 adds a unique index to derived_consensus_chain_view so it can be refreshed concurrently without
+// blocking queries while the refresh runs
+
+func init() {
+	up := batch(`
+CREATE UNIQUE INDEX IF NOT EXISTS "derived_consensus_chain_view_cid_idx" ON public.derived_consensus_chain_view USING BTREE (cid);
+`)
+
+	down := batch(`
+DROP INDEX IF EXISTS derived_consensus_chain_view_cid_idx;
+`)
+
+	migrations.MustRegisterTx(up, down)
+}
@@ -1,17 +1,46 @@
 package migrations
 
 import (
+	"strings"
+
 	"github.com/go-pg/migrations/v8"
+	"github.com/go-pg/pg/v10"
 )
 
 // batch is a syntactic helper for registering a migration
 func batch(sqls ...string) func(db migrations.DB) error {
 	return func(db migrations.DB) error {
+		schema, err := currentSchema(db)
+		if err != nil {
+			return err
+		}
+
 		for _, sql := range sqls {
-			if _, err := db.Exec(sql); err != nil {
+			if _, err := db.Exec(inSchema(sql, schema)); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 }
+
+// currentSchema returns the schema that unqualified names are created in.
+func currentSchema(db migrations.DB) (string, error) {
+	if r, ok := db.(*Recorder); ok {
+		return r.Schema, nil
+	}
+
+	var schema string
+	if _, err := db.QueryOne(pg.Scan(&schema), `SELECT current_schema()`); err != nil {
+		return "", err
+	}
+	return schema, nil
+}
+
+// inSchema rewrites names that migrations qualify with the public schema to use schema instead.
+func inSchema(sql string, schema string) string {
+	if schema == "public" {
+		return sql
+	}
+	return strings.ReplaceAll(sql, "public.", `"`+strings.ReplaceAll(schema, `"`, `""`)+`".`)
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInSchema(t *testing.T) {
+	sql := `CREATE INDEX IF NOT EXISTS "blocks_idx" ON public.blocks USING BTREE (height);`
+
+	assert.Equal(t, sql, inSchema(sql, "public"))
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "blocks_idx" ON "devnet".blocks USING BTREE (height);`, inSchema(sql, "devnet"))
+}
+
+func TestRecord(t *testing.T) {
+	up := batch(`CREATE TABLE public.a (id int);`, `CREATE INDEX ON public.a (id);`)
+
+	stmts, err := Record(up, "devnet")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`CREATE TABLE "devnet".a (id int);`, `CREATE INDEX ON "devnet".a (id);`}, stmts)
+
+	stmts, err = Record(nil, "public")
+	require.NoError(t, err)
+	assert.Len(t, stmts, 0)
+}
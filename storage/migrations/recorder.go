@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations/v8"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// A Recorder is a migrations.DB that records the statements a migration executes instead of executing them. Only
+// the methods used by migrations registered with batch are supported.
+type Recorder struct {
+	migrations.DB
+
+	// Schema is the schema the migration is planned against.
+	Schema string
+
+	// Statements are the statements executed by the migration, in order.
+	Statements []string
+}
+
+func (r *Recorder) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
+	if len(params) > 0 {
+		return nil, fmt.Errorf("recording statements with parameters is not supported")
+	}
+	r.Statements = append(r.Statements, fmt.Sprint(query))
+	return nil, nil
+}
+
+// Record returns the statements that fn would execute when migrating schema.
+func Record(fn func(migrations.DB) error, schema string) ([]string, error) {
+	if fn == nil {
+		return nil, nil
+	}
+	r := &Recorder{Schema: schema}
+	if err := fn(r); err != nil {
+		return nil, err
+	}
+	return r.Statements, nil
+}
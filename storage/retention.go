@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+)
+
+// A RetentionPolicy controls how much of the data in a table partitioned by height is retained. The most recent
+// Epochs heights are always retained in full. When Interval is zero older data is removed, otherwise older data is
+// downsampled by retaining only the heights that are a multiple of Interval. A policy with zero Epochs retains
+// everything unless Referenced is set, in which case only the rows still referenced by other tables are retained.
+type RetentionPolicy struct {
+	Table      string
+	Epochs     int64
+	Interval   int64
+	Referenced bool
+}
+
+// RetainAll reports whether the policy retains all data in the table.
+func (p RetentionPolicy) RetainAll() bool {
+	return p.Epochs == 0 && !p.Referenced
+}
+
+// String returns the policy in the form parsed by ParseRetentionPolicy.
+func (p RetentionPolicy) String() string {
+	switch {
+	case p.Referenced:
+		return p.Table + "=referenced"
+	case p.RetainAll():
+		return p.Table + "=all"
+	case p.Interval != 0:
+		return fmt.Sprintf("%s=%d/%d", p.Table, p.Epochs, p.Interval)
+	default:
+		return fmt.Sprintf("%s=%d", p.Table, p.Epochs)
+	}
+}
+
+// unretainedTables lists the tables that retention policies may not remove data from by height, with the reason why.
+// Rows in actor_state_contents are keyed by head rather than height: a row at an old height may still be the current
+// state of an actor or the base that later diffs are applied to.
+var unretainedTables = map[string]string{
+	"actor_state_contents": "states are shared across heights and diffs depend on older states, use actor_state_contents=referenced instead",
+}
+
+// changeOnlyTables lists the tables that only have a row for an entity at the heights where it changed rather than at
+// every height. Downsampling them would keep only the entities that happened to change at a multiple of the interval,
+// not the state at those heights, so they may only have older data removed.
+var changeOnlyTables = map[string]bool{
+	"actors":                       true,
+	"actor_states":                 true,
+	"derived_balance_changes":      true,
+	"id_addresses":                 true,
+	"market_deal_proposals":        true,
+	"market_deal_states":           true,
+	"miner_current_deadline_infos": true,
+	"miner_fee_debts":              true,
+	"miner_infos":                  true,
+	"miner_locked_funds":           true,
+	"miner_pre_commit_infos":       true,
+	"miner_sector_deals":           true,
+	"miner_sector_events":          true,
+	"miner_sector_infos":           true,
+	"power_actor_claims":           true,
+}
+
+// referencedRetention holds the statement used to delete the rows of a table that are no longer referenced by the
+// data retained in other tables. Contents of actor state are needed while an actor row refers to their head or while
+// they are the base of a diff that is needed.
+var referencedRetention = map[string]string{
+	"actor_state_contents": `
+WITH RECURSIVE needed AS (
+	SELECT head FROM actors
+	UNION
+	SELECT c.base_head FROM actor_state_contents c JOIN needed ON c.head = needed.head WHERE c.base_head IS NOT null
+)
+DELETE FROM actor_state_contents c
+WHERE NOT EXISTS (SELECT 1 FROM needed WHERE needed.head = c.head)
+`,
+}
+
+// checkRetainable returns an error if data may not be removed from the table by the policy.
+func checkRetainable(p RetentionPolicy) error {
+	if p.Referenced {
+		if _, ok := referencedRetention[p.Table]; !ok {
+			return xerrors.Errorf("retention policies cannot retain only the referenced rows of %s", p.Table)
+		}
+		return nil
+	}
+	if reason, ok := unretainedTables[p.Table]; ok {
+		return xerrors.Errorf("retention policies cannot remove data from %s: %s", p.Table, reason)
+	}
+	if p.Interval != 0 && changeOnlyTables[p.Table] {
+		return xerrors.Errorf("retention policies cannot downsample %s: it only has rows at the heights where an entity changed", p.Table)
+	}
+	return nil
+}
+
+// ParseRetentionPolicy parses a policy of the form table=all to retain everything, table=N to retain the most recent
+// N epochs, table=N/M to retain the most recent N epochs and one height in every M before that or table=referenced to
+// retain only the rows still referenced by other tables.
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return RetentionPolicy{}, xerrors.Errorf("invalid retention policy %q: expected table=all, table=epochs, table=epochs/interval or table=referenced", s)
+	}
+
+	p := RetentionPolicy{Table: parts[0]}
+	switch parts[1] {
+	case "all":
+		return p, nil
+	case "referenced":
+		p.Referenced = true
+		if err := checkRetainable(p); err != nil {
+			return RetentionPolicy{}, xerrors.Errorf("invalid retention policy %q: %w", s, err)
+		}
+		return p, nil
+	}
+
+	keep := strings.SplitN(parts[1], "/", 2)
+
+	var err error
+	p.Epochs, err = strconv.ParseInt(keep[0], 10, 64)
+	if err != nil || p.Epochs <= 0 {
+		return RetentionPolicy{}, xerrors.Errorf("invalid retention policy %q: epochs must be a positive integer", s)
+	}
+
+	if len(keep) == 2 {
+		p.Interval, err = strconv.ParseInt(keep[1], 10, 64)
+		if err != nil || p.Interval <= 0 {
+			return RetentionPolicy{}, xerrors.Errorf("invalid retention policy %q: interval must be a positive integer", s)
+		}
+	}
+
+	if err := checkRetainable(p); err != nil {
+		return RetentionPolicy{}, xerrors.Errorf("invalid retention policy %q: %w", s, err)
+	}
+
+	return p, nil
+}
+
+// A RetentionResult reports the data removed from a table when applying a retention policy.
+type RetentionResult struct {
+	Policy        RetentionPolicy
+	Before        int64 // height before which data was removed or downsampled, zero if nothing was eligible
+	ChunksDropped int   // number of hypertable chunks dropped
+	RowsDeleted   int   // number of rows deleted
+}
+
+// ApplyRetentionPolicy removes the data in a table that is older than the policy retains. Data is removed from
+// hypertables by dropping the chunks that only contain heights older than the retained epochs, so some older data
+// remains until the rest of its chunk is old enough to be dropped. Data is removed from other tables, and
+// downsampled in all tables, by deleting rows. Policies that retain only referenced rows delete the rows no longer
+// referenced. Policies that remove data from a table listed in unretainedTables or downsample a table listed in
+// changeOnlyTables are refused.
+func (d *Database) ApplyRetentionPolicy(ctx context.Context, p RetentionPolicy) (*RetentionResult, error) {
+	res := &RetentionResult{Policy: p}
+	if p.RetainAll() {
+		return res, nil
+	}
+
+	if err := checkRetainable(p); err != nil {
+		return nil, err
+	}
+
+	if p.Referenced {
+		r, err := d.DB.ExecContext(ctx, referencedRetention[p.Table])
+		if err != nil {
+			return nil, xerrors.Errorf("delete unreferenced rows from %s: %w", p.Table, err)
+		}
+		res.RowsDeleted = r.RowsAffected()
+		return res, nil
+	}
+
+	var hasHeight bool
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&hasHeight), `
+SELECT EXISTS (
+	SELECT 1 FROM information_schema.columns
+	WHERE table_schema = ? AND table_name = ? AND column_name = 'height'
+)`, d.Schema(), p.Table); err != nil {
+		return nil, xerrors.Errorf("check %s: %w", p.Table, err)
+	}
+	if !hasHeight {
+		return nil, xerrors.Errorf("table %s does not exist or has no height column", p.Table)
+	}
+
+	var maxHeight int64
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&maxHeight), `SELECT COALESCE(max(height), -1) FROM ?`, pg.Ident(p.Table)); err != nil {
+		return nil, xerrors.Errorf("get %s height: %w", p.Table, err)
+	}
+
+	// An empty table has a max height of -1 so there is never anything to remove
+	before := maxHeight - p.Epochs + 1
+	if before <= 0 {
+		return res, nil
+	}
+	res.Before = before
+
+	if p.Interval != 0 {
+		r, err := d.DB.ExecContext(ctx, `DELETE FROM ? WHERE height < ? AND height % ? <> 0`, pg.Ident(p.Table), before, p.Interval)
+		if err != nil {
+			return nil, xerrors.Errorf("downsample %s: %w", p.Table, err)
+		}
+		res.RowsDeleted = r.RowsAffected()
+		return res, nil
+	}
+
+	version, err := d.timescaleVersion(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("get timescaledb version: %w", err)
+	}
+
+	hypertable := false
+	if version != "" {
+		if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&hypertable), `SELECT EXISTS (SELECT 1 FROM _timescaledb_catalog.hypertable WHERE schema_name = ? AND table_name = ?)`, d.Schema(), p.Table); err != nil {
+			return nil, xerrors.Errorf("check %s is a hypertable: %w", p.Table, err)
+		}
+	}
+
+	if !hypertable {
+		r, err := d.DB.ExecContext(ctx, `DELETE FROM ? WHERE height < ?`, pg.Ident(p.Table), before)
+		if err != nil {
+			return nil, xerrors.Errorf("delete from %s: %w", p.Table, err)
+		}
+		res.RowsDeleted = r.RowsAffected()
+		return res, nil
+	}
+
+	// The signature of drop_chunks changed in timescaledb 2.0
+	dropChunks := `SELECT count(*) FROM drop_chunks(format('%I.%I', ?, ?)::regclass, older_than => ?::bigint)`
+	args := []interface{}{d.Schema(), p.Table, before}
+	if strings.HasPrefix(version, "1.") {
+		dropChunks = `SELECT count(*) FROM drop_chunks(older_than => ?::bigint, table_name => ?, schema_name => ?)`
+		args = []interface{}{before, p.Table, d.Schema()}
+	}
+
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(&res.ChunksDropped), dropChunks, args...); err != nil {
+		return nil, xerrors.Errorf("drop chunks from %s: %w", p.Table, err)
+	}
+	return res, nil
+}
+
+// timescaleVersion returns the version of the installed timescaledb extension or an empty string if it is not
+// installed.
+func (d *Database) timescaleVersion(ctx context.Context) (string, error) {
+	var versions []string
+	if _, err := d.DB.QueryOneContext(ctx, pg.Scan(pg.Array(&versions)), `SELECT COALESCE(array_agg(extversion::text), '{}') FROM pg_extension WHERE extname = 'timescaledb'`); err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", nil
+	}
+	return versions[0], nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/model/actors/common"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected RetentionPolicy
+		err      bool
+	}{
+		{in: "actor_states=all", expected: RetentionPolicy{Table: "actor_states"}},
+		{in: "actor_states=2880", expected: RetentionPolicy{Table: "actor_states", Epochs: 2880}},
+		{in: "chain_powers=2880/120", expected: RetentionPolicy{Table: "chain_powers", Epochs: 2880, Interval: 120}},
+		{in: "actor_states", err: true},
+		{in: "=2880", err: true},
+		{in: "actor_states=", err: true},
+		{in: "actor_states=0", err: true},
+		{in: "actor_states=-5", err: true},
+		{in: "actor_states=some", err: true},
+		{in: "chain_powers=2880/0", err: true},
+		{in: "chain_powers=2880/x", err: true},
+		{in: "actor_state_contents=all", expected: RetentionPolicy{Table: "actor_state_contents"}},
+		{in: "actor_state_contents=2880", err: true},
+		{in: "actor_state_contents=2880/120", err: true},
+		{in: "actor_state_contents=referenced", expected: RetentionPolicy{Table: "actor_state_contents", Referenced: true}},
+		{in: "actor_states=referenced", err: true},
+		{in: "actor_states=2880/120", err: true},
+		{in: "miner_sector_events=2880/120", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			p, err := ParseRetentionPolicy(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, p)
+
+			// Policies can be parsed from their string form
+			assert.Equal(t, tc.in, p.String())
+		})
+	}
+}
+
+func TestApplyRetentionPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	d := &Database{
+		DB:    db,
+		Clock: testutil.NewMockClock(),
+	}
+
+	// fill recreates a table holding one row at each height from 0 to 9
+	fill := func(table string) {
+		_, err := db.Exec(`DROP TABLE IF EXISTS ?`, pg.Ident(table))
+		require.NoError(t, err)
+		_, err = db.Exec(`CREATE TABLE ? (height bigint NOT NULL)`, pg.Ident(table))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, _ = db.Exec(`DROP TABLE IF EXISTS ?`, pg.Ident(table))
+		})
+		_, err = db.Exec(`INSERT INTO ? SELECT generate_series(0, 9)`, pg.Ident(table))
+		require.NoError(t, err)
+	}
+
+	heights := func(table string) []int64 {
+		var hs []int64
+		_, err := db.Query(&hs, `SELECT height FROM ? ORDER BY height`, pg.Ident(table))
+		require.NoError(t, err)
+		return hs
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		fill("retention_test")
+		res, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "retention_test", Epochs: 4})
+		require.NoError(t, err)
+		assert.EqualValues(t, 6, res.Before)
+		assert.Equal(t, 6, res.RowsDeleted)
+		assert.Equal(t, []int64{6, 7, 8, 9}, heights("retention_test"))
+	})
+
+	t.Run("downsample", func(t *testing.T) {
+		fill("retention_test")
+		res, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "retention_test", Epochs: 4, Interval: 3})
+		require.NoError(t, err)
+		assert.Equal(t, 4, res.RowsDeleted)
+		assert.Equal(t, []int64{0, 3, 6, 7, 8, 9}, heights("retention_test"))
+	})
+
+	t.Run("retain all", func(t *testing.T) {
+		fill("retention_test")
+		res, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "retention_test"})
+		require.NoError(t, err)
+		assert.Equal(t, 0, res.RowsDeleted)
+		assert.Len(t, heights("retention_test"), 10)
+	})
+
+	t.Run("hypertable", func(t *testing.T) {
+		version, err := d.timescaleVersion(ctx)
+		require.NoError(t, err)
+		if version == "" {
+			t.Skip("timescaledb is not installed")
+		}
+
+		fill("retention_test_hyper")
+		_, err = db.Exec(`SELECT create_hypertable('retention_test_hyper', 'height', chunk_time_interval => 2, migrate_data => true)`)
+		require.NoError(t, err)
+
+		res, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "retention_test_hyper", Epochs: 4})
+		require.NoError(t, err)
+		assert.Equal(t, 3, res.ChunksDropped)
+		assert.Equal(t, []int64{6, 7, 8, 9}, heights("retention_test_hyper"))
+	})
+
+	t.Run("actor state contents", func(t *testing.T) {
+		var before int
+		_, err := db.QueryOne(pg.Scan(&before), `SELECT COUNT(*) FROM actor_state_contents`)
+		require.NoError(t, err)
+
+		_, err = d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "actor_state_contents", Epochs: 1})
+		assert.Error(t, err)
+
+		var after int
+		_, err = db.QueryOne(pg.Scan(&after), `SELECT COUNT(*) FROM actor_state_contents`)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("change only table", func(t *testing.T) {
+		_, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "actor_states", Epochs: 4, Interval: 3})
+		assert.Error(t, err)
+	})
+
+	t.Run("referenced actor state contents", func(t *testing.T) {
+		truncate := func() {
+			_, err := db.Exec(`TRUNCATE TABLE actors, actor_state_contents`)
+			require.NoError(t, err)
+		}
+		truncate()
+		t.Cleanup(truncate)
+
+		contents := []*common.ActorStateContent{
+			{Head: "head1", Code: "code", Height: 1, State: "{}"},
+			{Head: "head2", Code: "code", Height: 2, BaseHead: "head1", Diff: "[]", DiffDepth: 1},
+			{Head: "head3", Code: "code", Height: 3, State: "{}"},
+			{Head: "head4", Code: "code", Height: 4, BaseHead: "head3", Diff: "[]", DiffDepth: 1},
+			{Head: "head5", Code: "code", Height: 5, State: "{}"},
+			{Head: "head6", Code: "code", Height: 6, BaseHead: "head5", Diff: "[]", DiffDepth: 1},
+		}
+		_, err := db.Model(&contents).Insert()
+		require.NoError(t, err)
+
+		// head1 and head3 are only needed as the base of a referenced diff, head5 and head6 are not needed at all
+		actors := []*common.Actor{
+			{Height: 2, ID: "f01000", StateRoot: "root2", Code: "code", Head: "head2", Balance: "0"},
+			{Height: 4, ID: "f01001", StateRoot: "root4", Code: "code", Head: "head4", Balance: "0"},
+		}
+		_, err = db.Model(&actors).Insert()
+		require.NoError(t, err)
+
+		res, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "actor_state_contents", Referenced: true})
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.RowsDeleted)
+
+		var heads []string
+		_, err = db.Query(&heads, `SELECT head FROM actor_state_contents ORDER BY head`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"head1", "head2", "head3", "head4"}, heads)
+	})
+
+	t.Run("missing table", func(t *testing.T) {
+		_, err := d.ApplyRetentionPolicy(ctx, RetentionPolicy{Table: "retention_test_missing", Epochs: 4})
+		assert.Error(t, err)
+	})
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"regexp"
 	"strings"
 	"time"
 
@@ -41,6 +42,8 @@ var models = []interface{}{
 	(*miner.MinerSectorPost)(nil),
 	(*miner.MinerPreCommitInfo)(nil),
 	(*miner.MinerSectorEvent)(nil),
+	(*miner.MinerPartitionPost)(nil),
+	(*miner.MinerPartitionFault)(nil),
 	(*miner.MinerCurrentDeadlineInfo)(nil),
 	(*miner.MinerFeeDebt)(nil),
 	(*miner.MinerLockedFund)(nil),
@@ -54,11 +57,14 @@ var models = []interface{}{
 	(*messages.Receipt)(nil),
 	(*messages.MessageGasEconomy)(nil),
 	(*messages.ParsedMessage)(nil),
+	(*messages.InternalMessage)(nil),
+	(*messages.MempoolMessage)(nil),
 
 	(*power.ChainPower)(nil),
 	(*reward.ChainReward)(nil),
 	(*common.Actor)(nil),
 	(*common.ActorState)(nil),
+	(*common.ActorStateContent)(nil),
 
 	(*init_.IdAddress)(nil),
 
@@ -69,6 +75,11 @@ var models = []interface{}{
 	(*visor.ProcessingStat)(nil),
 
 	(*derived.GasOutputs)(nil),
+	(*derived.GasAggregate)(nil),
+	(*derived.BlockReward)(nil),
+	(*derived.BalanceChange)(nil),
+	(*derived.MinerSector)(nil),
+	(*derived.Deal)(nil),
 	(*chain.ChainEconomics)(nil),
 }
 
@@ -84,7 +95,16 @@ var (
 	ErrSchemaTooNew = errors.New("database schema is too new for this version of visor")
 )
 
-func NewDatabase(ctx context.Context, url string, poolSize int) (*Database, error) {
+// DefaultSchema is the Postgres schema used for visor's tables when no other schema is configured.
+const DefaultSchema = "public"
+
+// schemaName matches the names accepted for a schema. Only lowercase identifiers are accepted so the name means the
+// same whether or not it is quoted, since it is used unquoted when naming the migrations table.
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// NewDatabase creates a Database that will store its tables in schema. An empty schema uses DefaultSchema. schema
+// must be a lowercase identifier made up of letters, digits and underscores.
+func NewDatabase(ctx context.Context, url string, poolSize int, schema string) (*Database, error) {
 	opt, err := pg.ParseURL(url)
 	if err != nil {
 		return nil, xerrors.Errorf("parse database URL: %w", err)
@@ -92,29 +112,60 @@ func NewDatabase(ctx context.Context, url string, poolSize int) (*Database, erro
 	opt.PoolSize = poolSize
 	opt.ApplicationName = "visor-" + version.String()
 
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	if !schemaName.MatchString(schema) {
+		return nil, xerrors.Errorf("invalid schema name %q: must be a lowercase identifier of letters, digits and underscores", schema)
+	}
+	if schema != DefaultSchema {
+		// Every connection resolves unqualified names in the schema first. The public schema remains on the
+		// search path since that is where extensions such as timescaledb are installed.
+		opt.OnConnect = func(ctx context.Context, conn *pg.Conn) error {
+			if _, err := conn.ExecContext(ctx, `SET search_path TO ?, public`, pg.Ident(schema)); err != nil {
+				return xerrors.Errorf("set search path: %w", err)
+			}
+			return nil
+		}
+	}
+
 	return &Database{
-		opt:   opt,
-		Clock: clock.New(),
+		opt:    opt,
+		schema: schema,
+		Clock:  clock.New(),
 	}, nil
 }
 
 type Database struct {
-	DB    *pg.DB
-	opt   *pg.Options
-	Clock clock.Clock
+	DB     *pg.DB
+	opt    *pg.Options
+	schema string
+	Clock  clock.Clock
+
+	// LeaseStallLimit is the longest time a lease kept by KeepLeases is renewed without the processor reporting
+	// progress. Zero renews leases until they are released.
+	LeaseStallLimit time.Duration
+}
+
+// Schema returns the name of the Postgres schema the database uses for its tables.
+func (d *Database) Schema() string {
+	if d.schema == "" {
+		return DefaultSchema
+	}
+	return d.schema
 }
 
 // Connect opens a connection to the database and checks that the schema is compatible the the version required
 // by this version of visor. ErrSchemaTooOld is returned if the database schema is older than the current schema,
 // ErrSchemaTooNew if it is newer.
 func (d *Database) Connect(ctx context.Context) error {
-	db, err := connect(ctx, d.opt)
+	db, err := connect(ctx, d.opt, d.Schema())
 	if err != nil {
 		return xerrors.Errorf("connect: %w", err)
 	}
 
 	// Check if the version of the schema is compatible
-	dbVersion, latestVersion, err := getSchemaVersions(ctx, db)
+	dbVersion, latestVersion, err := getSchemaVersions(ctx, db, d.Schema())
 	if err != nil {
 		_ = db.Close()
 		return xerrors.Errorf("get schema versions: %w", err)
@@ -136,7 +187,7 @@ func (d *Database) Connect(ctx context.Context) error {
 	}
 }
 
-func connect(ctx context.Context, opt *pg.Options) (*pg.DB, error) {
+func connect(ctx context.Context, opt *pg.Options, schema string) (*pg.DB, error) {
 	db := pg.Connect(opt)
 	db = db.WithContext(ctx)
 	db.AddQueryHook(&pgext.OpenTelemetryHook{})
@@ -146,6 +197,14 @@ func connect(ctx context.Context, opt *pg.Options) (*pg.DB, error) {
 		return nil, xerrors.Errorf("ping database: %w", err)
 	}
 
+	// Create the schema so it is the current schema for locks and migrations
+	if schema != DefaultSchema {
+		if _, err := db.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS ?`, pg.Ident(schema)); err != nil {
+			_ = db.Close()
+			return nil, xerrors.Errorf("create schema: %w", err)
+		}
+	}
+
 	// Acquire a shared lock on the schema to notify other instances that we are running
 	if err := SchemaLock.LockShared(ctx, db); err != nil {
 		_ = db.Close()
@@ -195,25 +254,25 @@ func (d *Database) MostRecentAddedTipSet(ctx context.Context) (*visor.Processing
 func (d *Database) VerifyCurrentSchema(ctx context.Context) error {
 	// If we're already connected then use that connection
 	if d.DB != nil {
-		return verifyCurrentSchema(ctx, d.DB)
+		return verifyCurrentSchema(ctx, d.DB, d.Schema())
 	}
 
 	// Temporarily connect
-	db, err := connect(ctx, d.opt)
+	db, err := connect(ctx, d.opt, d.Schema())
 	if err != nil {
 		return xerrors.Errorf("connect: %w", err)
 	}
 	defer db.Close()
-	return verifyCurrentSchema(ctx, db)
+	return verifyCurrentSchema(ctx, db, d.Schema())
 }
 
-func verifyCurrentSchema(ctx context.Context, db *pg.DB) error {
+func verifyCurrentSchema(ctx context.Context, db *pg.DB, schema string) error {
 	valid := true
 	for _, model := range models {
 		q := db.Model(model)
 		tm := q.TableModel()
 		m := tm.Table()
-		err := verifyModel(ctx, db, m)
+		err := verifyModel(ctx, db, schema, m)
 		if err != nil {
 			valid = false
 			log.Errorf("verify schema: %v", err)
@@ -226,11 +285,11 @@ func verifyCurrentSchema(ctx context.Context, db *pg.DB) error {
 	return nil
 }
 
-func verifyModel(ctx context.Context, db *pg.DB, m *orm.Table) error {
+func verifyModel(ctx context.Context, db *pg.DB, schema string, m *orm.Table) error {
 	tableName := stripQuotes(m.SQLNameForSelects)
 
 	var exists bool
-	_, err := db.QueryOneContext(ctx, pg.Scan(&exists), `SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema='public' AND table_name=?)`, tableName)
+	_, err := db.QueryOneContext(ctx, pg.Scan(&exists), `SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema=? AND table_name=?)`, schema, tableName)
 	if err != nil {
 		return xerrors.Errorf("querying table: %v", err)
 	}
@@ -239,35 +298,43 @@ func verifyModel(ctx context.Context, db *pg.DB, m *orm.Table) error {
 	}
 
 	for _, fld := range m.Fields {
-		var datatype string
-		_, err := db.QueryOne(pg.Scan(&datatype), `SELECT data_type FROM information_schema.columns WHERE table_schema='public' AND table_name=? AND column_name=?`, tableName, fld.SQLName)
+		if err := verifyModelField(ctx, db, schema, tableName, fld); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyModelField checks that the column for a model field exists and has the datatype expected by the model.
+func verifyModelField(ctx context.Context, db *pg.DB, schema string, tableName string, fld *orm.Field) error {
+	var datatype string
+	_, err := db.QueryOneContext(ctx, pg.Scan(&datatype), `SELECT data_type FROM information_schema.columns WHERE table_schema=? AND table_name=? AND column_name=?`, schema, tableName, fld.SQLName)
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return xerrors.Errorf("required column %s.%s not found", tableName, fld.SQLName)
+		}
+		return xerrors.Errorf("querying field: %v %T", err, err)
+	}
+	if datatype == "USER-DEFINED" {
+		_, err := db.QueryOneContext(ctx, pg.Scan(&datatype), `SELECT udt_name FROM information_schema.columns WHERE table_schema=? AND table_name=? AND column_name=?`, schema, tableName, fld.SQLName)
 		if err != nil {
 			if errors.Is(err, pg.ErrNoRows) {
 				return xerrors.Errorf("required column %s.%s not found", tableName, fld.SQLName)
 			}
 			return xerrors.Errorf("querying field: %v %T", err, err)
 		}
-		if datatype == "USER-DEFINED" {
-			_, err := db.QueryOne(pg.Scan(&datatype), `SELECT udt_name FROM information_schema.columns WHERE table_schema='public' AND table_name=? AND column_name=?`, tableName, fld.SQLName)
-			if err != nil {
-				if errors.Is(err, pg.ErrNoRows) {
-					return xerrors.Errorf("required column %s.%s not found", tableName, fld.SQLName)
-				}
-				return xerrors.Errorf("querying field: %v %T", err, err)
-			}
-		}
-
-		// Some common aliases
-		if datatype == "timestamp with time zone" {
-			datatype = "timestamptz"
-		} else if datatype == "timestamp without time zone" {
-			datatype = "timestamp"
-		}
+	}
 
-		if datatype != fld.SQLType {
-			return xerrors.Errorf("column %s.%s had datatype %s, expected %s", tableName, fld.SQLName, datatype, fld.SQLType)
-		}
+	// Some common aliases
+	if datatype == "timestamp with time zone" {
+		datatype = "timestamptz"
+	} else if datatype == "timestamp without time zone" {
+		datatype = "timestamp"
+	}
 
+	if datatype != fld.SQLType {
+		return xerrors.Errorf("column %s.%s had datatype %s, expected %s", tableName, fld.SQLName, datatype, fld.SQLType)
 	}
 
 	return nil
@@ -277,64 +344,22 @@ func stripQuotes(s types.Safe) string {
 	return strings.Trim(string(s), `"`)
 }
 
+// LeaseStateChanges leases a set of tipsets whose actor state changes will be extracted. minHeight and maxHeight define an inclusive range of heights to process.
 func (d *Database) LeaseStateChanges(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
-	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
-	defer stop()
-
-	var blocks visor.ProcessingTipSetList
+	return d.leaseTipSets(ctx, StateChangeQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
 
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.QueryContext(ctx, &blocks, `
-WITH leased AS (
-    UPDATE visor_processing_tipsets
-    SET statechange_claimed_until = ?
-    FROM (
-	    SELECT *
-	    FROM visor_processing_tipsets
-	    WHERE statechange_completed_at IS null AND
-	          (statechange_claimed_until IS null OR statechange_claimed_until < ?) AND
-	          height >= ? AND height <= ?
-	    ORDER BY height DESC
-	    LIMIT ?
-	    FOR UPDATE SKIP LOCKED
-	) candidates
-	WHERE visor_processing_tipsets.tip_set = candidates.tip_set AND visor_processing_tipsets.height = candidates.height
-	AND visor_processing_tipsets.height >= ? AND visor_processing_tipsets.height <= ?
-    RETURNING visor_processing_tipsets.tip_set, visor_processing_tipsets.height
-)
-SELECT tip_set,height FROM leased;
-    `, claimUntil, d.Clock.Now(), minHeight, maxHeight, batchSize, minHeight, maxHeight)
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
+// leaseTipSets leases a set of tipsets from a queue of tipsets to process.
+func (d *Database) leaseTipSets(ctx context.Context, q *WorkQueue, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	var tipsets visor.ProcessingTipSetList
+	if err := d.LeaseWork(ctx, q, claimUntil, batchSize, WorkFilter{MinHeight: minHeight, MaxHeight: maxHeight}, &tipsets); err != nil {
 		return nil, err
 	}
-	return blocks, nil
+	return tipsets, nil
 }
 
 func (d *Database) MarkStateChangeComplete(ctx context.Context, tsk string, height int64, completedAt time.Time, errorsDetected string) error {
-	stop := metrics.Timer(ctx, metrics.CompletionDuration)
-	defer stop()
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.ExecContext(ctx, `
-    UPDATE visor_processing_tipsets
-    SET statechange_claimed_until = null,
-        statechange_completed_at = ?,
-        statechange_errors_detected = ?
-    WHERE tip_set = ? AND height = ?
-`, completedAt, useNullIfEmpty(errorsDetected), tsk, height)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return d.CompleteWork(ctx, StateChangeQueue, completedAt, errorsDetected, tsk, height)
 }
 
 // GetActorByHead returns an actor without a lease by its CID
@@ -359,41 +384,18 @@ func (d *Database) GetActorByHead(ctx context.Context, head string) (*visor.Proc
 
 // LeaseActors leases a set of actors to process. minHeight and maxHeight define an inclusive range of heights to process.
 func (d *Database) LeaseActors(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64, codes []string) (visor.ProcessingActorList, error) {
-	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
-	defer stop()
-	var actors visor.ProcessingActorList
-
 	// Ensure we never return genesis, which is handled separately
 	if minHeight < 1 {
 		minHeight = 1
 	}
 
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.QueryContext(ctx, &actors, `
-WITH leased AS (
-    UPDATE visor_processing_actors a
-    SET claimed_until = ?
-    FROM (
-	    SELECT *
-	    FROM visor_processing_actors
-	    WHERE completed_at IS null AND
-	          (claimed_until IS null OR claimed_until < ?) AND
-	          height >= ? AND height <= ? AND
-	          code IN (?)
-	    ORDER BY height DESC
-	    LIMIT ?
-	    FOR UPDATE SKIP LOCKED
-	) candidates
-	WHERE a.head = candidates.head AND a.code = candidates.code
- 	AND a.height >= ? AND a.height <= ?
-   RETURNING a.head, a.code, a.nonce, a.balance, a.address, a.parent_state_root, a.tip_set, a.parent_tip_set, a.height)
-SELECT head, code, nonce, balance, address, parent_state_root, tip_set, parent_tip_set, height from leased;
-    `, claimUntil, d.Clock.Now(), minHeight, maxHeight, pg.In(codes), batchSize, minHeight, maxHeight)
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
+	var actors visor.ProcessingActorList
+	if err := d.LeaseWork(ctx, ActorQueue, claimUntil, batchSize, WorkFilter{
+		MinHeight: minHeight,
+		MaxHeight: maxHeight,
+		Where:     "code IN (?)",
+		Args:      []interface{}{pg.In(codes)},
+	}, &actors); err != nil {
 		return nil, err
 	}
 	return actors, nil
@@ -401,131 +403,38 @@ SELECT head, code, nonce, balance, address, parent_state_root, tip_set, parent_t
 
 // FindActors finds a set of actors to process but does not take a lease out. minHeight and maxHeight define an inclusive range of heights to process.
 func (d *Database) FindActors(ctx context.Context, batchSize int, minHeight, maxHeight int64, codes []string) (visor.ProcessingActorList, error) {
-	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
-	defer stop()
-	var actors visor.ProcessingActorList
-
 	// Ensure we never return genesis, which is handled separately
 	if minHeight < 1 {
 		minHeight = 1
 	}
 
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		var err error
-		switch len(codes) {
-		case 0:
-			_, err = tx.QueryContext(ctx, &actors, `
-				    SELECT head, code, nonce, balance, address, parent_state_root, tip_set, parent_tip_set, height
-				    FROM visor_processing_actors
-				    WHERE completed_at IS null AND height >= ? AND height <= ?
-				    ORDER BY height DESC
-				    LIMIT ?`, minHeight, maxHeight, batchSize)
-		case 1:
-			_, err = tx.QueryContext(ctx, &actors, `
-				    SELECT head, code, nonce, balance, address, parent_state_root, tip_set, parent_tip_set, height
-				    FROM visor_processing_actors
-				    WHERE completed_at IS null AND height >= ? AND height <= ? AND code = ?
-				    ORDER BY height DESC
-				    LIMIT ?`, minHeight, maxHeight, codes[0], batchSize)
-		default:
-			_, err = tx.QueryContext(ctx, &actors, `
-				    SELECT head, code, nonce, balance, address, parent_state_root, tip_set, parent_tip_set, height
-				    FROM visor_processing_actors
-				    WHERE completed_at IS null AND height >= ? AND height <= ? AND code IN (?)
-				    ORDER BY height DESC
-				    LIMIT ?`, minHeight, maxHeight, pg.In(codes), batchSize)
-		}
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
+	f := WorkFilter{
+		MinHeight: minHeight,
+		MaxHeight: maxHeight,
+	}
+	if len(codes) > 0 {
+		f.Where = "code IN (?)"
+		f.Args = []interface{}{pg.In(codes)}
+	}
+
+	var actors visor.ProcessingActorList
+	if err := d.FindWork(ctx, ActorQueue, batchSize, f, &actors); err != nil {
 		return nil, err
 	}
 	return actors, nil
 }
 
 func (d *Database) MarkActorComplete(ctx context.Context, height int64, head string, code string, completedAt time.Time, errorsDetected string) error {
-	stop := metrics.Timer(ctx, metrics.CompletionDuration)
-	defer stop()
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.ExecContext(ctx, `
-    UPDATE visor_processing_actors
-    SET claimed_until = null,
-        completed_at = ?,
-        errors_detected = ?
-    WHERE height = ? AND head = ? AND code = ?
-`, completedAt, useNullIfEmpty(errorsDetected), height, head, code)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return d.CompleteWork(ctx, ActorQueue, completedAt, errorsDetected, height, head, code)
 }
 
 // LeaseTipSetMessages leases a set of tipsets containing messages to process. minHeight and maxHeight define an inclusive range of heights to process.
 func (d *Database) LeaseTipSetMessages(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
-	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
-	defer stop()
-	var messages visor.ProcessingTipSetList
-
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.QueryContext(ctx, &messages, `
-WITH leased AS (
-    UPDATE visor_processing_tipsets
-    SET message_claimed_until = ?
-    FROM (
-	    SELECT *
-	    FROM visor_processing_tipsets
-	    WHERE message_completed_at IS null AND
-	          (message_claimed_until IS null OR message_claimed_until < ?) AND
-	          height >= ? AND height <= ?
-	    ORDER BY height DESC
-	    LIMIT ?
-	    FOR UPDATE SKIP LOCKED
-	) candidates
-	WHERE visor_processing_tipsets.tip_set = candidates.tip_set AND visor_processing_tipsets.height = candidates.height
-	AND visor_processing_tipsets.height >= ? AND visor_processing_tipsets.height <= ?
-    RETURNING visor_processing_tipsets.tip_set, visor_processing_tipsets.height
-)
-SELECT tip_set,height FROM leased;
-    `, claimUntil, d.Clock.Now(), minHeight, maxHeight, batchSize, minHeight, maxHeight)
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
-		return nil, err
-	}
-	return messages, nil
+	return d.leaseTipSets(ctx, MessageQueue, claimUntil, batchSize, minHeight, maxHeight)
 }
 
 func (d *Database) MarkTipSetMessagesComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
-	stop := metrics.Timer(ctx, metrics.CompletionDuration)
-	defer stop()
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.ExecContext(ctx, `
-    UPDATE visor_processing_tipsets
-    SET message_claimed_until = null,
-        message_completed_at = ?,
-        message_errors_detected = ?
-    WHERE tip_set = ? AND height = ?
-`, completedAt, useNullIfEmpty(errorsDetected), tipset, height)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return d.CompleteWork(ctx, MessageQueue, completedAt, errorsDetected, tipset, height)
 }
 
 func useNullIfEmpty(s string) *string {
@@ -537,6 +446,8 @@ func useNullIfEmpty(s string) *string {
 }
 
 // LeaseGasOutputsMessages leases a set of messages that have receipts for gas output processing. minHeight and maxHeight define an inclusive range of heights to process.
+// Unlike other work queues the leased messages are joined with the data needed to process them, so GasOutputsQueue is only
+// used to renew and complete them.
 func (d *Database) LeaseGasOutputsMessages(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) ([]*derived.ProcessingGasOutputs, error) {
 	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
 	defer stop()
@@ -549,7 +460,7 @@ WITH leased AS (
     SET gas_outputs_claimed_until = ?
     FROM (
 		SELECT pm.cid, m.from, m.to, m.size_bytes, m.nonce, m.value,
-			   m.gas_fee_cap, m.gas_premium, m.gas_limit, m.method,
+			   m.gas_fee_cap, m.gas_premium, m.gas_limit, m.method, m.actor_code, m.method_name,
 			   r.state_root, r.exit_code,r.gas_used, bh.parent_base_fee
 		FROM visor_processing_messages pm
 		JOIN receipts r ON pm.cid = r.message -- don't join receipts on height since it's the height of the receipt
@@ -589,7 +500,7 @@ func (d *Database) FindGasOutputsMessages(ctx context.Context, batchSize int, mi
 	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
 		_, err := tx.QueryContext(ctx, &list, `
 		SELECT pm.height, pm.cid, m.from, m.to, m.size_bytes, m.nonce, m.value,
-			   m.gas_fee_cap, m.gas_premium, m.gas_limit, m.method,
+			   m.gas_fee_cap, m.gas_premium, m.gas_limit, m.method, m.actor_code, m.method_name,
 			   r.state_root, r.exit_code,r.gas_used, bh.parent_base_fee
 		FROM visor_processing_messages pm
 		JOIN receipts r ON pm.cid = r.message -- don't join receipts on height since it's the height of the receipt
@@ -612,86 +523,102 @@ func (d *Database) FindGasOutputsMessages(ctx context.Context, batchSize int, mi
 }
 
 func (d *Database) MarkGasOutputsMessagesComplete(ctx context.Context, height int64, cid string, completedAt time.Time, errorsDetected string) error {
-	stop := metrics.Timer(ctx, metrics.CompletionDuration)
-	defer stop()
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.ExecContext(ctx, `
-    UPDATE visor_processing_messages
-    SET gas_outputs_claimed_until = null,
-        gas_outputs_completed_at = ?,
-        gas_outputs_errors_detected = ?
-    WHERE height = ? AND cid = ?
-`, completedAt, useNullIfEmpty(errorsDetected), height, cid)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return d.CompleteWork(ctx, GasOutputsQueue, completedAt, errorsDetected, height, cid)
 }
 
 // LeaseTipSetEconomics leases a set of tipsets containing chain economics to process. minHeight and maxHeight define an inclusive range of heights to process.
-// TODO: refactor all the tipset leasing methods into a more general function
 func (d *Database) LeaseTipSetEconomics(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
-	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
-	defer stop()
-	var tipsets visor.ProcessingTipSetList
-
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.QueryContext(ctx, &tipsets, `
-WITH leased AS (
-    UPDATE visor_processing_tipsets
-    SET economics_claimed_until = ?
-    FROM (
-	    SELECT *
-	    FROM visor_processing_tipsets
-	    WHERE economics_completed_at IS null AND
-	          (economics_claimed_until IS null OR economics_claimed_until < ?) AND
-	          height >= ? AND height <= ?
-	    ORDER BY height DESC
-	    LIMIT ?
-	    FOR UPDATE SKIP LOCKED
-	) candidates
-	WHERE visor_processing_tipsets.tip_set = candidates.tip_set AND visor_processing_tipsets.height = candidates.height
-	AND visor_processing_tipsets.height >= ? AND visor_processing_tipsets.height <= ?
-    RETURNING visor_processing_tipsets.tip_set, visor_processing_tipsets.height
-)
-SELECT tip_set,height FROM leased;
-    `, claimUntil, d.Clock.Now(), minHeight, maxHeight, batchSize, minHeight, maxHeight)
-		if err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
-		return nil, err
-	}
-	return tipsets, nil
+	return d.leaseTipSets(ctx, EconomicsQueue, claimUntil, batchSize, minHeight, maxHeight)
 }
 
 func (d *Database) MarkTipSetEconomicsComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
-	stop := metrics.Timer(ctx, metrics.CompletionDuration)
-	defer stop()
+	return d.CompleteWork(ctx, EconomicsQueue, completedAt, errorsDetected, tipset, height)
+}
 
-	if err := d.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		_, err := tx.ExecContext(ctx, `
-    UPDATE visor_processing_tipsets
-    SET economics_claimed_until = null,
-        economics_completed_at = ?,
-        economics_errors_detected = ?
-    WHERE tip_set = ? AND height = ?
-`, completedAt, useNullIfEmpty(errorsDetected), tipset, height)
-		if err != nil {
-			return err
-		}
+// LeaseTipSetInternalMessages leases a set of tipsets whose messages will be replayed to extract internal messages. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetInternalMessages(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	return d.leaseTipSets(ctx, InternalMessageQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
 
-		return nil
-	}); err != nil {
-		return err
+func (d *Database) MarkTipSetInternalMessagesComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, InternalMessageQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetParsedMessages leases a set of tipsets whose messages will be parsed. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetParsedMessages(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	return d.leaseTipSets(ctx, ParsedMessageQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
+
+func (d *Database) MarkTipSetParsedMessagesComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, ParsedMessageQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetGasAggregates leases a set of tipsets whose messages will have their gas usage aggregated. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetGasAggregates(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	return d.leaseTipSets(ctx, GasAggregateQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
+
+func (d *Database) MarkTipSetGasAggregatesComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, GasAggregateQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetBlockRewards leases a set of tipsets whose blocks will have their miner rewards attributed. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetBlockRewards(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	return d.leaseTipSets(ctx, BlockRewardQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
+
+func (d *Database) MarkTipSetBlockRewardsComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, BlockRewardQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetBalanceChanges leases a set of tipsets whose changes to actor balances will be recorded. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetBalanceChanges(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64) (visor.ProcessingTipSetList, error) {
+	return d.leaseTipSets(ctx, BalanceChangeQueue, claimUntil, batchSize, minHeight, maxHeight)
+}
+
+func (d *Database) MarkTipSetBalanceChangesComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, BalanceChangeQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetMinerSectors leases a set of tipsets whose sector events will be summarized. Only tipsets whose state
+// changes have been extracted and whose miner actors have all been processed are leased. codes are the miner actor codes.
+// minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetMinerSectors(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64, codes []string) (visor.ProcessingTipSetList, error) {
+	return d.leaseActorsCompletedTipSets(ctx, MinerSectorQueue, claimUntil, batchSize, minHeight, maxHeight, codes)
+}
+
+func (d *Database) MarkTipSetMinerSectorsComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, MinerSectorQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// LeaseTipSetDeals leases a set of tipsets whose deal changes will be applied to the deal lifecycle. Only tipsets whose
+// state changes have been extracted and whose market and miner actors have all been processed are leased. codes are
+// the market and miner actor codes. minHeight and maxHeight define an inclusive range of heights to process.
+func (d *Database) LeaseTipSetDeals(ctx context.Context, claimUntil time.Time, batchSize int, minHeight, maxHeight int64, codes []string) (visor.ProcessingTipSetList, error) {
+	return d.leaseActorsCompletedTipSets(ctx, DealQueue, claimUntil, batchSize, minHeight, maxHeight, codes)
+}
+
+func (d *Database) MarkTipSetDealsComplete(ctx context.Context, tipset string, height int64, completedAt time.Time, errorsDetected string) error {
+	return d.CompleteWork(ctx, DealQueue, completedAt, errorsDetected, tipset, height)
+}
+
+// leaseActorsCompletedTipSets leases a set of tipsets from a queue of tipsets, limited to those whose state changes have
+// been extracted and that have no incomplete actors with one of the given codes. Actors are looked up by the height of
+// each candidate tipset so only the actors at that height are read.
+func (d *Database) leaseActorsCompletedTipSets(ctx context.Context, q *WorkQueue, claimUntil time.Time, batchSize int, minHeight, maxHeight int64, codes []string) (visor.ProcessingTipSetList, error) {
+	f := WorkFilter{
+		MinHeight: minHeight,
+		MaxHeight: maxHeight,
+		Where: `statechange_completed_at IS NOT null AND NOT EXISTS (
+	SELECT 1 FROM visor_processing_actors a
+	WHERE a.height = visor_processing_tipsets.height AND a.code IN (?) AND a.completed_at IS null
+)`,
+		Args: []interface{}{pg.In(codes)},
 	}
 
-	return nil
+	var tipsets visor.ProcessingTipSetList
+	if err := d.LeaseWork(ctx, q, claimUntil, batchSize, f, &tipsets); err != nil {
+		return nil, err
+	}
+	return tipsets, nil
 }
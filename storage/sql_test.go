@@ -39,7 +39,7 @@ func TestSchemaIsCurrent(t *testing.T) {
 	for _, model := range models {
 		t.Run(fmt.Sprintf("%T", model), func(t *testing.T) {
 			q := db.Model(model)
-			err := verifyModel(ctx, db, q.TableModel().Table())
+			err := verifyModel(ctx, db, DefaultSchema, q.TableModel().Table())
 			if err != nil {
 				t.Errorf("%v", err)
 				ctq := orm.NewCreateTableQuery(q, &orm.CreateTableOptions{IfNotExists: true})
@@ -1160,4 +1160,100 @@ func TestMarkTipSetComplete(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, 1, count)
 	})
+
+	t.Run("internal messages with error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 1)
+		err = d.MarkTipSetInternalMessagesComplete(ctx, "cid1", 1, completedAt, "message")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE internal_messages_completed_at=?`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("internal messages without error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 2)
+		err = d.MarkTipSetInternalMessagesComplete(ctx, "cid1", 1, completedAt, "")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row with a null errors_detected column
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE internal_messages_completed_at=? AND internal_messages_errors_detected IS NULL`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("parsed messages with error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 1)
+		err = d.MarkTipSetParsedMessagesComplete(ctx, "cid1", 1, completedAt, "message")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE parsed_messages_completed_at=?`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("parsed messages without error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 2)
+		err = d.MarkTipSetParsedMessagesComplete(ctx, "cid1", 1, completedAt, "")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row with a null errors_detected column
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE parsed_messages_completed_at=? AND parsed_messages_errors_detected IS NULL`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("gas aggregates with error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 1)
+		err = d.MarkTipSetGasAggregatesComplete(ctx, "cid1", 1, completedAt, "message")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE gas_aggregates_completed_at=?`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("gas aggregates without error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 2)
+		err = d.MarkTipSetGasAggregatesComplete(ctx, "cid1", 1, completedAt, "")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row with a null errors_detected column
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE gas_aggregates_completed_at=? AND gas_aggregates_errors_detected IS NULL`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("block rewards with error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 1)
+		err = d.MarkTipSetBlockRewardsComplete(ctx, "cid1", 1, completedAt, "message")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE block_rewards_completed_at=?`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("block rewards without error", func(t *testing.T) {
+		completedAt := testutil.KnownTime.Add(time.Minute * 2)
+		err = d.MarkTipSetBlockRewardsComplete(ctx, "cid1", 1, completedAt, "")
+		require.NoError(t, err)
+
+		// Check the database contains the updated row with a null errors_detected column
+		var count int
+		_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE block_rewards_completed_at=? AND block_rewards_errors_detected IS NULL`, completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
 }
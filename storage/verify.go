@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/migrations/v8"
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+
+	vmigrations "github.com/filecoin-project/sentinel-visor/storage/migrations"
+)
+
+// Kinds of schema object checked by schema verification
+const (
+	SchemaTable            = "table"
+	SchemaColumn           = "column"
+	SchemaPrimaryKey       = "primary key"
+	SchemaIndex            = "index"
+	SchemaHypertable       = "hypertable"
+	SchemaMaterializedView = "materialized view"
+)
+
+// A SchemaProblem is a difference between the database schema and the schema expected by visor.
+type SchemaProblem struct {
+	Kind    string // kind of schema object
+	Object  string // name of the schema object
+	Problem string
+}
+
+// A SchemaReport lists every difference found between the database schema and the schema expected by visor.
+type SchemaReport struct {
+	Schema   string
+	Version  int
+	Problems []SchemaProblem
+}
+
+// OK reports whether the database schema matched the expected schema.
+func (r *SchemaReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *SchemaReport) add(kind, object, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, SchemaProblem{
+		Kind:    kind,
+		Object:  object,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+// Write writes the report as a table of problems.
+func (r *SchemaReport) Write(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "schema %s at version %d: ", r.Schema, r.Version)
+	if r.OK() {
+		fmt.Fprintf(&b, "no problems found\n")
+	} else {
+		fmt.Fprintf(&b, "%d problems found\n", len(r.Problems))
+		for _, p := range r.Problems {
+			fmt.Fprintf(&b, "%-18s %-50s %s\n", p.Kind, p.Object, p.Problem)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// SchemaObjects are the indexes, hypertables and materialized views created by the schema migrations.
+type SchemaObjects struct {
+	Indexes           map[string]string // index name to table name
+	Hypertables       map[string]bool
+	MaterializedViews map[string]bool
+}
+
+var (
+	createIndexStmt      = regexp.MustCompile(`(?i)CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?` + qualifiedName + `\s+ON\s+(?:ONLY\s+)?` + qualifiedName)
+	dropIndexStmt        = regexp.MustCompile(`(?i)DROP\s+INDEX\s+(?:IF\s+EXISTS\s+)?` + qualifiedName)
+	createHypertableStmt = regexp.MustCompile(`(?i)create_hypertable\(\s*'(?:[\w"]+\.)?"?(\w+)"?'`)
+	createMatViewStmt    = regexp.MustCompile(`(?i)CREATE\s+MATERIALIZED\s+VIEW\s+(?:IF\s+NOT\s+EXISTS\s+)?` + qualifiedName)
+	dropMatViewStmt      = regexp.MustCompile(`(?i)DROP\s+MATERIALIZED\s+VIEW\s+(?:IF\s+EXISTS\s+)?` + qualifiedName)
+	renameTableStmt      = regexp.MustCompile(`(?i)ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?` + qualifiedName + `\s+RENAME\s+TO\s+"?(\w+)"?`)
+	dropTableStmt        = regexp.MustCompile(`(?i)DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?((?:(?:[\w"]+\.)?"?\w+"?\s*,\s*)*(?:[\w"]+\.)?"?\w+"?)`)
+	tableNameInList      = regexp.MustCompile(`(?:[\w"]+\.)?"?(\w+)"?`)
+	sqlComment           = regexp.MustCompile(`--[^\n]*`)
+)
+
+// qualifiedName matches an optionally schema qualified and quoted name, capturing the unqualified name
+const qualifiedName = `(?:[\w"]+\.)?"?(\w+)"?`
+
+// ExpectedSchemaObjects returns the indexes, hypertables and materialized views that exist once the migrations up to
+// and including version have been run, determined from the statements the migrations execute.
+func ExpectedSchemaObjects(version int) (*SchemaObjects, error) {
+	ms := append([]*migrations.Migration(nil), migrations.DefaultCollection.Migrations()...)
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+
+	objs := &SchemaObjects{
+		Indexes:           map[string]string{},
+		Hypertables:       map[string]bool{},
+		MaterializedViews: map[string]bool{},
+	}
+
+	for _, m := range ms {
+		if int(m.Version) > version {
+			break
+		}
+		stmts, err := vmigrations.Record(m.Up, DefaultSchema)
+		if err != nil {
+			return nil, xerrors.Errorf("record migration %d: %w", m.Version, err)
+		}
+		for _, stmt := range stmts {
+			objs.apply(sqlComment.ReplaceAllString(stmt, ""))
+		}
+	}
+
+	return objs, nil
+}
+
+// apply updates the objects with the effects of sql, which may contain several statements.
+func (o *SchemaObjects) apply(sql string) {
+	for _, stmt := range strings.Split(sql, ";") {
+		for _, m := range createIndexStmt.FindAllStringSubmatch(stmt, -1) {
+			o.Indexes[m[1]] = m[2]
+		}
+		for _, m := range dropIndexStmt.FindAllStringSubmatch(stmt, -1) {
+			delete(o.Indexes, m[1])
+		}
+		for _, m := range createHypertableStmt.FindAllStringSubmatch(stmt, -1) {
+			o.Hypertables[m[1]] = true
+		}
+		for _, m := range createMatViewStmt.FindAllStringSubmatch(stmt, -1) {
+			o.MaterializedViews[m[1]] = true
+		}
+		for _, m := range dropMatViewStmt.FindAllStringSubmatch(stmt, -1) {
+			delete(o.MaterializedViews, m[1])
+			o.dropTable(m[1])
+		}
+		for _, m := range renameTableStmt.FindAllStringSubmatch(stmt, -1) {
+			o.renameTable(m[1], m[2])
+		}
+		for _, m := range dropTableStmt.FindAllStringSubmatch(stmt, -1) {
+			for _, t := range tableNameInList.FindAllStringSubmatch(m[1], -1) {
+				o.dropTable(t[1])
+			}
+		}
+	}
+}
+
+func (o *SchemaObjects) renameTable(from, to string) {
+	for idx, table := range o.Indexes {
+		if table == from {
+			o.Indexes[idx] = to
+		}
+	}
+	if o.Hypertables[from] {
+		delete(o.Hypertables, from)
+		o.Hypertables[to] = true
+	}
+}
+
+func (o *SchemaObjects) dropTable(name string) {
+	for idx, table := range o.Indexes {
+		if table == name {
+			delete(o.Indexes, idx)
+		}
+	}
+	delete(o.Hypertables, name)
+}
+
+// VerifySchema compares the tables, columns, primary keys, indexes, hypertables and materialized views present in the
+// database with those expected by visor and reports every difference found.
+func (d *Database) VerifySchema(ctx context.Context) (*SchemaReport, error) {
+	db := d.DB
+	if db == nil {
+		// Temporarily connect
+		var err error
+		db, err = connect(ctx, d.opt, d.Schema())
+		if err != nil {
+			return nil, xerrors.Errorf("connect: %w", err)
+		}
+		defer db.Close()
+	}
+
+	dbVersion, _, err := getSchemaVersions(ctx, db, d.Schema())
+	if err != nil {
+		return nil, xerrors.Errorf("get schema versions: %w", err)
+	}
+
+	report := &SchemaReport{
+		Schema:  d.Schema(),
+		Version: dbVersion,
+	}
+
+	if err := verifyModels(ctx, db, d.Schema(), report); err != nil {
+		return nil, xerrors.Errorf("verify models: %w", err)
+	}
+
+	objs, err := ExpectedSchemaObjects(dbVersion)
+	if err != nil {
+		return nil, xerrors.Errorf("expected schema objects: %w", err)
+	}
+
+	if err := verifyIndexes(ctx, db, d.Schema(), objs, report); err != nil {
+		return nil, xerrors.Errorf("verify indexes: %w", err)
+	}
+	if err := verifyHypertables(ctx, db, d.Schema(), objs, report); err != nil {
+		return nil, xerrors.Errorf("verify hypertables: %w", err)
+	}
+	if err := verifyMaterializedViews(ctx, db, d.Schema(), objs, report); err != nil {
+		return nil, xerrors.Errorf("verify materialized views: %w", err)
+	}
+
+	return report, nil
+}
+
+// verifyModels reports missing tables, and missing or mismatched columns and primary keys of the models.
+func verifyModels(ctx context.Context, db *pg.DB, schema string, report *SchemaReport) error {
+	for _, model := range models {
+		m := db.Model(model).TableModel().Table()
+		tableName := stripQuotes(m.SQLNameForSelects)
+
+		var exists bool
+		if _, err := db.QueryOneContext(ctx, pg.Scan(&exists), `SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema=? AND table_name=?)`, schema, tableName); err != nil {
+			return xerrors.Errorf("querying table: %w", err)
+		}
+		if !exists {
+			report.add(SchemaTable, tableName, "required table not found")
+			continue
+		}
+
+		for _, fld := range m.Fields {
+			if err := verifyModelField(ctx, db, schema, tableName, fld); err != nil {
+				report.add(SchemaColumn, tableName+"."+fld.SQLName, "%v", err)
+			}
+		}
+
+		if len(m.PKs) == 0 {
+			continue
+		}
+
+		var pkColumns []string
+		if _, err := db.QueryOneContext(ctx, pg.Scan(pg.Array(&pkColumns)), `
+SELECT COALESCE(array_agg(a.attname::text ORDER BY a.attname), '{}')
+FROM pg_index i
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indrelid = to_regclass(quote_ident(?) || '.' || quote_ident(?)) AND i.indisprimary
+`, schema, tableName); err != nil {
+			return xerrors.Errorf("querying primary key: %w", err)
+		}
+
+		expected := make([]string, 0, len(m.PKs))
+		for _, pk := range m.PKs {
+			expected = append(expected, string(pk.SQLName))
+		}
+		sort.Strings(expected)
+
+		if strings.Join(pkColumns, ",") != strings.Join(expected, ",") {
+			if len(pkColumns) == 0 {
+				report.add(SchemaPrimaryKey, tableName, "primary key not found, expected (%s)", strings.Join(expected, ", "))
+			} else {
+				report.add(SchemaPrimaryKey, tableName, "primary key was (%s), expected (%s)", strings.Join(pkColumns, ", "), strings.Join(expected, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func verifyIndexes(ctx context.Context, db *pg.DB, schema string, objs *SchemaObjects, report *SchemaReport) error {
+	var indexes []struct {
+		IndexName string
+		TableName string
+	}
+	if _, err := db.QueryContext(ctx, &indexes, `SELECT indexname AS index_name, tablename AS table_name FROM pg_indexes WHERE schemaname = ?`, schema); err != nil {
+		return err
+	}
+
+	found := make(map[string]string, len(indexes))
+	for _, idx := range indexes {
+		found[idx.IndexName] = idx.TableName
+	}
+
+	names := make([]string, 0, len(objs.Indexes))
+	for name := range objs.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table := objs.Indexes[name]
+		actual, ok := found[name]
+		switch {
+		case !ok:
+			report.add(SchemaIndex, name, "required index on %s not found", table)
+		case actual != table:
+			report.add(SchemaIndex, name, "index was on %s, expected %s", actual, table)
+		}
+	}
+	return nil
+}
+
+func verifyHypertables(ctx context.Context, db *pg.DB, schema string, objs *SchemaObjects, report *SchemaReport) error {
+	if len(objs.Hypertables) == 0 {
+		return nil
+	}
+
+	var installed bool
+	if _, err := db.QueryOneContext(ctx, pg.Scan(&installed), `SELECT to_regclass('_timescaledb_catalog.hypertable') IS NOT NULL`); err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+	if installed {
+		var names []string
+		if _, err := db.QueryOneContext(ctx, pg.Scan(pg.Array(&names)), `SELECT COALESCE(array_agg(table_name::text), '{}') FROM _timescaledb_catalog.hypertable WHERE schema_name = ?`, schema); err != nil {
+			return err
+		}
+		for _, name := range names {
+			found[name] = true
+		}
+	}
+
+	for _, name := range sortedNames(objs.Hypertables) {
+		if !installed {
+			report.add(SchemaHypertable, name, "timescaledb extension is not installed")
+			continue
+		}
+		if !found[name] {
+			report.add(SchemaHypertable, name, "table is not a hypertable")
+		}
+	}
+	return nil
+}
+
+func verifyMaterializedViews(ctx context.Context, db *pg.DB, schema string, objs *SchemaObjects, report *SchemaReport) error {
+	var names []string
+	if _, err := db.QueryOneContext(ctx, pg.Scan(pg.Array(&names)), `SELECT COALESCE(array_agg(matviewname::text), '{}') FROM pg_matviews WHERE schemaname = ?`, schema); err != nil {
+		return err
+	}
+
+	found := make(map[string]bool, len(names))
+	for _, name := range names {
+		found[name] = true
+	}
+
+	for _, name := range sortedNames(objs.MaterializedViews) {
+		if !found[name] {
+			report.add(SchemaMaterializedView, name, "required materialized view not found")
+		}
+	}
+	return nil
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaObjectsApply(t *testing.T) {
+	objs := &SchemaObjects{
+		Indexes:           map[string]string{},
+		Hypertables:       map[string]bool{},
+		MaterializedViews: map[string]bool{},
+	}
+
+	objs.apply(`
+CREATE TABLE public.a (height bigint);
+CREATE INDEX IF NOT EXISTS "a_height_idx" ON public.a USING BTREE (height);
+CREATE UNIQUE INDEX b_uindex ON public.b USING btree (x);
+SELECT create_hypertable('a', 'height', chunk_time_interval => 2880, if_not_exists => TRUE);
+CREATE MATERIALIZED VIEW IF NOT EXISTS a_view AS SELECT * FROM a;
+CREATE INDEX a_view_idx ON a_view (height);
+`)
+	assert.Equal(t, map[string]string{"a_height_idx": "a", "b_uindex": "b", "a_view_idx": "a_view"}, objs.Indexes)
+	assert.Equal(t, map[string]bool{"a": true}, objs.Hypertables)
+	assert.Equal(t, map[string]bool{"a_view": true}, objs.MaterializedViews)
+
+	objs.apply(`
+ALTER TABLE public.a RENAME TO c;
+DROP TABLE IF EXISTS public.b CASCADE;
+DROP MATERIALIZED VIEW IF EXISTS public.a_view;
+`)
+	assert.Equal(t, map[string]string{"a_height_idx": "c"}, objs.Indexes)
+	assert.Equal(t, map[string]bool{"c": true}, objs.Hypertables)
+	assert.Len(t, objs.MaterializedViews, 0)
+
+	objs.apply(`DROP INDEX IF EXISTS a_height_idx;`)
+	assert.Len(t, objs.Indexes, 0)
+}
+
+func TestExpectedSchemaObjects(t *testing.T) {
+	objs, err := ExpectedSchemaObjects(getLatestSchemaVersion())
+	require.NoError(t, err)
+
+	// Indexes that leasing queries rely on
+	assert.Equal(t, "visor_processing_tipsets", objs.Indexes["visor_processing_tipsets_message_idx"])
+	assert.Equal(t, "visor_processing_tipsets", objs.Indexes["visor_processing_tipsets_statechange_idx"])
+	assert.True(t, objs.Hypertables["miner_partition_posts"])
+}
+
+func TestSchemaReportWrite(t *testing.T) {
+	r := &SchemaReport{Schema: "public", Version: 26}
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	assert.Equal(t, "schema public at version 26: no problems found\n", buf.String())
+
+	r.add(SchemaIndex, "a_idx", "required index on %s not found", "a")
+	buf.Reset()
+	require.NoError(t, r.Write(&buf))
+	assert.Contains(t, buf.String(), "1 problems found\n")
+	assert.Contains(t, buf.String(), "required index on a not found")
+	assert.False(t, r.OK())
+}
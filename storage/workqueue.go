@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+)
+
+// A WorkQueue is a queue of items of work held in a processing table. Processors take items from the queue by leasing
+// them, which claims them until a time in the future, and mark them complete once processed. Items are taken in
+// descending height order. A queue only needs to be described by its table and columns; the SQL for leasing, finding
+// and completing items is the same for every queue.
+type WorkQueue struct {
+	Name    string   // name of the queue, used in logs and errors
+	Table   string   // processing table holding the items
+	Task    string   // prefix of the task's claimed_until, completed_at and errors_detected columns, empty for none
+	Keys    []string // columns that uniquely identify an item, in the order values are passed to CompleteWork
+	Columns []string // columns read for each leased or found item, defaults to Keys
+}
+
+func tipSetWorkQueue(task string) *WorkQueue {
+	return &WorkQueue{
+		Name:  "tipsets_" + task,
+		Table: "visor_processing_tipsets",
+		Task:  task,
+		Keys:  []string{"tip_set", "height"},
+	}
+}
+
+var (
+	StateChangeQueue     = tipSetWorkQueue("statechange")
+	MessageQueue         = tipSetWorkQueue("message")
+	EconomicsQueue       = tipSetWorkQueue("economics")
+	InternalMessageQueue = tipSetWorkQueue("internal_messages")
+	ParsedMessageQueue   = tipSetWorkQueue("parsed_messages")
+	GasAggregateQueue    = tipSetWorkQueue("gas_aggregates")
+	BlockRewardQueue     = tipSetWorkQueue("block_rewards")
+	BalanceChangeQueue   = tipSetWorkQueue("balance_changes")
+	MinerSectorQueue     = tipSetWorkQueue("miner_sectors")
+	DealQueue            = tipSetWorkQueue("deals")
+	GasOutputsQueue      = &WorkQueue{
+		Name:  "messages_gas_outputs",
+		Table: "visor_processing_messages",
+		Task:  "gas_outputs",
+		Keys:  []string{"height", "cid"},
+	}
+	ActorQueue = &WorkQueue{
+		Name:    "actors",
+		Table:   "visor_processing_actors",
+		Keys:    []string{"height", "head", "code"},
+		Columns: []string{"head", "code", "nonce", "balance", "address", "parent_state_root", "tip_set", "parent_tip_set", "height"},
+	}
+)
+
+// WorkQueues lists every queue of work that is leased to processors
+var WorkQueues = []*WorkQueue{StateChangeQueue, MessageQueue, EconomicsQueue, InternalMessageQueue, ParsedMessageQueue, GasAggregateQueue, BlockRewardQueue, BalanceChangeQueue, MinerSectorQueue, DealQueue, GasOutputsQueue, ActorQueue}
+
+// column returns the name of one of the task's columns
+func (q *WorkQueue) column(name string) string {
+	if q.Task == "" {
+		return name
+	}
+	return q.Task + "_" + name
+}
+
+// ClaimedColumn is the column holding the time until which an item is claimed, null when unclaimed.
+func (q *WorkQueue) ClaimedColumn() string {
+	return q.column("claimed_until")
+}
+
+// CompletedColumn is the column holding the time an item was completed, null when incomplete.
+func (q *WorkQueue) CompletedColumn() string {
+	return q.column("completed_at")
+}
+
+// ErrorsColumn is the column holding the errors detected while processing an item, null when there were none.
+func (q *WorkQueue) ErrorsColumn() string {
+	return q.column("errors_detected")
+}
+
+func (q *WorkQueue) columns() []string {
+	if len(q.Columns) == 0 {
+		return q.Keys
+	}
+	return q.Columns
+}
+
+// A WorkFilter limits the items taken from a work queue. MinHeight and MaxHeight define an inclusive range of heights.
+// Where is an optional condition on the columns of the processing table with placeholders for Args.
+type WorkFilter struct {
+	MinHeight int64
+	MaxHeight int64
+	Where     string
+	Args      []interface{}
+}
+
+func (f WorkFilter) where() string {
+	if f.Where == "" {
+		return ""
+	}
+	return " AND (" + f.Where + ")"
+}
+
+// leaseSQL returns the query used to lease items from the queue. Its parameters are the new claim, the current time,
+// the height range, the filter arguments, the batch size and the height range again.
+func (q *WorkQueue) leaseSQL(f WorkFilter) string {
+	var join, returning []string
+	for _, k := range q.Keys {
+		join = append(join, fmt.Sprintf("q.%[1]s = candidates.%[1]s", k))
+	}
+	for _, c := range q.columns() {
+		returning = append(returning, "q."+c)
+	}
+
+	return fmt.Sprintf(`
+WITH leased AS (
+    UPDATE %[1]s q
+    SET %[2]s = ?
+    FROM (
+	    SELECT %[4]s
+	    FROM %[1]s
+	    WHERE %[3]s IS null AND
+	          (%[2]s IS null OR %[2]s < ?) AND
+	          height >= ? AND height <= ?%[5]s
+	    ORDER BY height DESC
+	    LIMIT ?
+	    FOR UPDATE SKIP LOCKED
+	) candidates
+	WHERE %[6]s
+	AND q.height >= ? AND q.height <= ?
+    RETURNING %[7]s
+)
+SELECT %[8]s FROM leased;
+`, q.Table, q.ClaimedColumn(), q.CompletedColumn(), strings.Join(q.Keys, ", "), f.where(),
+		strings.Join(join, " AND "), strings.Join(returning, ", "), strings.Join(q.columns(), ", "))
+}
+
+// findSQL returns the query used to find items in the queue without leasing them. Its parameters are the height
+// range, the filter arguments and the batch size.
+func (q *WorkQueue) findSQL(f WorkFilter) string {
+	return fmt.Sprintf(`
+SELECT %[1]s
+FROM %[2]s
+WHERE %[3]s IS null AND height >= ? AND height <= ?%[4]s
+ORDER BY height DESC
+LIMIT ?
+`, strings.Join(q.columns(), ", "), q.Table, q.CompletedColumn(), f.where())
+}
+
+// completeSQL returns the statement used to mark an item complete. Its parameters are the completion time, the
+// errors detected and the values of the item's keys.
+func (q *WorkQueue) completeSQL() string {
+	var where []string
+	for _, k := range q.Keys {
+		where = append(where, k+" = ?")
+	}
+
+	return fmt.Sprintf(`
+UPDATE %[1]s
+SET %[2]s = null,
+    %[3]s = ?,
+    %[4]s = ?
+WHERE %[5]s
+`, q.Table, q.ClaimedColumn(), q.CompletedColumn(), q.ErrorsColumn(), strings.Join(where, " AND "))
+}
+
+// LeaseWork leases a batch of incomplete items from the queue that are not claimed by another processor, claiming
+// them until claimUntil, and reads them into items, which must be a pointer to a slice of models.
+func (d *Database) LeaseWork(ctx context.Context, q *WorkQueue, claimUntil time.Time, batchSize int, f WorkFilter, items interface{}) error {
+	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
+	defer stop()
+
+	args := []interface{}{claimUntil, d.Clock.Now(), f.MinHeight, f.MaxHeight}
+	args = append(args, f.Args...)
+	args = append(args, batchSize, f.MinHeight, f.MaxHeight)
+
+	if _, err := d.DB.QueryContext(ctx, items, q.leaseSQL(f), args...); err != nil {
+		return xerrors.Errorf("lease %s: %w", q.Name, err)
+	}
+	return nil
+}
+
+// FindWork finds a batch of incomplete items in the queue without leasing them and reads them into items, which must
+// be a pointer to a slice of models.
+func (d *Database) FindWork(ctx context.Context, q *WorkQueue, batchSize int, f WorkFilter, items interface{}) error {
+	stop := metrics.Timer(ctx, metrics.BatchSelectionDuration)
+	defer stop()
+
+	args := []interface{}{f.MinHeight, f.MaxHeight}
+	args = append(args, f.Args...)
+	args = append(args, batchSize)
+
+	if _, err := d.DB.QueryContext(ctx, items, q.findSQL(f), args...); err != nil {
+		return xerrors.Errorf("find %s: %w", q.Name, err)
+	}
+	return nil
+}
+
+// CompleteWork marks the item identified by the values of the queue's keys complete and releases its claim.
+func (d *Database) CompleteWork(ctx context.Context, q *WorkQueue, completedAt time.Time, errorsDetected string, keys ...interface{}) error {
+	stop := metrics.Timer(ctx, metrics.CompletionDuration)
+	defer stop()
+
+	if len(keys) != len(q.Keys) {
+		return xerrors.Errorf("complete %s: got %d key values, expected %d", q.Name, len(keys), len(q.Keys))
+	}
+
+	args := []interface{}{completedAt, useNullIfEmpty(errorsDetected)}
+	args = append(args, keys...)
+
+	if _, err := d.DB.ExecContext(ctx, q.completeSQL(), args...); err != nil {
+		return xerrors.Errorf("complete %s: %w", q.Name, err)
+	}
+	return nil
+}
+
+// DeferWork replaces the claim on an incomplete item with one that expires at retryAt. It is used for items that
+// cannot be processed yet so they are taken again soon after retryAt rather than staying claimed until the lease
+// that took them expires. The new claim is not renewed by the lease.
+func (d *Database) DeferWork(ctx context.Context, q *WorkQueue, retryAt time.Time, keys ...interface{}) error {
+	if len(keys) != len(q.Keys) {
+		return xerrors.Errorf("defer %s: got %d key values, expected %d", q.Name, len(keys), len(q.Keys))
+	}
+
+	var where []string
+	for _, k := range q.Keys {
+		where = append(where, k+" = ?")
+	}
+
+	args := []interface{}{retryAt}
+	args = append(args, keys...)
+
+	if _, err := d.DB.ExecContext(ctx, fmt.Sprintf(`
+UPDATE %[1]s
+SET %[2]s = ?
+WHERE %[3]s AND %[4]s IS null
+`, q.Table, q.ClaimedColumn(), strings.Join(where, " AND "), q.CompletedColumn()), args...); err != nil {
+		return xerrors.Errorf("defer %s: %w", q.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestWorkQueueColumns(t *testing.T) {
+	assert.Equal(t, "statechange_claimed_until", StateChangeQueue.ClaimedColumn())
+	assert.Equal(t, "statechange_completed_at", StateChangeQueue.CompletedColumn())
+	assert.Equal(t, "statechange_errors_detected", StateChangeQueue.ErrorsColumn())
+	assert.Equal(t, []string{"tip_set", "height"}, StateChangeQueue.columns())
+
+	assert.Equal(t, "claimed_until", ActorQueue.ClaimedColumn())
+	assert.Equal(t, "completed_at", ActorQueue.CompletedColumn())
+	assert.Equal(t, "errors_detected", ActorQueue.ErrorsColumn())
+	assert.Contains(t, ActorQueue.columns(), "parent_state_root")
+}
+
+func TestWorkQueue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	truncateVisorProcessingTables(t, db)
+
+	indexedTipsets := visor.ProcessingTipSetList{
+		{
+			TipSet:  "cid0",
+			Height:  0,
+			AddedAt: testutil.KnownTime,
+		},
+		{
+			TipSet:  "cid1",
+			Height:  1,
+			AddedAt: testutil.KnownTime,
+		},
+		{
+			TipSet:  "cid2",
+			Height:  2,
+			AddedAt: testutil.KnownTime,
+		},
+		{
+			TipSet:  "cid3",
+			Height:  3,
+			AddedAt: testutil.KnownTime,
+		},
+	}
+
+	if err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return indexedTipsets.PersistWithTx(ctx, tx)
+	}); err != nil {
+		t.Fatalf("persisting indexed tipsets: %v", err)
+	}
+
+	d := &Database{
+		DB:    db,
+		Clock: testutil.NewMockClock(),
+	}
+
+	q := tipSetWorkQueue("economics")
+	f := WorkFilter{
+		MinHeight: 0,
+		MaxHeight: 500,
+		Where:     "tip_set <> ?",
+		Args:      []interface{}{"cid3"},
+	}
+
+	var found visor.ProcessingTipSetList
+	require.NoError(t, d.FindWork(ctx, q, 10, f, &found))
+	require.Len(t, found, 3, "number of found tipsets")
+
+	claimUntil := testutil.KnownTime.Add(time.Minute * 10)
+
+	var claimed visor.ProcessingTipSetList
+	require.NoError(t, d.LeaseWork(ctx, q, claimUntil, 2, f, &claimed))
+	require.Len(t, claimed, 2, "number of claimed tipsets")
+
+	// Items are leased in descending height order, excluding those that do not match the filter
+	assert.Equal(t, "cid2", claimed[0].TipSet, "first claimed tipset")
+	assert.Equal(t, "cid1", claimed[1].TipSet, "second claimed tipset")
+
+	require.NoError(t, d.CompleteWork(ctx, q, testutil.KnownTime, "", claimed[0].TipSet, claimed[0].Height))
+	require.NoError(t, d.CompleteWork(ctx, q, testutil.KnownTime, "boom", claimed[1].TipSet, claimed[1].Height))
+	assert.Error(t, d.CompleteWork(ctx, q, testutil.KnownTime, "", claimed[1].TipSet), "missing key value")
+
+	var count int
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE economics_completed_at IS NOT NULL AND economics_claimed_until IS NULL`)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "completed tipsets")
+
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE economics_errors_detected = 'boom'`)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "tipsets with errors")
+
+	// Only the remaining incomplete tipset that matches the filter is found
+	found = nil
+	require.NoError(t, d.FindWork(ctx, q, 10, f, &found))
+	require.Len(t, found, 1, "number of found tipsets")
+	assert.Equal(t, "cid0", found[0].TipSet)
+
+	// Deferring replaces the claim on incomplete items only
+	retryAt := testutil.KnownTime.Add(time.Second * 30)
+	require.NoError(t, d.DeferWork(ctx, q, retryAt, "cid0", int64(0)))
+	require.NoError(t, d.DeferWork(ctx, q, retryAt, "cid2", int64(2)))
+	assert.Error(t, d.DeferWork(ctx, q, retryAt, "cid0"), "missing key value")
+
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE economics_claimed_until = ?`, retryAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "deferred tipsets")
+}
+
+// leasedItem reads the height of any item leased from a work queue
+type leasedItem struct {
+	tableName struct{} `pg:",discard_unknown_columns"`
+	Height    int64
+}
+
+func TestLeaseWorkQueues(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	items := []struct {
+		height       int64
+		claimedUntil time.Time
+		completedAt  time.Time
+	}{
+		{height: 0},
+		{height: 1},
+		{height: 2},
+		{height: 3},
+		// Completed with stale claim
+		{height: 4, claimedUntil: testutil.KnownTime.Add(-time.Minute * 15), completedAt: testutil.KnownTime.Add(-time.Minute * 5)},
+		// Claimed by another process that has expired
+		{height: 5, claimedUntil: testutil.KnownTime.Add(-time.Minute * 5)},
+		// Claimed by another process
+		{height: 6, claimedUntil: testutil.KnownTime.Add(time.Minute * 15)},
+	}
+
+	const batchSize = 3
+	claimUntil := testutil.KnownTime.Add(time.Minute * 10)
+
+	d := &Database{
+		DB:    db,
+		Clock: testutil.NewMockClock(),
+	}
+
+	for _, q := range WorkQueues {
+		t.Run(q.Name, func(t *testing.T) {
+			truncateVisorProcessingTables(t, db)
+
+			for _, item := range items {
+				cols := append([]string{}, q.Keys...)
+				var args []interface{}
+				for _, k := range q.Keys {
+					if k == "height" {
+						args = append(args, item.height)
+					} else {
+						args = append(args, fmt.Sprintf("%s%d", k, item.height))
+					}
+				}
+				cols = append(cols, "added_at", q.ClaimedColumn(), q.CompletedColumn())
+				args = append(args, testutil.KnownTime, nullTime(item.claimedUntil), nullTime(item.completedAt))
+
+				_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?%s)`,
+					q.Table, strings.Join(cols, ", "), strings.Repeat(", ?", len(cols)-1)), args...)
+				require.NoError(t, err, "insert item at height %d", item.height)
+			}
+
+			var claimed []leasedItem
+			require.NoError(t, d.LeaseWork(ctx, q, claimUntil, batchSize, WorkFilter{MinHeight: 0, MaxHeight: 500}, &claimed))
+			require.Len(t, claimed, batchSize, "number of claimed items")
+
+			// Items are selected in descending height order, ignoring completed and claimed items
+			assert.Equal(t, int64(5), claimed[0].Height, "first claimed item")
+			assert.Equal(t, int64(3), claimed[1].Height, "second claimed item")
+			assert.Equal(t, int64(2), claimed[2].Height, "third claimed item")
+
+			// Check the database contains the leases
+			var count int
+			_, err = db.QueryOne(pg.Scan(&count), fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s = ?`, q.Table, q.ClaimedColumn()), claimUntil)
+			require.NoError(t, err)
+			assert.Equal(t, batchSize, count)
+		})
+	}
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -13,10 +13,22 @@ import (
 
 // was services/processor/tasks/common/actor.go
 
+// Ways of storing the raw state of actors
+const (
+	RawStateFull  = "full"  // store the state of every changed actor at every height
+	RawStateDedup = "dedup" // store each distinct state once, keyed by head
+	RawStateDiff  = "diff"  // store each distinct state once, as a diff against the previous head when possible
+)
+
+// RawStateStorageModes lists the supported ways of storing raw actor state
+var RawStateStorageModes = []string{RawStateFull, RawStateDedup, RawStateDiff}
+
 // ActorExtractor extracts common actor state
-type ActorExtractor struct{}
+type ActorExtractor struct {
+	RawState string // how raw state is stored, defaults to RawStateFull
+}
 
-func (ActorExtractor) Extract(ctx context.Context, a ActorInfo, node ActorStateAPI) (model.Persistable, error) {
+func (ae ActorExtractor) Extract(ctx context.Context, a ActorInfo, node ActorStateAPI) (model.Persistable, error) {
 	ctx, span := global.Tracer("").Start(ctx, "ActorExtractor")
 	defer span.End()
 
@@ -34,7 +46,7 @@ func (ActorExtractor) Extract(ctx context.Context, a ActorInfo, node ActorStateA
 	}
 	log.Debugw("read full actor state", "addr", a.Address.String(), "size", len(state), "code", ActorNameByCode(a.Actor.Code))
 
-	return &commonmodel.ActorTaskResult{
+	res := &commonmodel.ActorTaskResult{
 		Actor: &commonmodel.Actor{
 			Height:    int64(a.Epoch),
 			ID:        a.Address.String(),
@@ -44,11 +56,63 @@ func (ActorExtractor) Extract(ctx context.Context, a ActorInfo, node ActorStateA
 			Balance:   a.Actor.Balance.String(),
 			Nonce:     a.Actor.Nonce,
 		},
-		State: &commonmodel.ActorState{
+	}
+
+	if ae.RawState == "" || ae.RawState == RawStateFull {
+		res.State = &commonmodel.ActorState{
 			Height: int64(a.Epoch),
 			Head:   a.Actor.Head.String(),
 			Code:   a.Actor.Code.String(),
 			State:  string(state),
-		},
-	}, nil
+		}
+		return res, nil
+	}
+
+	res.Content = &commonmodel.ActorStateContent{
+		Head:   a.Actor.Head.String(),
+		Code:   a.Actor.Code.String(),
+		Height: int64(a.Epoch),
+		State:  string(state),
+	}
+	if ae.RawState == RawStateDiff {
+		ae.diffPreviousState(ctx, a, node, state, res.Content)
+	}
+	return res, nil
+}
+
+// diffPreviousState sets the diff of the content against the state of the actor in the parent tipset when the diff
+// is smaller than the full state. Failing to diff is not an error since the full state is always stored.
+func (ActorExtractor) diffPreviousState(ctx context.Context, a ActorInfo, node ActorStateAPI, state []byte, content *commonmodel.ActorStateContent) {
+	prev, err := node.StateGetActor(ctx, a.Address, a.ParentTipSet)
+	if err != nil {
+		// The actor may not have existed in the parent tipset
+		log.Debugw("no previous actor to diff state against", "addr", a.Address.String(), "error", err.Error())
+		return
+	}
+	if prev.Head == a.Actor.Head {
+		return
+	}
+
+	prevState, err := node.StateReadState(ctx, a.Address, a.ParentTipSet)
+	if err != nil {
+		log.Debugw("failed to read previous actor state", "addr", a.Address.String(), "error", err.Error())
+		return
+	}
+	prevJSON, err := json.Marshal(prevState.State)
+	if err != nil {
+		log.Debugw("failed to encode previous actor state", "addr", a.Address.String(), "error", err.Error())
+		return
+	}
+
+	patch, ok, err := commonmodel.CreateStatePatch(prevJSON, state)
+	if err != nil {
+		log.Debugw("failed to diff actor state", "addr", a.Address.String(), "error", err.Error())
+		return
+	}
+	if !ok || len(patch) >= len(state) {
+		return
+	}
+
+	content.BaseHead = prev.Head.String()
+	content.Diff = string(patch)
 }
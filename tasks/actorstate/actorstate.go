@@ -17,9 +17,7 @@ import (
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
-	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/filecoin-project/specs-actors/actors/util/adt"
-	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/raulk/clock"
@@ -28,6 +26,7 @@ import (
 	"go.opentelemetry.io/otel/api/global"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/sentinel-visor/actors"
 	"github.com/filecoin-project/sentinel-visor/lens"
 	"github.com/filecoin-project/sentinel-visor/metrics"
 	"github.com/filecoin-project/sentinel-visor/model"
@@ -63,6 +62,19 @@ type ActorStateAPI interface {
 	Store() adt.Store
 }
 
+// actorStateAPIMethods lists the names of the lens methods used by ActorStateAPI
+var actorStateAPIMethods = []string{
+	"ChainGetTipSet",
+	"ChainGetBlockMessages",
+	"StateGetReceipt",
+	"ChainHasObj",
+	"ChainReadObj",
+	"StateGetActor",
+	"StateMinerPower",
+	"StateReadState",
+	"StateMinerSectors",
+}
+
 // An ActorStateExtractor extracts actor state into a persistable format
 type ActorStateExtractor interface {
 	Extract(ctx context.Context, a ActorInfo, node ActorStateAPI) (model.Persistable, error)
@@ -95,7 +107,11 @@ func SupportedActorCodes() []cid.Cid {
 	return codes
 }
 
-func NewActorStateProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64, actorCodes []cid.Cid, useLeases bool) (*ActorStateProcessor, error) {
+func NewActorStateProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64, actorCodes []cid.Cid, useLeases bool, prefetchWorkers int, rawState string) (*ActorStateProcessor, error) {
+	if !isRawStateStorageMode(rawState) {
+		return nil, xerrors.Errorf("unsupported raw actor state storage: %q", rawState)
+	}
+
 	p := &ActorStateProcessor{
 		opener:      opener,
 		storage:     d,
@@ -106,6 +122,8 @@ func NewActorStateProcessor(d *storage.Database, opener lens.APIOpener, leaseLen
 		extractors:  map[cid.Cid]ActorStateExtractor{},
 		clock:       clock.New(),
 		useLeases:   useLeases,
+		prefetcher:  NewPrefetcher(prefetchWorkers),
+		rawState:    rawState,
 	}
 
 	extractorsMu.Lock()
@@ -134,7 +152,9 @@ type ActorStateProcessor struct {
 	actorCodes  []string                        // list of actor codes that will be requested
 	extractors  map[cid.Cid]ActorStateExtractor // list of extractors that will be used
 	clock       clock.Clock
-	useLeases   bool // when true this task will update the claimed_until column in the processing table (which can cause contention)
+	useLeases   bool        // when true this task will update the claimed_until column in the processing table (which can cause contention)
+	prefetcher  *Prefetcher // loads state for a batch concurrently ahead of extraction
+	rawState    string      // how raw actor state is stored, one of RawStateStorageModes
 }
 
 func trackDuration(topic string, w io.Writer) func() {
@@ -211,6 +231,10 @@ func (p *ActorStateProcessor) Run(ctx context.Context) error {
 	}
 	defer closer()
 
+	if err := lens.RequireMethods(node, actorStateAPIMethods...); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
 	// Loop until context is done or processing encounters a fatal error
 	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
 		return p.processBatch(ctx, node)
@@ -250,33 +274,46 @@ func (p *ActorStateProcessor) processBatch(ctx context.Context, node lens.API) (
 	}
 
 	log.Debugw("processing batch of actors", "count", len(batch))
+	var lease *storage.Lease
 	if p.useLeases {
-		var cancel func()
-		ctx, cancel = context.WithDeadline(ctx, claimUntil)
-		defer cancel()
+		// Keep renewing the lease while the batch is being processed
+		ctx, lease = p.storage.KeepLeases(ctx, storage.ActorQueue, claimUntil, p.leaseLength)
+		defer lease.Release()
 	}
 
 	stats.Record(ctx, metrics.TipsetHeight.M(batch[0].Height))
-	for _, actor := range batch {
-		// Stop processing if we have somehow passed our own lease time
-		select {
-		case <-ctx.Done():
-			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
-		default:
-		}
-
-		errorLog := log.With("actor_head", actor.Head, "actor_code", actor.Code, "tipset", actor.TipSet)
 
+	actors := make([]*visor.ProcessingActor, 0, len(batch))
+	infos := make([]ActorInfo, 0, len(batch))
+	for _, actor := range batch {
 		info, err := NewActorInfo(actor)
 		if err != nil {
+			errorLog := log.With("actor_head", actor.Head, "actor_code", actor.Code, "tipset", actor.TipSet)
 			errorLog.Errorw("unmarshal actor", "error", err.Error())
 			if err := p.storage.MarkActorComplete(ctx, actor.Height, actor.Head, actor.Code, p.clock.Now(), err.Error()); err != nil {
 				errorLog.Errorw("failed to mark actor complete", "error", err.Error())
 			}
 			continue
 		}
+		actors = append(actors, actor)
+		infos = append(infos, info)
+	}
+
+	// Load the state needed by the extractors concurrently before processing the actors one at a time
+	prefetched := p.prefetcher.Prefetch(ctx, node, infos)
+	lease.Progress()
+
+	for i, actor := range actors {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
 
-		if err := p.processActor(ctx, node, info); err != nil {
+		errorLog := log.With("actor_head", actor.Head, "actor_code", actor.Code, "tipset", actor.TipSet)
+
+		if err := p.processActor(ctx, prefetched, infos[i]); err != nil {
 			errorLog.Errorw("process actor", "error", err.Error())
 			if err := p.storage.MarkActorComplete(ctx, actor.Height, actor.Head, actor.Code, p.clock.Now(), err.Error()); err != nil {
 				errorLog.Errorw("failed to mark actor complete", "error", err.Error())
@@ -288,16 +325,17 @@ func (p *ActorStateProcessor) processBatch(ctx context.Context, node lens.API) (
 		if err := p.storage.MarkActorComplete(ctx, actor.Height, actor.Head, actor.Code, p.clock.Now(), ""); err != nil {
 			errorLog.Errorw("failed to mark actor complete", "error", err.Error())
 		}
+		lease.Progress()
 	}
 
 	return false, nil
 }
 
-func (p *ActorStateProcessor) processActor(ctx context.Context, node lens.API, info ActorInfo) error {
+func (p *ActorStateProcessor) processActor(ctx context.Context, node ActorStateAPI, info ActorInfo) error {
 	ctx, span := global.Tracer("").Start(ctx, "ActorStateProcessor.processActor")
 	defer span.End()
 
-	var ae ActorExtractor
+	ae := ActorExtractor{RawState: p.rawState}
 
 	// Persist the raw state
 	data, err := ae.Extract(ctx, info, node)
@@ -331,6 +369,15 @@ func (p *ActorStateProcessor) processActor(ctx context.Context, node lens.API, i
 	return nil
 }
 
+func isRawStateStorageMode(mode string) bool {
+	for _, m := range RawStateStorageModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
 func NewActorInfo(a *visor.ProcessingActor) (ActorInfo, error) {
 	var info ActorInfo
 
@@ -383,8 +430,5 @@ func NewActorInfo(a *visor.ProcessingActor) (ActorInfo, error) {
 // ActorNameByCode returns the name of the actor code. Agnostic to the
 // version of specs-actors.
 func ActorNameByCode(code cid.Cid) string {
-	if name := builtin.ActorNameByCode(code); name != "<unknown>" {
-		return name
-	}
-	return builtin2.ActorNameByCode(code)
+	return actors.ActorNameByCode(code)
 }
@@ -58,6 +58,10 @@ func (p *ActorStateChangeProcessor) Run(ctx context.Context) error {
 	}
 	defer closer()
 
+	if err := lens.RequireMethods(node, "ChainGetTipSet", "StateChangedActors", "StateGetActor"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
 	// Loop until context is done or processing encounters a fatal error
 	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
 		return p.processBatch(ctx, node)
@@ -86,11 +90,12 @@ func (p *ActorStateChangeProcessor) processBatch(ctx context.Context, node lens.
 	}
 
 	log.Debugw("leased batch of tipsets", "count", len(batch))
-	ctx, cancel := context.WithDeadline(ctx, claimUntil)
-	defer cancel()
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.StateChangeQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
 
 	for _, item := range batch {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
@@ -110,6 +115,7 @@ func (p *ActorStateChangeProcessor) processBatch(ctx context.Context, node lens.
 		if err := p.storage.MarkStateChangeComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
 			errorLog.Errorw("failed to mark tipset complete", "error", err.Error())
 		}
+		lease.Progress()
 	}
 
 	return false, nil
@@ -170,7 +176,7 @@ func (p *ActorStateChangeProcessor) processTipSet(ctx context.Context, node lens
 	var palist visor.ProcessingActorList
 
 	for str, act := range changes {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
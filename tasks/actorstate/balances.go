@@ -0,0 +1,376 @@
+package actorstate
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/go-pg/pg/v10"
+	"github.com/raulk/clock"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	"github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+func NewBalanceChangeProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *BalanceChangeProcessor {
+	return &BalanceChangeProcessor{
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// BalanceChangeProcessor is a task that records every change to the balance of an actor between the state of a
+// tipset's parent and the tipset's own state, including changes to actors whose head did not change and the final
+// change to zero of actors that were deleted.
+type BalanceChangeProcessor struct {
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts processing batches of tipsets until the context is done or
+// an error occurs.
+func (p *BalanceChangeProcessor) Run(ctx context.Context) error {
+	node, closer, err := p.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "ChainGetTipSet", "ChainGetParentMessages", "ChainGetParentReceipts", "StateChangedActors", "StateGetActor", "StateListActors", "StateLookupID"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	// Loop until context is done or processing encounters a fatal error
+	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
+		return p.processBatch(ctx, node)
+	})
+}
+
+func (p *BalanceChangeProcessor) processBatch(ctx context.Context, node lens.API) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "balancechanges"))
+	ctx, span := global.Tracer("").Start(ctx, "BalanceChangeProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets to work on
+	batch, err := p.storage.LeaseTipSetBalanceChanges(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset balance changes: %w", err)
+	}
+
+	// If we have no tipsets to work on then wait before trying again
+	if len(batch) == 0 {
+		sleepInterval := wait.Jitter(idleSleepInterval, 2)
+		log.Debugf("no tipsets to process, waiting for %s", sleepInterval)
+		time.Sleep(sleepInterval)
+		return false, nil
+	}
+
+	log.Debugw("leased batch of tipsets", "count", len(batch))
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.BalanceChangeQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	for _, item := range batch {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
+
+		errorLog := log.With("height", item.Height, "tipset", item.TipSet)
+
+		if err := p.processItem(ctx, node, item); err != nil {
+			errorLog.Errorw("failed to process tipset", "error", err.Error())
+			if err := p.storage.MarkTipSetBalanceChangesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), err.Error()); err != nil {
+				errorLog.Errorw("failed to mark tipset balance changes complete", "error", err.Error())
+			}
+			return false, xerrors.Errorf("process item: %w", err)
+		}
+
+		if err := p.storage.MarkTipSetBalanceChangesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
+			errorLog.Errorw("failed to mark tipset balance changes complete", "error", err.Error())
+		}
+		lease.Progress()
+	}
+
+	return false, nil
+}
+
+func (p *BalanceChangeProcessor) processItem(ctx context.Context, node lens.API, item *visor.ProcessingTipSet) error {
+	ctx, span := global.Tracer("").Start(ctx, "BalanceChangeProcessor.processItem")
+	defer span.End()
+	span.SetAttributes(label.Any("height", item.Height), label.Any("tipset", item.TipSet))
+
+	stats.Record(ctx, metrics.TipsetHeight.M(item.Height))
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	tsk, err := item.TipSetKey()
+	if err != nil {
+		return xerrors.Errorf("get tipsetkey: %w", err)
+	}
+
+	ts, err := node.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return xerrors.Errorf("get tipset: %w", err)
+	}
+
+	var balances map[string]actorBalance
+	causes := map[string]string{}
+	if ts.Height() == 0 {
+		balances, err = genesisBalances(ctx, node, ts)
+		if err != nil {
+			return xerrors.Errorf("get genesis balances: %w", err)
+		}
+	} else {
+		balances, err = changedBalances(ctx, node, ts)
+		if err != nil {
+			return xerrors.Errorf("get changed balances: %w", err)
+		}
+
+		parties, err := executedMessageParties(ctx, node, ts)
+		if err != nil {
+			return xerrors.Errorf("get executed messages: %w", err)
+		}
+		causes = balanceChangeCauses(parties)
+	}
+
+	changes := balanceChanges(int64(ts.Height()), ts.ParentState().String(), balances, causes)
+
+	log.Debugw("persisting balance changes", "height", int64(ts.Height()), "count", len(changes))
+
+	if err := p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return changes.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return xerrors.Errorf("persist: %w", err)
+	}
+
+	return nil
+}
+
+// actorBalance is the balance of an actor before and after a tipset's parent messages were executed
+type actorBalance struct {
+	Old abi.TokenAmount
+	New abi.TokenAmount
+}
+
+// genesisBalances returns the balance of every actor in the genesis state, each of which starts from zero.
+func genesisBalances(ctx context.Context, node lens.API, ts *types.TipSet) (map[string]actorBalance, error) {
+	addrs, err := node.StateListActors(ctx, ts.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("list actors: %w", err)
+	}
+
+	balances := make(map[string]actorBalance, len(addrs))
+	for _, addr := range addrs {
+		act, err := node.StateGetActor(ctx, addr, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("get actor %s: %w", addr, err)
+		}
+		balances[addr.String()] = actorBalance{Old: big.Zero(), New: act.Balance}
+	}
+	return balances, nil
+}
+
+// changedBalances returns the balance before and after of each actor whose state changed between the state of the
+// tipset's parent and the tipset's own state. Actors created by the change start from zero and actors deleted by it
+// end at zero.
+func changedBalances(ctx context.Context, node lens.API, ts *types.TipSet) (map[string]actorBalance, error) {
+	pts, err := node.ChainGetTipSet(ctx, ts.Parents())
+	if err != nil {
+		return nil, xerrors.Errorf("get parent tipset: %w", err)
+	}
+
+	changes, err := node.StateChangedActors(ctx, pts.ParentState(), ts.ParentState())
+	if err != nil {
+		return nil, xerrors.Errorf("get actor changes: %w", err)
+	}
+
+	balances := make(map[string]actorBalance, len(changes))
+	for str, act := range changes {
+		addr, err := address.NewFromString(str)
+		if err != nil {
+			return nil, xerrors.Errorf("parse address: %w", err)
+		}
+
+		old := big.Zero()
+		prev, err := node.StateGetActor(ctx, addr, pts.Key())
+		if err != nil {
+			if !strings.Contains(err.Error(), "actor not found") {
+				return nil, xerrors.Errorf("get previous actor %s: %w", str, err)
+			}
+		} else {
+			old = prev.Balance
+		}
+
+		balances[str] = actorBalance{Old: old, New: act.Balance}
+	}
+
+	// The lens only reports actors present in the new state, so deleted actors are found by comparing the states the
+	// other way around
+	reverse, err := node.StateChangedActors(ctx, ts.ParentState(), pts.ParentState())
+	if err != nil {
+		return nil, xerrors.Errorf("get reverse actor changes: %w", err)
+	}
+	for str, b := range deletedBalances(changes, reverse) {
+		balances[str] = b
+	}
+
+	return balances, nil
+}
+
+// deletedBalances returns the final balance change of each actor that was deleted, given the actors that changed
+// from the old state to the new state and those that changed from the new state back to the old state. An actor
+// that was deleted differs between the states but is only present in the old state.
+func deletedBalances(changes, reverse map[string]types.Actor) map[string]actorBalance {
+	deleted := map[string]actorBalance{}
+	for str, act := range reverse {
+		if _, ok := changes[str]; ok {
+			continue
+		}
+		deleted[str] = actorBalance{Old: act.Balance, New: big.Zero()}
+	}
+	return deleted
+}
+
+// messageParties are the ID addresses whose balances were affected directly by an executed message
+type messageParties struct {
+	Cid  string
+	From string
+	To   string // empty if the message failed, since it transferred no value
+}
+
+// executedMessageParties returns the parties to each message executed to produce the tipset's state.
+func executedMessageParties(ctx context.Context, node lens.API, ts *types.TipSet) ([]messageParties, error) {
+	// All blocks in the tipset share the same parent messages and receipts
+	blk := ts.Cids()[0]
+
+	msgs, err := node.ChainGetParentMessages(ctx, blk)
+	if err != nil {
+		return nil, xerrors.Errorf("get parent messages: %w", err)
+	}
+
+	rcpts, err := node.ChainGetParentReceipts(ctx, blk)
+	if err != nil {
+		return nil, xerrors.Errorf("get parent receipts: %w", err)
+	}
+
+	if len(msgs) != len(rcpts) {
+		return nil, xerrors.Errorf("mismatching number of parent messages (%d) and receipts (%d)", len(msgs), len(rcpts))
+	}
+
+	// Addresses are resolved in the tipset's state so actors created by the messages can be found
+	ids := map[address.Address]string{}
+	lookupID := func(addr address.Address) string {
+		if id, ok := ids[addr]; ok {
+			return id
+		}
+		id := ""
+		if addr.Protocol() == address.ID {
+			id = addr.String()
+		} else if idAddr, err := node.StateLookupID(ctx, addr, ts.Key()); err == nil {
+			id = idAddr.String()
+		} else {
+			log.Debugw("failed to resolve message address", "addr", addr.String(), "error", err.Error())
+		}
+		ids[addr] = id
+		return id
+	}
+
+	out := make([]messageParties, 0, len(msgs))
+	for i, m := range msgs {
+		mp := messageParties{
+			Cid:  m.Message.Cid().String(),
+			From: lookupID(m.Message.From),
+		}
+		if rcpts[i].ExitCode == exitcode.Ok {
+			mp.To = lookupID(m.Message.To)
+		}
+		out = append(out, mp)
+	}
+	return out, nil
+}
+
+// balanceChangeCauses returns the message that caused the change to the balance of each address that was a party to
+// exactly one executed message. Other balance changes have no known cause.
+func balanceChangeCauses(parties []messageParties) map[string]string {
+	seen := map[string]map[string]struct{}{}
+	add := func(addr, msg string) {
+		if addr == "" {
+			return
+		}
+		if seen[addr] == nil {
+			seen[addr] = map[string]struct{}{}
+		}
+		seen[addr][msg] = struct{}{}
+	}
+
+	for _, mp := range parties {
+		add(mp.From, mp.Cid)
+		add(mp.To, mp.Cid)
+	}
+
+	causes := map[string]string{}
+	for addr, msgs := range seen {
+		if len(msgs) != 1 {
+			continue
+		}
+		for msg := range msgs {
+			causes[addr] = msg
+		}
+	}
+	return causes
+}
+
+// balanceChanges returns the changes to the balances of actors at a height, in address order, omitting actors whose
+// balance did not change.
+func balanceChanges(height int64, stateRoot string, balances map[string]actorBalance, causes map[string]string) derived.BalanceChangeList {
+	out := make(derived.BalanceChangeList, 0, len(balances))
+	for addr, b := range balances {
+		if b.Old.Equals(b.New) {
+			continue
+		}
+		out = append(out, &derived.BalanceChange{
+			Height:     height,
+			Address:    addr,
+			StateRoot:  stateRoot,
+			OldBalance: b.Old.String(),
+			NewBalance: b.New.String(),
+			Delta:      big.Sub(b.New, b.Old).String(),
+			MessageCid: causes[addr],
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
@@ -0,0 +1,83 @@
+package actorstate
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/model/derived"
+)
+
+func TestBalanceChangeCauses(t *testing.T) {
+	parties := []messageParties{
+		{Cid: "msg1", From: "f0100", To: "f0101"},
+		{Cid: "msg2", From: "f0100", To: "f0102"},
+		{Cid: "msg3", From: "f0103"},              // failed, only the sender paid for gas
+		{Cid: "msg4", From: "f0104", To: "f0104"}, // sent to itself
+		{Cid: "msg5", From: "", To: "f0105"},      // sender could not be resolved
+		{Cid: "msg6", From: "f0106", To: "f0101"}, // second message to f0101
+		{Cid: "msg7", From: "f0107", To: "f0108"}, // single message for both parties
+	}
+
+	causes := balanceChangeCauses(parties)
+
+	assert.Equal(t, map[string]string{
+		"f0102": "msg2",
+		"f0103": "msg3",
+		"f0104": "msg4",
+		"f0105": "msg5",
+		"f0106": "msg6",
+		"f0107": "msg7",
+		"f0108": "msg7",
+	}, causes)
+}
+
+func TestBalanceChanges(t *testing.T) {
+	balances := map[string]actorBalance{
+		"f0102": {Old: abi.NewTokenAmount(100), New: abi.NewTokenAmount(150)},
+		"f0101": {Old: abi.NewTokenAmount(100), New: abi.NewTokenAmount(40)},
+		"f0103": {Old: abi.NewTokenAmount(100), New: abi.NewTokenAmount(100)}, // head changed but balance did not
+		"f0104": {Old: abi.NewTokenAmount(0), New: abi.NewTokenAmount(7)},     // created
+	}
+	causes := map[string]string{
+		"f0101": "msg1",
+		"f0103": "msg3",
+	}
+
+	changes := balanceChanges(10, "stateroot", balances, causes)
+	require.Len(t, changes, 3)
+
+	assert.Equal(t, derived.BalanceChangeList{
+		{Height: 10, Address: "f0101", StateRoot: "stateroot", OldBalance: "100", NewBalance: "40", Delta: "-60", MessageCid: "msg1"},
+		{Height: 10, Address: "f0102", StateRoot: "stateroot", OldBalance: "100", NewBalance: "150", Delta: "50"},
+		{Height: 10, Address: "f0104", StateRoot: "stateroot", OldBalance: "0", NewBalance: "7", Delta: "7"},
+	}, changes)
+}
+
+func TestDeletedBalances(t *testing.T) {
+	changes := map[string]types.Actor{
+		"f0101": {Balance: abi.NewTokenAmount(40)}, // changed
+		"f0104": {Balance: abi.NewTokenAmount(7)},  // created
+	}
+	reverse := map[string]types.Actor{
+		"f0101": {Balance: abi.NewTokenAmount(100)},
+		"f0105": {Balance: abi.NewTokenAmount(25)}, // deleted, such as a settled payment channel
+		"f0106": {Balance: abi.NewTokenAmount(0)},  // deleted with no balance
+	}
+
+	deleted := deletedBalances(changes, reverse)
+	assert.Equal(t, map[string]actorBalance{
+		"f0105": {Old: abi.NewTokenAmount(25), New: big.Zero()},
+		"f0106": {Old: abi.NewTokenAmount(0), New: big.Zero()},
+	}, deleted)
+
+	// Only the deleted actor with a balance is recorded as a change
+	changed := balanceChanges(10, "stateroot", deleted, nil)
+	assert.Equal(t, derived.BalanceChangeList{
+		{Height: 10, Address: "f0105", StateRoot: "stateroot", OldBalance: "25", NewBalance: "0", Delta: "-25"},
+	}, changed)
+}
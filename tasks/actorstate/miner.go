@@ -3,6 +3,7 @@ package actorstate
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 
 	"github.com/filecoin-project/go-address"
 	maddr "github.com/multiformats/go-multiaddr"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/filecoin-project/go-bitfield"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
 	miner "github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
 	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
@@ -70,13 +72,31 @@ func (m StorageMinerExtractor) Extract(ctx context.Context, a ActorInfo, node Ac
 		return nil, xerrors.Errorf("extracting miner sector changes: %w", err)
 	}
 
-	posts, err := ExtractMinerPoSts(ctx, &a, ec, node)
+	sectorPostsModel, partitionPostsModel, err := ExtractMinerPoSts(ctx, &a, ec, node)
 	if err != nil {
 		return nil, xerrors.Errorf("extracting miner posts: %v", err)
 	}
 
+	closed, err := closedDeadline(ec)
+	if err != nil {
+		return nil, xerrors.Errorf("finding closed deadline: %w", err)
+	}
+
+	missedPostsModel, err := ExtractMinerMissedPoSts(ctx, &a, ec, closed, partitionPostsModel)
+	if err != nil {
+		return nil, xerrors.Errorf("extracting miner missed posts: %w", err)
+	}
+	partitionPostsModel = append(partitionPostsModel, missedPostsModel...)
+
+	partitionFaultsModel, err := ExtractMinerPartitionFaults(ctx, &a, ec, closed)
+	if err != nil {
+		return nil, xerrors.Errorf("extracting miner partition faults: %w", err)
+	}
+
 	return &minermodel.MinerTaskResult{
-		Posts: posts,
+		SectorPostsModel:     sectorPostsModel,
+		PartitionPostsModel:  partitionPostsModel,
+		PartitionFaultsModel: partitionFaultsModel,
 
 		MinerInfoModel:           minerInfoModel,
 		LockedFundsModel:         lockedFundsModel,
@@ -106,6 +126,7 @@ func NewMinerStateExtractionContext(ctx context.Context, a ActorInfo, node Actor
 	}
 
 	prevState := curState
+	prevTipset := curTipset
 	if a.Epoch != 0 {
 		prevActor, err := node.StateGetActor(ctx, a.Address, a.ParentTipSet)
 		if err != nil {
@@ -116,10 +137,16 @@ func NewMinerStateExtractionContext(ctx context.Context, a ActorInfo, node Actor
 		if err != nil {
 			return nil, xerrors.Errorf("loading previous miner actor state: %w", err)
 		}
+
+		prevTipset, err = node.ChainGetTipSet(ctx, a.ParentTipSet)
+		if err != nil {
+			return nil, xerrors.Errorf("loading parent tipset: %w", err)
+		}
 	}
 
 	return &MinerStateExtractionContext{
 		PrevState: prevState,
+		PrevTs:    prevTipset,
 		CurrActor: curActor,
 		CurrState: curState,
 		CurrTs:    curTipset,
@@ -128,10 +155,28 @@ func NewMinerStateExtractionContext(ctx context.Context, a ActorInfo, node Actor
 
 type MinerStateExtractionContext struct {
 	PrevState miner.State
+	PrevTs    *types.TipSet // parent tipset, whose messages were executed to produce the current state
 
 	CurrActor *types.Actor
 	CurrState miner.State
 	CurrTs    *types.TipSet
+
+	dlDiff       miner.DeadlinesDiff
+	dlDiffLoaded bool
+}
+
+// DiffDeadlines returns the changes to the partitions of the miner's deadlines between the previous and current
+// states, loading them on first use.
+func (m *MinerStateExtractionContext) DiffDeadlines() (miner.DeadlinesDiff, error) {
+	if !m.dlDiffLoaded {
+		dlDiff, err := miner.DiffDeadlines(m.PrevState, m.CurrState)
+		if err != nil {
+			return nil, err
+		}
+		m.dlDiff = dlDiff
+		m.dlDiffLoaded = true
+	}
+	return m.dlDiff, nil
 }
 
 func (m *MinerStateExtractionContext) IsGenesis() bool {
@@ -373,40 +418,41 @@ func ExtractMinerSectorData(ctx context.Context, ec *MinerStateExtractionContext
 	return preCommitModel, sectorModel, sectorDealsModel, sectorEventModel, nil
 }
 
-func ExtractMinerPoSts(ctx context.Context, actor *ActorInfo, ec *MinerStateExtractionContext, node ActorStateAPI) (map[uint64]cid.Cid, error) {
+// ExtractMinerPoSts extracts the window PoSts successfully submitted by the miner in the messages of the parent
+// tipset, whose execution produced the current state. It returns the message that proved each sector and a record of
+// each partition proven. Messages are read from every block in the parent tipset since a PoSt may be included by any
+// of them.
+func ExtractMinerPoSts(ctx context.Context, actor *ActorInfo, ec *MinerStateExtractionContext, node ActorStateAPI) (minermodel.MinerSectorPostList, minermodel.MinerPartitionPostList, error) {
 	// short circuit genesis state, no PoSt messages in genesis blocks.
 	if ec.IsGenesis() {
-		return nil, nil
-	}
-	posts := make(map[uint64]cid.Cid)
-	block := actor.TipSet.Cids()[0]
-	msgs, err := node.ChainGetBlockMessages(ctx, block)
-	if err != nil {
-		return nil, xerrors.Errorf("diffing miner posts: %v", err)
+		return nil, nil, nil
 	}
 
-	var partitions map[uint64]miner.Partition
-	loadPartitions := func(state miner.State, epoch abi.ChainEpoch) (map[uint64]miner.Partition, error) {
-		info, err := state.DeadlineInfo(epoch)
-		if err != nil {
-			return nil, err
-		}
-		dline, err := state.LoadDeadline(info.Index)
-		if err != nil {
-			return nil, err
-		}
-		pmap := make(map[uint64]miner.Partition)
-		if err := dline.ForEachPartition(func(idx uint64, p miner.Partition) error {
-			pmap[idx] = p
-			return nil
-		}); err != nil {
-			return nil, err
+	var sectorPosts minermodel.MinerSectorPostList
+	var partitionPosts minermodel.MinerPartitionPostList
+
+	// Partitions are loaded from the state the PoSt was submitted against
+	deadlines := make(map[uint64]miner.Deadline)
+	loadPartition := func(dlIdx, partIdx uint64) (miner.Partition, error) {
+		dl, ok := deadlines[dlIdx]
+		if !ok {
+			var err error
+			dl, err = ec.PrevState.LoadDeadline(dlIdx)
+			if err != nil {
+				return nil, err
+			}
+			deadlines[dlIdx] = dl
 		}
-		return pmap, nil
+		return dl.LoadPartition(partIdx)
+	}
+
+	// The deadline that was current when the parent tipset's messages were executed
+	prevDeadlineInfo, err := ec.PrevState.DeadlineInfo(ec.PrevTs.Height())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading previous deadline info: %w", err)
 	}
 
 	processPostMsg := func(msg *types.Message) error {
-		sectors := make([]uint64, 0)
 		rcpt, err := node.StateGetReceipt(ctx, msg.Cid(), actor.TipSet)
 		if err != nil {
 			return err
@@ -419,15 +465,12 @@ func ExtractMinerPoSts(ctx context.Context, actor *ActorInfo, ec *MinerStateExtr
 			return err
 		}
 
-		if partitions == nil {
-			partitions, err = loadPartitions(ec.CurrState, ec.CurrTs.Height())
+		for _, p := range params.Partitions {
+			partition, err := loadPartition(params.Deadline, p.Index)
 			if err != nil {
-				return err
+				return xerrors.Errorf("loading partition %d of deadline %d: %w", p.Index, params.Deadline, err)
 			}
-		}
-
-		for _, p := range params.Partitions {
-			all, err := partitions[p.Index].AllSectors()
+			all, err := partition.AllSectors()
 			if err != nil {
 				return err
 			}
@@ -436,35 +479,251 @@ func ExtractMinerPoSts(ctx context.Context, actor *ActorInfo, ec *MinerStateExtr
 				return err
 			}
 
+			var provenCount int64
 			if err := proven.ForEach(func(sector uint64) error {
-				sectors = append(sectors, sector)
+				provenCount++
+				sectorPosts = append(sectorPosts, &minermodel.MinerSectorPost{
+					Height:         int64(ec.CurrTs.Height()),
+					MinerID:        actor.Address.String(),
+					SectorID:       sector,
+					PostMessageCID: msg.Cid().String(),
+				})
 				return nil
 			}); err != nil {
 				return err
 			}
-		}
 
-		for _, s := range sectors {
-			posts[s] = msg.Cid()
+			skipped, err := bitfieldJSON(p.Skipped)
+			if err != nil {
+				return err
+			}
+
+			partitionPosts = append(partitionPosts, &minermodel.MinerPartitionPost{
+				Height:         int64(ec.CurrTs.Height()),
+				MinerID:        actor.Address.String(),
+				DeadlineIndex:  params.Deadline,
+				PartitionIndex: p.Index,
+				StateRoot:      actor.ParentStateRoot.String(),
+				PeriodStart:    int64(prevDeadlineInfo.PeriodStart),
+				OnTime:         params.Deadline == prevDeadlineInfo.Index,
+				PostMessageCID: msg.Cid().String(),
+				ProvenSectors:  provenCount,
+				SkippedSectors: skipped,
+			})
 		}
+
 		return nil
 	}
 
-	for _, msg := range msgs.BlsMessages {
-		if msg.To == actor.Address && msg.Method == 5 /* miner.SubmitWindowedPoSt */ {
-			if err := processPostMsg(msg); err != nil {
-				return nil, err
+	msgsSeen := make(map[cid.Cid]struct{})
+	for _, blk := range ec.PrevTs.Cids() {
+		msgs, err := node.ChainGetBlockMessages(ctx, blk)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("diffing miner posts: %v", err)
+		}
+
+		vmm := make([]*types.Message, 0, len(msgs.Cids))
+		for _, m := range msgs.BlsMessages {
+			vmm = append(vmm, m)
+		}
+		for _, m := range msgs.SecpkMessages {
+			vmm = append(vmm, &m.Message)
+		}
+
+		for _, msg := range vmm {
+			// A message included by more than one block is only executed once
+			if _, seen := msgsSeen[msg.Cid()]; seen {
+				continue
+			}
+			msgsSeen[msg.Cid()] = struct{}{}
+
+			// SubmitWindowedPoSt has the same method number in every actors version
+			if msg.To == actor.Address && msg.Method == sa0builtin.MethodsMiner.SubmitWindowedPoSt {
+				if err := processPostMsg(msg); err != nil {
+					return nil, nil, err
+				}
 			}
 		}
 	}
-	for _, msg := range msgs.SecpkMessages {
-		if msg.Message.To == actor.Address && msg.Message.Method == 5 /* miner.SubmitWindowedPoSt */ {
-			if err := processPostMsg(&msg.Message); err != nil {
-				return nil, err
+	return sectorPosts, partitionPosts, nil
+}
+
+// ExtractMinerMissedPoSts records the partitions of closed, the deadline that closed while producing the current
+// state, that had no PoSt submitted for them, either earlier in the deadline or in the posts included by the parent
+// tipset. Nothing is recorded when closed is nil.
+func ExtractMinerMissedPoSts(ctx context.Context, actor *ActorInfo, ec *MinerStateExtractionContext, closed *dline.Info, posts minermodel.MinerPartitionPostList) (minermodel.MinerPartitionPostList, error) {
+	if ec.IsGenesis() || closed == nil {
+		return nil, nil
+	}
+
+	dl, err := ec.PrevState.LoadDeadline(closed.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("loading deadline %d: %w", closed.Index, err)
+	}
+
+	submitted, err := dl.PostSubmissions()
+	if err != nil {
+		return nil, xerrors.Errorf("loading post submissions of deadline %d: %w", closed.Index, err)
+	}
+
+	posted := make(map[uint64]bool)
+	if err := submitted.ForEach(func(idx uint64) error {
+		posted[idx] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		if p.DeadlineIndex == closed.Index {
+			posted[p.PartitionIndex] = true
+		}
+	}
+
+	var out minermodel.MinerPartitionPostList
+	if err := dl.ForEachPartition(func(idx uint64, p miner.Partition) error {
+		if posted[idx] {
+			return nil
+		}
+
+		// Partitions with no live sectors do not need to be proven
+		live, err := p.LiveSectors()
+		if err != nil {
+			return err
+		}
+		count, err := live.Count()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+
+		skipped, err := bitfieldJSON(live)
+		if err != nil {
+			return err
+		}
+
+		out = append(out, &minermodel.MinerPartitionPost{
+			Height:         int64(ec.CurrTs.Height()),
+			MinerID:        actor.Address.String(),
+			DeadlineIndex:  closed.Index,
+			PartitionIndex: idx,
+			StateRoot:      actor.ParentStateRoot.String(),
+			PeriodStart:    int64(closed.PeriodStart),
+			OnTime:         false,
+			SkippedSectors: skipped,
+		})
+		return nil
+	}); err != nil {
+		return nil, xerrors.Errorf("walking partitions of deadline %d: %w", closed.Index, err)
+	}
+
+	return out, nil
+}
+
+// closedDeadline returns the deadline that closed while producing the current state, or nil if the current deadline
+// did not change.
+func closedDeadline(ec *MinerStateExtractionContext) (*dline.Info, error) {
+	if ec.IsGenesis() {
+		return nil, nil
+	}
+
+	prevDeadlineInfo, err := ec.PrevState.DeadlineInfo(ec.PrevTs.Height())
+	if err != nil {
+		return nil, xerrors.Errorf("loading previous deadline info: %w", err)
+	}
+	currDeadlineInfo, err := ec.CurrState.DeadlineInfo(ec.CurrTs.Height())
+	if err != nil {
+		return nil, xerrors.Errorf("loading current deadline info: %w", err)
+	}
+
+	if !prevDeadlineInfo.PeriodStarted() {
+		return nil, nil
+	}
+	if prevDeadlineInfo.Index == currDeadlineInfo.Index && prevDeadlineInfo.PeriodStart == currDeadlineInfo.PeriodStart {
+		return nil, nil
+	}
+	return prevDeadlineInfo, nil
+}
+
+// ExtractMinerPartitionFaults records the sectors of each partition whose fault status changed while producing the
+// current state. closed is the deadline that closed while producing the current state, nil if none did.
+func ExtractMinerPartitionFaults(ctx context.Context, actor *ActorInfo, ec *MinerStateExtractionContext, closed *dline.Info) (minermodel.MinerPartitionFaultList, error) {
+	ctx, span := global.Tracer("").Start(ctx, "StorageMinerExtractor.partitionFaults")
+	defer span.End()
+
+	// short circuit genesis state.
+	if ec.IsGenesis() {
+		return nil, nil
+	}
+
+	dlDiff, err := ec.DiffDeadlines()
+	if err != nil {
+		return nil, err
+	}
+	if dlDiff == nil {
+		return nil, nil
+	}
+
+	var out minermodel.MinerPartitionFaultList
+	for dlIdx, deadline := range dlDiff {
+		for partIdx, partition := range deadline {
+			if partition == nil {
+				continue
 			}
+
+			changed := false
+			fields := make([]string, 4)
+			for i, bf := range []bitfield.BitField{partition.Faulted, partition.Recovering, partition.Recovered, partition.Removed} {
+				count, err := bf.Count()
+				if err != nil {
+					return nil, err
+				}
+				if count == 0 {
+					continue
+				}
+				changed = true
+				fields[i], err = bitfieldJSON(bf)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !changed {
+				continue
+			}
+
+			out = append(out, &minermodel.MinerPartitionFault{
+				Height:         int64(ec.CurrTs.Height()),
+				MinerID:        actor.Address.String(),
+				DeadlineIndex:  uint64(dlIdx),
+				PartitionIndex: uint64(partIdx),
+				StateRoot:      actor.ParentStateRoot.String(),
+				DeadlineClosed: closed != nil && closed.Index == uint64(dlIdx),
+				Faulted:        fields[0],
+				Recovering:     fields[1],
+				Recovered:      fields[2],
+				Removed:        fields[3],
+			})
 		}
 	}
-	return posts, nil
+
+	return out, nil
+}
+
+// bitfieldJSON returns the set bits of a bitfield as a json array.
+func bitfieldJSON(bf bitfield.BitField) (string, error) {
+	bits := make([]uint64, 0)
+	if err := bf.ForEach(func(u uint64) error {
+		bits = append(bits, u)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(bits)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
 
 func extractMinerSectorEvents(ctx context.Context, node ActorStateAPI, a ActorInfo, ec *MinerStateExtractionContext, sc *miner.SectorChanges, pc *miner.PreCommitChanges) (minermodel.MinerSectorEventList, error) {
@@ -618,7 +877,7 @@ func extractMinerPartitionsDiff(ctx context.Context, ec *MinerStateExtractionCon
 		return nil, nil
 	}
 
-	dlDiff, err := miner.DiffDeadlines(ec.PrevState, ec.CurrState)
+	dlDiff, err := ec.DiffDeadlines()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,354 @@
+package actorstate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	miner "github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa0miner "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	minermodel "github.com/filecoin-project/sentinel-visor/model/actors/miner"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestBitfieldJSON(t *testing.T) {
+	s, err := bitfieldJSON(bitfield.New())
+	require.NoError(t, err)
+	assert.Equal(t, "[]", s)
+
+	s, err = bitfieldJSON(bitfield.NewFromSet([]uint64{3, 1, 20}))
+	require.NoError(t, err)
+	assert.Equal(t, "[1,3,20]", s)
+}
+
+// fakeMinerState serves the deadlines of a miner from memory. Deadlines are 60 epochs long and the miner's proving
+// period starts at a multiple of 2880 epochs.
+type fakeMinerState struct {
+	miner.State
+	deadlines map[uint64]*fakeDeadline
+}
+
+func (s *fakeMinerState) DeadlineInfo(epoch abi.ChainEpoch) (*dline.Info, error) {
+	return &dline.Info{
+		CurrentEpoch: epoch,
+		PeriodStart:  epoch - epoch%2880,
+		Index:        uint64(epoch%2880) / 60,
+	}, nil
+}
+
+func (s *fakeMinerState) LoadDeadline(idx uint64) (miner.Deadline, error) {
+	dl, ok := s.deadlines[idx]
+	if !ok {
+		return nil, xerrors.Errorf("deadline %d not found", idx)
+	}
+	return dl, nil
+}
+
+type fakeDeadline struct {
+	miner.Deadline
+	partitions      []*fakePartition
+	postSubmissions []uint64
+}
+
+func (d *fakeDeadline) LoadPartition(idx uint64) (miner.Partition, error) {
+	if idx >= uint64(len(d.partitions)) {
+		return nil, xerrors.Errorf("partition %d not found", idx)
+	}
+	return d.partitions[idx], nil
+}
+
+func (d *fakeDeadline) ForEachPartition(cb func(idx uint64, part miner.Partition) error) error {
+	for i, p := range d.partitions {
+		if err := cb(uint64(i), p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *fakeDeadline) PostSubmissions() (bitfield.BitField, error) {
+	return bitfield.NewFromSet(d.postSubmissions), nil
+}
+
+type fakePartition struct {
+	miner.Partition
+	all  []uint64
+	live []uint64
+}
+
+func (p *fakePartition) AllSectors() (bitfield.BitField, error) {
+	return bitfield.NewFromSet(p.all), nil
+}
+
+func (p *fakePartition) LiveSectors() (bitfield.BitField, error) {
+	return bitfield.NewFromSet(p.live), nil
+}
+
+func tipsetAtHeight(t *testing.T, height abi.ChainEpoch) *types.TipSet {
+	minerAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 minerAddr,
+		Height:                height,
+		ParentStateRoot:       testutil.RandomCid(),
+		Messages:              testutil.RandomCid(),
+		ParentMessageReceipts: testutil.RandomCid(),
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+	}})
+	require.NoError(t, err)
+	return ts
+}
+
+// minerExtractionContext returns a context for a miner whose state was produced by executing a tipset at prevHeight
+func minerExtractionContext(t *testing.T, prevHeight, currHeight abi.ChainEpoch, st *fakeMinerState) *MinerStateExtractionContext {
+	return &MinerStateExtractionContext{
+		PrevState: st,
+		PrevTs:    tipsetAtHeight(t, prevHeight),
+		CurrState: st,
+		CurrTs:    tipsetAtHeight(t, currHeight),
+	}
+}
+
+func TestClosedDeadline(t *testing.T) {
+	st := &fakeMinerState{}
+
+	testCases := []struct {
+		name       string
+		prevHeight abi.ChainEpoch
+		currHeight abi.ChainEpoch
+		closed     *uint64
+	}{
+		{name: "genesis", prevHeight: 0, currHeight: 0},
+		{name: "same deadline", prevHeight: 100, currHeight: 101},
+		{name: "next deadline", prevHeight: 119, currHeight: 120, closed: uint64Ptr(1)},
+		{name: "after null rounds", prevHeight: 110, currHeight: 250, closed: uint64Ptr(1)},
+		{name: "next proving period", prevHeight: 2879, currHeight: 2880, closed: uint64Ptr(47)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			closed, err := closedDeadline(minerExtractionContext(t, tc.prevHeight, tc.currHeight, st))
+			require.NoError(t, err)
+			if tc.closed == nil {
+				assert.Nil(t, closed)
+				return
+			}
+			require.NotNil(t, closed)
+			assert.Equal(t, *tc.closed, closed.Index)
+		})
+	}
+}
+
+func uint64Ptr(v uint64) *uint64 {
+	return &v
+}
+
+func TestExtractMinerMissedPoSts(t *testing.T) {
+	ctx := context.Background()
+
+	minerAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	info := &ActorInfo{Address: minerAddr, ParentStateRoot: testutil.RandomCid()}
+
+	st := &fakeMinerState{
+		deadlines: map[uint64]*fakeDeadline{
+			1: {
+				partitions: []*fakePartition{
+					{all: []uint64{1, 2}, live: []uint64{1, 2}}, // proven earlier in the deadline
+					{all: []uint64{3}, live: []uint64{3}},       // proven by the parent tipset
+					{all: []uint64{4}},                          // no live sectors
+					{all: []uint64{5, 6, 7}, live: []uint64{5, 6}},
+				},
+				postSubmissions: []uint64{0},
+			},
+		},
+	}
+	ec := minerExtractionContext(t, 119, 120, st)
+
+	posts := minermodel.MinerPartitionPostList{
+		{DeadlineIndex: 1, PartitionIndex: 1},
+		{DeadlineIndex: 2, PartitionIndex: 3},
+	}
+
+	t.Run("closed deadline", func(t *testing.T) {
+		closed, err := closedDeadline(ec)
+		require.NoError(t, err)
+
+		missed, err := ExtractMinerMissedPoSts(ctx, info, ec, closed, posts)
+		require.NoError(t, err)
+		require.Len(t, missed, 1)
+
+		assert.EqualValues(t, 120, missed[0].Height)
+		assert.Equal(t, minerAddr.String(), missed[0].MinerID)
+		assert.EqualValues(t, 1, missed[0].DeadlineIndex)
+		assert.EqualValues(t, 3, missed[0].PartitionIndex)
+		assert.False(t, missed[0].OnTime)
+		assert.Equal(t, "", missed[0].PostMessageCID)
+		assert.Equal(t, "[5,6]", missed[0].SkippedSectors)
+	})
+
+	t.Run("no closed deadline", func(t *testing.T) {
+		missed, err := ExtractMinerMissedPoSts(ctx, info, ec, nil, posts)
+		require.NoError(t, err)
+		assert.Empty(t, missed)
+	})
+}
+
+func TestExtractMinerPartitionFaults(t *testing.T) {
+	ctx := context.Background()
+
+	minerAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	info := &ActorInfo{Address: minerAddr, ParentStateRoot: testutil.RandomCid()}
+
+	bf := func(bits ...uint64) bitfield.BitField {
+		return bitfield.NewFromSet(bits)
+	}
+
+	ec := minerExtractionContext(t, 119, 120, &fakeMinerState{})
+	ec.dlDiffLoaded = true
+	ec.dlDiff = miner.DeadlinesDiff{
+		1: miner.DeadlineDiff{
+			0: &miner.PartitionDiff{Faulted: bf(7), Recovering: bf(), Recovered: bf(), Removed: bf()},
+			1: &miner.PartitionDiff{Faulted: bf(), Recovering: bf(), Recovered: bf(), Removed: bf()}, // unchanged
+		},
+		2: miner.DeadlineDiff{
+			0: &miner.PartitionDiff{Faulted: bf(), Recovering: bf(8), Recovered: bf(9), Removed: bf(10, 11)},
+		},
+	}
+
+	closed, err := closedDeadline(ec)
+	require.NoError(t, err)
+	require.NotNil(t, closed)
+
+	faults, err := ExtractMinerPartitionFaults(ctx, info, ec, closed)
+	require.NoError(t, err)
+	require.Len(t, faults, 2)
+
+	byDeadline := map[uint64]*minermodel.MinerPartitionFault{}
+	for _, f := range faults {
+		assert.EqualValues(t, 120, f.Height)
+		assert.EqualValues(t, 0, f.PartitionIndex)
+		byDeadline[f.DeadlineIndex] = f
+	}
+
+	require.Contains(t, byDeadline, uint64(1))
+	assert.True(t, byDeadline[1].DeadlineClosed)
+	assert.Equal(t, "[7]", byDeadline[1].Faulted)
+	assert.Equal(t, "", byDeadline[1].Recovering)
+
+	require.Contains(t, byDeadline, uint64(2))
+	assert.False(t, byDeadline[2].DeadlineClosed)
+	assert.Equal(t, "", byDeadline[2].Faulted)
+	assert.Equal(t, "[8]", byDeadline[2].Recovering)
+	assert.Equal(t, "[9]", byDeadline[2].Recovered)
+	assert.Equal(t, "[10,11]", byDeadline[2].Removed)
+
+	t.Run("no changes", func(t *testing.T) {
+		ec.dlDiff = nil
+		faults, err := ExtractMinerPartitionFaults(ctx, info, ec, closed)
+		require.NoError(t, err)
+		assert.Empty(t, faults)
+	})
+}
+
+// postAPI serves the messages included by the parent tipset and their receipts
+type postAPI struct {
+	*MockAPI
+	msgs  []*types.Message
+	rcpts map[cid.Cid]*types.MessageReceipt
+}
+
+func (p *postAPI) ChainGetBlockMessages(ctx context.Context, blk cid.Cid) (*api.BlockMessages, error) {
+	bm := &api.BlockMessages{BlsMessages: p.msgs}
+	for _, m := range p.msgs {
+		bm.Cids = append(bm.Cids, m.Cid())
+	}
+	return bm, nil
+}
+
+func (p *postAPI) StateGetReceipt(ctx context.Context, msg cid.Cid, tsk types.TipSetKey) (*types.MessageReceipt, error) {
+	return p.rcpts[msg], nil
+}
+
+func TestExtractMinerPoSts(t *testing.T) {
+	ctx := context.Background()
+
+	minerAddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	otherAddr, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	postMsg := func(to address.Address, nonce uint64, method abi.MethodNum, dlIdx uint64, skipped ...uint64) *types.Message {
+		params := &sa0miner.SubmitWindowedPoStParams{
+			Deadline:   dlIdx,
+			Partitions: []sa0miner.PoStPartition{{Index: 0, Skipped: bitfield.NewFromSet(skipped)}},
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, params.MarshalCBOR(buf))
+		return &types.Message{To: to, From: otherAddr, Nonce: nonce, Method: method, Params: buf.Bytes(), Value: abi.NewTokenAmount(0)}
+	}
+
+	onTime := postMsg(minerAddr, 0, sa0builtin.MethodsMiner.SubmitWindowedPoSt, 1, 2)
+	late := postMsg(minerAddr, 1, sa0builtin.MethodsMiner.SubmitWindowedPoSt, 0)
+	failed := postMsg(minerAddr, 2, sa0builtin.MethodsMiner.SubmitWindowedPoSt, 1)
+	otherMiner := postMsg(otherAddr, 3, sa0builtin.MethodsMiner.SubmitWindowedPoSt, 1)
+	otherMethod := postMsg(minerAddr, 4, sa0builtin.MethodsMiner.DeclareFaults, 1)
+
+	node := &postAPI{
+		MockAPI: NewMockAPI(),
+		msgs:    []*types.Message{onTime, late, failed, otherMiner, otherMethod},
+		rcpts: map[cid.Cid]*types.MessageReceipt{
+			onTime.Cid():      {ExitCode: exitcode.Ok},
+			late.Cid():        {ExitCode: exitcode.Ok},
+			failed.Cid():      {ExitCode: exitcode.ErrIllegalArgument},
+			otherMiner.Cid():  {ExitCode: exitcode.Ok},
+			otherMethod.Cid(): {ExitCode: exitcode.Ok},
+		},
+	}
+
+	st := &fakeMinerState{
+		deadlines: map[uint64]*fakeDeadline{
+			0: {partitions: []*fakePartition{{all: []uint64{4}}}},
+			1: {partitions: []*fakePartition{{all: []uint64{1, 2, 3}}}},
+		},
+	}
+	// The parent tipset is executed during deadline 1
+	ec := minerExtractionContext(t, 70, 71, st)
+	info := &ActorInfo{Address: minerAddr, ParentStateRoot: testutil.RandomCid()}
+
+	sectorPosts, partitionPosts, err := ExtractMinerPoSts(ctx, info, ec, node)
+	require.NoError(t, err)
+
+	require.Len(t, partitionPosts, 2)
+	assert.EqualValues(t, 1, partitionPosts[0].DeadlineIndex)
+	assert.True(t, partitionPosts[0].OnTime)
+	assert.EqualValues(t, 2, partitionPosts[0].ProvenSectors)
+	assert.Equal(t, "[2]", partitionPosts[0].SkippedSectors)
+	assert.Equal(t, onTime.Cid().String(), partitionPosts[0].PostMessageCID)
+
+	assert.EqualValues(t, 0, partitionPosts[1].DeadlineIndex)
+	assert.False(t, partitionPosts[1].OnTime)
+	assert.Equal(t, late.Cid().String(), partitionPosts[1].PostMessageCID)
+
+	var proven []uint64
+	for _, sp := range sectorPosts {
+		proven = append(proven, sp.SectorID)
+	}
+	assert.Equal(t, []uint64{1, 3, 4}, proven)
+}
@@ -0,0 +1,189 @@
+package actorstate
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/actors/util/adt"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// Prefetcher concurrently loads the chain state needed to extract a batch of actors so that extraction is
+// not bound by the latency of many small sequential requests to the lens. Tipsets and actors are held by
+// the returned PrefetchedAPI, state objects are read through a single lens store that the PrefetchedAPI serves
+// to the extractors so they find the objects in its cache. Some lenses return a store with a fresh cache on every
+// call so the store must not be obtained again from the lens.
+type Prefetcher struct {
+	workers int // maximum number of concurrent requests made to the lens
+}
+
+func NewPrefetcher(workers int) *Prefetcher {
+	return &Prefetcher{
+		workers: workers,
+	}
+}
+
+type prefetchKey struct {
+	addr address.Address
+	tsk  types.TipSetKey
+}
+
+// Prefetch loads the tipsets, actors, actor heads and the objects directly linked from each actor head
+// for the given actors. Failures are not reported since the extractors will request anything that could
+// not be loaded and report the error in context.
+func (p *Prefetcher) Prefetch(ctx context.Context, node ActorStateAPI, infos []ActorInfo) *PrefetchedAPI {
+	ctx, span := global.Tracer("").Start(ctx, "Prefetcher.Prefetch", trace.WithAttributes(label.Int("count", len(infos))))
+	defer span.End()
+
+	pa := &PrefetchedAPI{
+		ActorStateAPI: node,
+		store:         node.Store(),
+		tipsets:       map[types.TipSetKey]*types.TipSet{},
+		actors:        map[prefetchKey]*types.Actor{},
+	}
+
+	if p.workers <= 0 || len(infos) == 0 {
+		return pa
+	}
+
+	sem := make(chan struct{}, p.workers)
+	var wg sync.WaitGroup
+	spawn := func(fn func()) {
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn()
+		}()
+	}
+
+	seenTipsets := map[types.TipSetKey]bool{}
+	seenActors := map[prefetchKey]bool{}
+	seenHeads := map[cid.Cid]bool{}
+
+	for _, info := range infos {
+		head := info.Actor.Head
+
+		for _, tsk := range []types.TipSetKey{info.TipSet, info.ParentTipSet} {
+			if tsk == types.EmptyTSK || seenTipsets[tsk] {
+				continue
+			}
+			seenTipsets[tsk] = true
+			tsk := tsk
+			spawn(func() {
+				ts, err := node.ChainGetTipSet(ctx, tsk)
+				if err != nil {
+					return
+				}
+				pa.mu.Lock()
+				pa.tipsets[tsk] = ts
+				pa.mu.Unlock()
+			})
+		}
+
+		for _, tsk := range []types.TipSetKey{info.TipSet, info.ParentTipSet} {
+			key := prefetchKey{addr: info.Address, tsk: tsk}
+			if tsk == types.EmptyTSK || seenActors[key] {
+				continue
+			}
+			seenActors[key] = true
+			spawn(func() {
+				act, err := node.StateGetActor(ctx, key.addr, key.tsk)
+				if err != nil {
+					return
+				}
+				pa.mu.Lock()
+				pa.actors[key] = act
+				pa.mu.Unlock()
+
+				if act.Head != head {
+					p.loadHead(ctx, pa.store, act.Head)
+				}
+			})
+		}
+
+		if !seenHeads[head] {
+			seenHeads[head] = true
+			spawn(func() {
+				p.loadHead(ctx, pa.store, head)
+			})
+		}
+	}
+
+	wg.Wait()
+	return pa
+}
+
+// loadHead reads an actor's head object and the objects it links to directly, which are the roots of
+// the HAMTs and AMTs that make up the actor's state.
+func (p *Prefetcher) loadHead(ctx context.Context, store adt.Store, head cid.Cid) {
+	var raw cbg.Deferred
+	if err := store.Get(ctx, head, &raw); err != nil {
+		return
+	}
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(raw.Raw), func(c cid.Cid) {
+		links = append(links, c)
+	}); err != nil {
+		return
+	}
+
+	for _, c := range links {
+		if ctx.Err() != nil {
+			return
+		}
+		var root cbg.Deferred
+		_ = store.Get(ctx, c, &root)
+	}
+}
+
+var _ ActorStateAPI = (*PrefetchedAPI)(nil)
+
+// PrefetchedAPI serves tipsets and actors loaded by a Prefetcher, falling back to the underlying api
+// for anything that was not prefetched.
+type PrefetchedAPI struct {
+	ActorStateAPI
+	store adt.Store // store the state objects were prefetched into
+
+	mu      sync.Mutex
+	tipsets map[types.TipSetKey]*types.TipSet
+	actors  map[prefetchKey]*types.Actor
+}
+
+// Store returns the store holding the prefetched state objects.
+func (pa *PrefetchedAPI) Store() adt.Store {
+	return pa.store
+}
+
+func (pa *PrefetchedAPI) ChainGetTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	pa.mu.Lock()
+	ts, ok := pa.tipsets[tsk]
+	pa.mu.Unlock()
+	if ok {
+		return ts, nil
+	}
+	return pa.ActorStateAPI.ChainGetTipSet(ctx, tsk)
+}
+
+func (pa *PrefetchedAPI) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	pa.mu.Lock()
+	act, ok := pa.actors[prefetchKey{addr: addr, tsk: tsk}]
+	pa.mu.Unlock()
+	if ok {
+		return act, nil
+	}
+	return pa.ActorStateAPI.StateGetActor(ctx, addr, tsk)
+}
@@ -0,0 +1,119 @@
+package actorstate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
+	"github.com/filecoin-project/lotus/chain/types"
+	bstore "github.com/filecoin-project/lotus/lib/blockstore"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/util/adt"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestPrefetcher(t *testing.T) {
+	ctx := context.Background()
+
+	mapi := NewMockAPI()
+
+	state, err := mapi.newEmptyPowerStateV0()
+	require.NoError(t, err)
+
+	stateCid, err := mapi.Store().Put(ctx, state)
+	require.NoError(t, err)
+
+	minerAddr, err := address.NewFromString("t00")
+	require.NoError(t, err)
+	stateTs, err := mockTipset(minerAddr, 1)
+	require.NoError(t, err)
+
+	act := &types.Actor{Code: sa0builtin.StoragePowerActorCodeID, Head: stateCid}
+	mapi.setActor(stateTs.Key(), power.Address, act)
+	mapi.putTipSet(stateTs)
+
+	info := ActorInfo{
+		Actor:           *act,
+		Address:         power.Address,
+		TipSet:          stateTs.Key(),
+		ParentStateRoot: stateTs.ParentState(),
+	}
+
+	t.Run("prefetched state is served without the underlying api", func(t *testing.T) {
+		pa := NewPrefetcher(4).Prefetch(ctx, mapi, []ActorInfo{info})
+
+		// Replace the underlying api with an empty one so any request not served by the prefetcher fails
+		pa.ActorStateAPI = NewMockAPI()
+
+		gotTs, err := pa.ChainGetTipSet(ctx, stateTs.Key())
+		require.NoError(t, err)
+		assert.Equal(t, stateTs, gotTs)
+
+		gotAct, err := pa.StateGetActor(ctx, power.Address, stateTs.Key())
+		require.NoError(t, err)
+		assert.Equal(t, act, gotAct)
+	})
+
+	t.Run("disabled prefetcher falls back to the underlying api", func(t *testing.T) {
+		pa := NewPrefetcher(0).Prefetch(ctx, mapi, []ActorInfo{info})
+
+		gotAct, err := pa.StateGetActor(ctx, power.Address, stateTs.Key())
+		require.NoError(t, err)
+		assert.Equal(t, act, gotAct)
+
+		_, err = pa.StateGetActor(ctx, minerAddr, stateTs.Key())
+		assert.Error(t, err)
+	})
+	t.Run("state is prefetched into the store served to extractors", func(t *testing.T) {
+		fapi := &freshStoreAPI{MockAPI: mapi}
+		pa := NewPrefetcher(4).Prefetch(ctx, fapi, []ActorInfo{info})
+		assert.Equal(t, 1, fapi.stores)
+
+		// Remove the head from the lens so it can only be served from the cache it was prefetched into
+		require.NoError(t, mapi.bs.DeleteBlock(stateCid))
+
+		var st cbg.Deferred
+		assert.Error(t, fapi.Store().Get(ctx, stateCid, &st))
+		assert.NoError(t, pa.Store().Get(ctx, stateCid, &st))
+	})
+}
+
+// freshStoreAPI returns a store with an empty cache on every call to Store, like the car and sql lenses
+type freshStoreAPI struct {
+	*MockAPI
+	stores int
+}
+
+func (f *freshStoreAPI) Store() adt.Store {
+	f.stores++
+	return adt.WrapStore(context.Background(), cbornode.NewCborStore(&cachingBlockstore{
+		cache:   bstore.NewTemporarySync(),
+		backing: f.MockAPI.bs,
+	}))
+}
+
+type cachingBlockstore struct {
+	cache   bstore.Blockstore
+	backing bstore.Blockstore
+}
+
+func (c *cachingBlockstore) Get(k cid.Cid) (blocks.Block, error) {
+	if b, err := c.cache.Get(k); err == nil {
+		return b, nil
+	}
+	b, err := c.backing.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return b, c.cache.Put(b)
+}
+
+func (c *cachingBlockstore) Put(b blocks.Block) error {
+	return c.backing.Put(b)
+}
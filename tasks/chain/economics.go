@@ -60,6 +60,10 @@ func (p *ChainEconomics) Run(ctx context.Context) error {
 	}
 	defer closer()
 
+	if err := lens.RequireMethods(node, "ChainGetTipSet", "StateVMCirculatingSupplyInternal"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
 	// Loop until context is done or processing encounters a fatal error
 	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
 		return p.processBatch(ctx, node)
@@ -88,11 +92,12 @@ func (p *ChainEconomics) processBatch(ctx context.Context, node lens.API) (bool,
 	}
 
 	log.Debugw("leased batch of tipsets", "count", len(batch))
-	ctx, cancel := context.WithDeadline(ctx, claimUntil)
-	defer cancel()
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.EconomicsQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
 
 	for _, item := range batch {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
@@ -111,6 +116,7 @@ func (p *ChainEconomics) processBatch(ctx context.Context, node lens.API) (bool,
 		if err := p.storage.MarkTipSetEconomicsComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
 			log.Errorw("failed to mark tipset economics complete", "error", err.Error(), "height", item.Height)
 		}
+		lease.Progress()
 	}
 
 	return false, nil
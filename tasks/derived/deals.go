@@ -0,0 +1,275 @@
+package derived
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa2builtin "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	"github.com/go-pg/pg/v10"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	marketmodel "github.com/filecoin-project/sentinel-visor/model/actors/market"
+	minermodel "github.com/filecoin-project/sentinel-visor/model/actors/miner"
+	derivedmodel "github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/storage"
+)
+
+// dealCodes are the actor codes whose processing produces market deals and the sectors they are stored in
+var dealCodes = append([]string{
+	sa0builtin.StorageMarketActorCodeID.String(),
+	sa2builtin.StorageMarketActorCodeID.String(),
+}, minerCodes...)
+
+func NewDealProcessor(d *storage.Database, refreshRate, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *DealProcessor {
+	return &DealProcessor{
+		storage:     d,
+		refreshRate: refreshRate,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// DealProcessor is a task that maintains the lifecycle of every storage deal. It leases tipsets whose market and miner
+// actors have all been processed and rebuilds each deal with a proposal, state or sector deal in those tipsets from
+// all of the deal's proposals, states and sector deals, so tipsets may be processed in any order, such as when earlier
+// heights are backfilled.
+type DealProcessor struct {
+	storage     *storage.Database
+	refreshRate time.Duration // time to wait when there are no tipsets to process
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease and apply in a single transaction
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts applying deal changes until the context is done or an error occurs.
+func (p *DealProcessor) Run(ctx context.Context) error {
+	if p.refreshRate == 0 {
+		return nil
+	}
+
+	// Keep applying batches until there are no more tipsets ready to process
+	return repeatBatches(ctx, p.refreshRate, p.processBatch)
+}
+
+// processBatch rebuilds the deals touched by the next batch of tipsets, returning true if there may be more tipsets
+// that can be processed.
+func (p *DealProcessor) processBatch(ctx context.Context) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "deals"))
+	ctx, span := global.Tracer("").Start(ctx, "DealProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets whose market and miner actors have all been processed
+	batch, err := p.storage.LeaseTipSetDeals(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight, dealCodes)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset deals: %w", err)
+	}
+	if len(batch) == 0 {
+		return false, nil
+	}
+	span.SetAttributes(label.Int("count", len(batch)))
+
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.DealQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	heights := make([]int64, 0, len(batch))
+	for _, item := range batch {
+		heights = append(heights, item.Height)
+	}
+
+	errorsDetected := ""
+	err = p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return p.rebuildDeals(ctx, tx, heights)
+	})
+	if err != nil {
+		log.Errorw("failed to rebuild deals", "error", err.Error(), "tipsets", len(batch))
+		errorsDetected = err.Error()
+	}
+
+	for _, item := range batch {
+		if err := p.storage.MarkTipSetDealsComplete(ctx, item.TipSet, item.Height, p.clock.Now(), errorsDetected); err != nil {
+			log.Errorw("failed to mark tipset deals complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return len(batch) == p.batchSize, nil
+}
+
+// rebuildDeals rebuilds every deal with a proposal, state or sector deal at one of the heights from all of its
+// proposals, states and sector deals, then expires deals that have passed their end epoch.
+func (p *DealProcessor) rebuildDeals(ctx context.Context, tx *pg.Tx, heights []int64) error {
+	var dealIDs []uint64
+	if _, err := tx.QueryContext(ctx, &dealIDs, `
+SELECT deal_id FROM market_deal_proposals WHERE height IN (?)
+UNION
+SELECT deal_id FROM market_deal_states WHERE height IN (?)
+UNION
+SELECT deal_id FROM miner_sector_deals WHERE height IN (?)
+`, pg.In(heights), pg.In(heights), pg.In(heights)); err != nil {
+		return xerrors.Errorf("query touched deals: %w", err)
+	}
+
+	var proposals marketmodel.MarketDealProposals
+	var states marketmodel.MarketDealStates
+	var sectorDeals minermodel.MinerSectorDealList
+	if len(dealIDs) > 0 {
+		if err := tx.ModelContext(ctx, &proposals).Where("deal_id IN (?)", pg.In(dealIDs)).Select(); err != nil {
+			return xerrors.Errorf("query deal proposals: %w", err)
+		}
+		if err := tx.ModelContext(ctx, &states).Where("deal_id IN (?)", pg.In(dealIDs)).Select(); err != nil {
+			return xerrors.Errorf("query deal states: %w", err)
+		}
+		if err := tx.ModelContext(ctx, &sectorDeals).Where("deal_id IN (?)", pg.In(dealIDs)).Select(); err != nil {
+			return xerrors.Errorf("query sector deals: %w", err)
+		}
+	}
+
+	changed := applyDealChanges(map[uint64]*derivedmodel.Deal{}, proposals, states, sectorDeals)
+	if err := changed.PersistWithTx(ctx, tx); err != nil {
+		return err
+	}
+
+	// Deals that pass their end epoch are not removed from the market state until later, if ever, so expiry is
+	// determined from the highest height processed, which may be above this batch when earlier heights are backfilled
+	var highest int64
+	if _, err := tx.QueryOneContext(ctx, pg.Scan(&highest), `
+SELECT GREATEST(?, (SELECT MAX(height) FROM visor_processing_tipsets WHERE deals_completed_at IS NOT null))
+`, highestHeight(heights)); err != nil {
+		return xerrors.Errorf("query highest processed height: %w", err)
+	}
+
+	expired, err := derivedmodel.ExpireDeals(ctx, tx, highest)
+	if err != nil {
+		return err
+	}
+
+	log.Debugw("rebuilt deals", "heights", len(heights), "deals", len(dealIDs), "proposals", len(proposals), "states", len(states), "sector_deals", len(sectorDeals), "changed", len(changed), "expired", expired)
+	return nil
+}
+
+func highestHeight(heights []int64) int64 {
+	var max int64
+	for _, h := range heights {
+		if h > max {
+			max = h
+		}
+	}
+	return max
+}
+
+// applyDealChanges updates the deals with new proposals, states and sector deals and returns the deals that changed.
+// Proposals are applied first since a deal is published before it can be activated. States and sector deals of
+// deals that have no known proposal, such as those published before the earliest height indexed, are ignored.
+func applyDealChanges(deals map[uint64]*derivedmodel.Deal, proposals marketmodel.MarketDealProposals, states marketmodel.MarketDealStates, sectorDeals minermodel.MinerSectorDealList) derivedmodel.DealList {
+	changed := map[uint64]*derivedmodel.Deal{}
+
+	// The earliest proposal of a deal is the one that published it
+	sort.SliceStable(proposals, func(i, j int) bool {
+		return proposals[i].Height < proposals[j].Height
+	})
+
+	for _, dp := range proposals {
+		if _, exists := deals[dp.DealID]; exists {
+			continue
+		}
+		d := &derivedmodel.Deal{
+			DealID:           dp.DealID,
+			ClientID:         dp.ClientID,
+			ProviderID:       dp.ProviderID,
+			PieceCID:         dp.PieceCID,
+			PaddedPieceSize:  dp.PaddedPieceSize,
+			IsVerified:       dp.IsVerified,
+			StartEpoch:       dp.StartEpoch,
+			EndEpoch:         dp.EndEpoch,
+			Status:           derivedmodel.DealStatusPublished,
+			PublishedHeight:  dp.Height,
+			LastUpdateHeight: dp.Height,
+		}
+		deals[dp.DealID] = d
+		changed[dp.DealID] = d
+	}
+
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Height < states[j].Height
+	})
+
+	for _, ds := range states {
+		d, ok := deals[ds.DealID]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case ds.SlashEpoch >= 0:
+			if d.Status == derivedmodel.DealStatusSlashed {
+				continue
+			}
+			d.Status = derivedmodel.DealStatusSlashed
+			d.SlashHeight = ds.SlashEpoch
+			if ds.SectorStartEpoch >= 0 {
+				d.ActivationHeight = ds.SectorStartEpoch
+			}
+		case ds.SectorStartEpoch >= 0:
+			if d.Status != derivedmodel.DealStatusPublished {
+				continue
+			}
+			d.Status = derivedmodel.DealStatusActive
+			d.ActivationHeight = ds.SectorStartEpoch
+		default:
+			continue
+		}
+
+		d.LastUpdateHeight = ds.Height
+		changed[ds.DealID] = d
+	}
+
+	sort.SliceStable(sectorDeals, func(i, j int) bool {
+		return sectorDeals[i].Height < sectorDeals[j].Height
+	})
+
+	for _, sd := range sectorDeals {
+		d, ok := deals[sd.DealID]
+		if !ok {
+			continue
+		}
+		sectorID := sd.SectorID
+		d.SectorID = &sectorID
+		if sd.Height > d.LastUpdateHeight {
+			d.LastUpdateHeight = sd.Height
+		}
+		changed[sd.DealID] = d
+	}
+
+	out := make(derivedmodel.DealList, 0, len(changed))
+	for _, d := range changed {
+		out = append(out, d)
+	}
+
+	// Keep the insert order stable
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].DealID < out[j].DealID
+	})
+
+	return out
+}
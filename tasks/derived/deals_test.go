@@ -0,0 +1,89 @@
+package derived
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	marketmodel "github.com/filecoin-project/sentinel-visor/model/actors/market"
+	minermodel "github.com/filecoin-project/sentinel-visor/model/actors/miner"
+	derivedmodel "github.com/filecoin-project/sentinel-visor/model/derived"
+)
+
+func TestApplyDealChanges(t *testing.T) {
+	proposal := func(height int64, id uint64) *marketmodel.MarketDealProposal {
+		return &marketmodel.MarketDealProposal{
+			Height:     height,
+			DealID:     id,
+			ClientID:   "f01001",
+			ProviderID: "f01000",
+			PieceCID:   "bafk",
+			StartEpoch: 100,
+			EndEpoch:   1000,
+		}
+	}
+
+	t.Run("published and activated", func(t *testing.T) {
+		deals := map[uint64]*derivedmodel.Deal{}
+		changed := applyDealChanges(deals,
+			marketmodel.MarketDealProposals{proposal(10, 1), proposal(11, 2)},
+			marketmodel.MarketDealStates{
+				{Height: 20, DealID: 1, SectorStartEpoch: 20, LastUpdateEpoch: -1, SlashEpoch: -1},
+			},
+			minermodel.MinerSectorDealList{
+				{Height: 20, MinerID: "f01000", SectorID: 0, DealID: 1},
+			},
+		)
+
+		require.Len(t, changed, 2)
+		assert.EqualValues(t, 1, changed[0].DealID)
+		assert.Equal(t, derivedmodel.DealStatusActive, changed[0].Status)
+		assert.EqualValues(t, 10, changed[0].PublishedHeight)
+		assert.EqualValues(t, 20, changed[0].ActivationHeight)
+		require.NotNil(t, changed[0].SectorID)
+		assert.EqualValues(t, 0, *changed[0].SectorID)
+		assert.EqualValues(t, 20, changed[0].LastUpdateHeight)
+
+		assert.EqualValues(t, 2, changed[1].DealID)
+		assert.Equal(t, derivedmodel.DealStatusPublished, changed[1].Status)
+		assert.Nil(t, changed[1].SectorID)
+	})
+
+	t.Run("slashed existing deal", func(t *testing.T) {
+		deals := map[uint64]*derivedmodel.Deal{
+			1: {DealID: 1, Status: derivedmodel.DealStatusActive, PublishedHeight: 10, ActivationHeight: 20, LastUpdateHeight: 20},
+		}
+		changed := applyDealChanges(deals, nil,
+			marketmodel.MarketDealStates{
+				{Height: 50, DealID: 1, SectorStartEpoch: 20, LastUpdateEpoch: 40, SlashEpoch: 50},
+				{Height: 30, DealID: 1, SectorStartEpoch: 20, LastUpdateEpoch: 30, SlashEpoch: -1},
+			},
+			nil,
+		)
+
+		require.Len(t, changed, 1)
+		assert.Equal(t, derivedmodel.DealStatusSlashed, changed[0].Status)
+		assert.EqualValues(t, 50, changed[0].SlashHeight)
+		assert.EqualValues(t, 20, changed[0].ActivationHeight)
+		assert.EqualValues(t, 50, changed[0].LastUpdateHeight)
+	})
+
+	t.Run("ignores unknown deals and republished proposals", func(t *testing.T) {
+		deals := map[uint64]*derivedmodel.Deal{
+			1: {DealID: 1, Status: derivedmodel.DealStatusActive, PublishedHeight: 10, LastUpdateHeight: 20},
+		}
+		changed := applyDealChanges(deals,
+			marketmodel.MarketDealProposals{proposal(30, 1)},
+			marketmodel.MarketDealStates{
+				{Height: 30, DealID: 7, SectorStartEpoch: 30, SlashEpoch: -1},
+			},
+			minermodel.MinerSectorDealList{
+				{Height: 30, MinerID: "f01000", SectorID: 3, DealID: 8},
+			},
+		)
+
+		assert.Len(t, changed, 0)
+		assert.EqualValues(t, 10, deals[1].PublishedHeight)
+	})
+}
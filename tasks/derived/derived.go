@@ -0,0 +1,33 @@
+package derived
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+var log = logging.Logger("derived")
+
+// repeatBatches calls processBatch until it reports there is no more work, then waits for refreshRate before
+// trying again. It returns when the context is done or processBatch fails.
+func repeatBatches(ctx context.Context, refreshRate time.Duration, processBatch func(context.Context) (bool, error)) error {
+	return wait.RepeatUntil(ctx, refreshRate, func(ctx context.Context) (bool, error) {
+		for {
+			more, err := processBatch(ctx)
+			if err != nil {
+				return true, err
+			}
+			if !more {
+				return false, nil
+			}
+			select {
+			case <-ctx.Done():
+				return true, nil
+			default:
+			}
+		}
+	})
+}
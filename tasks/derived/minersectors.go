@@ -0,0 +1,251 @@
+package derived
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa2builtin "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	"github.com/go-pg/pg/v10"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	minermodel "github.com/filecoin-project/sentinel-visor/model/actors/miner"
+	derivedmodel "github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/storage"
+)
+
+// minerCodes are the actor codes whose processing produces miner sector events
+var minerCodes = []string{
+	sa0builtin.StorageMinerActorCodeID.String(),
+	sa2builtin.StorageMinerActorCodeID.String(),
+}
+
+func NewMinerSectorProcessor(d *storage.Database, refreshRate, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *MinerSectorProcessor {
+	return &MinerSectorProcessor{
+		storage:     d,
+		refreshRate: refreshRate,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// MinerSectorProcessor is a task that maintains the current state of every miner sector. It leases tipsets whose miner
+// actors have all been processed and rebuilds each sector with events in those tipsets from all of the sector's
+// events, so tipsets may be processed in any order, such as when earlier heights are backfilled.
+type MinerSectorProcessor struct {
+	storage     *storage.Database
+	refreshRate time.Duration // time to wait when there are no tipsets to process
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease and summarize in a single transaction
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts summarizing sectors until the context is done or an error occurs.
+func (p *MinerSectorProcessor) Run(ctx context.Context) error {
+	if p.refreshRate == 0 {
+		return nil
+	}
+
+	// Keep summarizing batches until there are no more tipsets ready to process
+	return repeatBatches(ctx, p.refreshRate, p.processBatch)
+}
+
+// sectorEvent is a miner sector event along with the sector info and deals recorded at the same height.
+type sectorEvent struct {
+	Height          int64
+	MinerID         string
+	SectorID        uint64
+	Event           string
+	ActivationEpoch int64
+	ExpirationEpoch int64
+	DealIDs         string
+}
+
+// processBatch summarizes the sectors touched by the next batch of tipsets, returning true if there may be more
+// tipsets that can be processed.
+func (p *MinerSectorProcessor) processBatch(ctx context.Context) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "minersectors"))
+	ctx, span := global.Tracer("").Start(ctx, "MinerSectorProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets whose miner actors have all been processed
+	batch, err := p.storage.LeaseTipSetMinerSectors(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight, minerCodes)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset miner sectors: %w", err)
+	}
+	if len(batch) == 0 {
+		return false, nil
+	}
+	span.SetAttributes(label.Int("count", len(batch)))
+
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.MinerSectorQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	heights := make([]int64, 0, len(batch))
+	for _, item := range batch {
+		heights = append(heights, item.Height)
+	}
+
+	errorsDetected := ""
+	err = p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		sectors, err := p.summarizeSectors(ctx, tx, heights)
+		if err != nil {
+			return xerrors.Errorf("summarize sectors: %w", err)
+		}
+		return sectors.PersistWithTx(ctx, tx)
+	})
+	if err != nil {
+		log.Errorw("failed to summarize sectors", "error", err.Error(), "tipsets", len(batch))
+		errorsDetected = err.Error()
+	}
+
+	for _, item := range batch {
+		if err := p.storage.MarkTipSetMinerSectorsComplete(ctx, item.TipSet, item.Height, p.clock.Now(), errorsDetected); err != nil {
+			log.Errorw("failed to mark tipset miner sectors complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return len(batch) == p.batchSize, nil
+}
+
+// summarizeSectors rebuilds the state of every sector with an event at one of the heights from all of its events.
+func (p *MinerSectorProcessor) summarizeSectors(ctx context.Context, tx *pg.Tx, heights []int64) (derivedmodel.MinerSectorList, error) {
+	var events []*sectorEvent
+	if _, err := tx.QueryContext(ctx, &events, `
+WITH touched AS (
+	SELECT DISTINCT miner_id, sector_id FROM miner_sector_events WHERE height IN (?)
+)
+SELECT e.height, e.miner_id, e.sector_id, e.event, si.activation_epoch, si.expiration_epoch, d.deal_ids
+FROM miner_sector_events e
+JOIN touched t ON t.miner_id = e.miner_id AND t.sector_id = e.sector_id
+LEFT JOIN miner_sector_infos si ON si.height = e.height AND si.miner_id = e.miner_id AND si.sector_id = e.sector_id AND si.state_root = e.state_root
+LEFT JOIN LATERAL (
+	SELECT json_agg(deal_id ORDER BY deal_id) AS deal_ids
+	FROM miner_sector_deals
+	WHERE height = e.height AND miner_id = e.miner_id AND sector_id = e.sector_id
+) d ON true
+ORDER BY e.height
+`, pg.In(heights)); err != nil {
+		return nil, xerrors.Errorf("query sector events: %w", err)
+	}
+
+	sectors := map[sectorKey]*derivedmodel.MinerSector{}
+	sortSectorEvents(events)
+	for _, ev := range events {
+		applySectorEvent(sectors, ev)
+	}
+
+	out := make(derivedmodel.MinerSectorList, 0, len(sectors))
+	for _, s := range sectors {
+		out = append(out, s)
+	}
+
+	// Keep the insert order stable
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].MinerID != out[j].MinerID {
+			return out[i].MinerID < out[j].MinerID
+		}
+		return out[i].SectorID < out[j].SectorID
+	})
+
+	log.Debugw("summarized sectors", "heights", len(heights), "events", len(events), "sectors", len(out))
+	return out, nil
+}
+
+type sectorKey struct {
+	miner  string
+	sector uint64
+}
+
+// sectorEventOrder is the order in which events recorded at the same height are applied, following the order
+// they occur within an epoch.
+var sectorEventOrder = map[string]int{
+	minermodel.PreCommitAdded:      0,
+	minermodel.PreCommitExpired:    1,
+	minermodel.SectorAdded:         2,
+	minermodel.CommitCapacityAdded: 3,
+	minermodel.SectorExtended:      4,
+	minermodel.SectorFaulted:       5,
+	minermodel.SectorRecovering:    6,
+	minermodel.SectorRecovered:     7,
+	minermodel.SectorExpired:       8,
+	minermodel.SectorTerminated:    9,
+}
+
+func sortSectorEvents(events []*sectorEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Height != events[j].Height {
+			return events[i].Height < events[j].Height
+		}
+		return sectorEventOrder[events[i].Event] < sectorEventOrder[events[j].Event]
+	})
+}
+
+// applySectorEvent updates the state of the sector the event refers to, creating it if it is not yet known.
+func applySectorEvent(sectors map[sectorKey]*derivedmodel.MinerSector, ev *sectorEvent) {
+	key := sectorKey{miner: ev.MinerID, sector: ev.SectorID}
+	s, ok := sectors[key]
+	if !ok {
+		s = &derivedmodel.MinerSector{
+			MinerID:  ev.MinerID,
+			SectorID: ev.SectorID,
+		}
+		sectors[key] = s
+	}
+
+	switch ev.Event {
+	case minermodel.PreCommitAdded:
+		s.Status = derivedmodel.SectorStatusPreCommitted
+		s.PrecommitHeight = ev.Height
+	case minermodel.PreCommitExpired:
+		s.Status = derivedmodel.SectorStatusPreCommitExpired
+	case minermodel.SectorAdded, minermodel.CommitCapacityAdded:
+		s.Status = derivedmodel.SectorStatusActive
+		s.ActivationHeight = ev.ActivationEpoch
+		s.ExpirationHeight = ev.ExpirationEpoch
+		if ev.DealIDs != "" {
+			s.DealIDs = ev.DealIDs
+		}
+	case minermodel.SectorExtended:
+		s.ExpirationHeight = ev.ExpirationEpoch
+	case minermodel.SectorFaulted:
+		s.Status = derivedmodel.SectorStatusFaulted
+		s.FaultCount++
+	case minermodel.SectorRecovering:
+		s.Status = derivedmodel.SectorStatusRecovering
+	case minermodel.SectorRecovered:
+		s.Status = derivedmodel.SectorStatusActive
+	case minermodel.SectorExpired:
+		s.Status = derivedmodel.SectorStatusExpired
+	case minermodel.SectorTerminated:
+		s.Status = derivedmodel.SectorStatusTerminated
+		s.TerminationHeight = ev.Height
+	default:
+		log.Warnw("unknown sector event", "event", ev.Event, "miner", ev.MinerID, "sector", ev.SectorID)
+		return
+	}
+
+	s.LastEvent = ev.Event
+	s.LastEventHeight = ev.Height
+}
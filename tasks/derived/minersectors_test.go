@@ -0,0 +1,179 @@
+package derived
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	minermodel "github.com/filecoin-project/sentinel-visor/model/actors/miner"
+	derivedmodel "github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestApplySectorEvent(t *testing.T) {
+	t.Run("lifecycle", func(t *testing.T) {
+		sectors := map[sectorKey]*derivedmodel.MinerSector{}
+		events := []*sectorEvent{
+			{Height: 30, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorFaulted},
+			{Height: 10, MinerID: "f01000", SectorID: 1, Event: minermodel.PreCommitAdded},
+			{Height: 20, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorAdded, ActivationEpoch: 20, ExpirationEpoch: 1000, DealIDs: "[4, 7]"},
+			{Height: 40, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorRecovered},
+			{Height: 40, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorRecovering},
+			{Height: 50, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorExtended, ExpirationEpoch: 2000},
+			{Height: 60, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorFaulted},
+		}
+
+		sortSectorEvents(events)
+		for _, ev := range events {
+			applySectorEvent(sectors, ev)
+		}
+
+		require.Len(t, sectors, 1)
+		s := sectors[sectorKey{miner: "f01000", sector: 1}]
+		require.NotNil(t, s)
+		assert.Equal(t, derivedmodel.SectorStatusFaulted, s.Status)
+		assert.EqualValues(t, 10, s.PrecommitHeight)
+		assert.EqualValues(t, 20, s.ActivationHeight)
+		assert.EqualValues(t, 2000, s.ExpirationHeight)
+		assert.EqualValues(t, 0, s.TerminationHeight)
+		assert.EqualValues(t, 2, s.FaultCount)
+		assert.Equal(t, "[4, 7]", s.DealIDs)
+		assert.Equal(t, minermodel.SectorFaulted, s.LastEvent)
+		assert.EqualValues(t, 60, s.LastEventHeight)
+	})
+
+	t.Run("updates existing sector", func(t *testing.T) {
+		sectors := map[sectorKey]*derivedmodel.MinerSector{
+			{miner: "f01000", sector: 1}: {
+				MinerID:          "f01000",
+				SectorID:         1,
+				Status:           derivedmodel.SectorStatusActive,
+				ActivationHeight: 20,
+				ExpirationHeight: 1000,
+				FaultCount:       1,
+				LastEvent:        minermodel.SectorRecovered,
+				LastEventHeight:  40,
+			},
+		}
+
+		applySectorEvent(sectors, &sectorEvent{Height: 70, MinerID: "f01000", SectorID: 1, Event: minermodel.SectorTerminated})
+
+		s := sectors[sectorKey{miner: "f01000", sector: 1}]
+		assert.Equal(t, derivedmodel.SectorStatusTerminated, s.Status)
+		assert.EqualValues(t, 70, s.TerminationHeight)
+		assert.EqualValues(t, 1000, s.ExpirationHeight)
+		assert.EqualValues(t, 1, s.FaultCount)
+		assert.EqualValues(t, 70, s.LastEventHeight)
+	})
+
+	t.Run("precommit expired", func(t *testing.T) {
+		sectors := map[sectorKey]*derivedmodel.MinerSector{}
+		applySectorEvent(sectors, &sectorEvent{Height: 10, MinerID: "f01000", SectorID: 2, Event: minermodel.PreCommitAdded})
+		applySectorEvent(sectors, &sectorEvent{Height: 15, MinerID: "f01001", SectorID: 2, Event: minermodel.PreCommitAdded})
+		applySectorEvent(sectors, &sectorEvent{Height: 90, MinerID: "f01000", SectorID: 2, Event: minermodel.PreCommitExpired})
+
+		require.Len(t, sectors, 2)
+		assert.Equal(t, derivedmodel.SectorStatusPreCommitExpired, sectors[sectorKey{miner: "f01000", sector: 2}].Status)
+		assert.Equal(t, derivedmodel.SectorStatusPreCommitted, sectors[sectorKey{miner: "f01001", sector: 2}].Status)
+	})
+}
+
+func TestMinerSectorProcessorBackfill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short testing requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, cleanup, err := testutil.WaitForExclusiveDatabase(ctx, t)
+	require.NoError(t, err)
+	defer cleanup()
+
+	for _, table := range []string{"visor_processing_tipsets", "visor_processing_actors", "miner_sector_events", "miner_sector_infos", "miner_sector_deals", "derived_miner_sectors"} {
+		_, err := db.Exec(`TRUNCATE TABLE ?`, pg.Ident(table))
+		require.NoError(t, err, "truncating %s", table)
+	}
+
+	clock := testutil.NewMockClock()
+	p := &MinerSectorProcessor{
+		storage:     &storage.Database{DB: db, Clock: clock},
+		leaseLength: time.Minute,
+		batchSize:   10,
+		minHeight:   0,
+		maxHeight:   1000,
+		clock:       clock,
+	}
+
+	// index records a tipset whose state changes have been extracted along with its miner actor and sector events
+	index := func(height int64, actorCompleted bool, events ...string) {
+		ts := visor.ProcessingTipSet{
+			TipSet:                 testutil.RandomCid().String(),
+			Height:                 height,
+			AddedAt:                testutil.KnownTime,
+			StatechangeCompletedAt: testutil.KnownTime,
+		}
+		actor := visor.ProcessingActor{
+			Head:    testutil.RandomCid().String(),
+			Code:    minerCodes[0],
+			Height:  height,
+			AddedAt: testutil.KnownTime,
+		}
+		if actorCompleted {
+			actor.CompletedAt = testutil.KnownTime
+		}
+		_, err := db.Model(&ts).Insert()
+		require.NoError(t, err)
+		_, err = db.Model(&actor).Insert()
+		require.NoError(t, err)
+		for _, ev := range events {
+			_, err := db.Model(&minermodel.MinerSectorEvent{Height: height, MinerID: "f01000", SectorID: 1, StateRoot: "root", Event: ev}).Insert()
+			require.NoError(t, err)
+		}
+	}
+
+	sector := func() *derivedmodel.MinerSector {
+		s := &derivedmodel.MinerSector{MinerID: "f01000", SectorID: 1}
+		require.NoError(t, db.Model(s).WherePK().Select())
+		return s
+	}
+
+	// The head is indexed first and a fault is summarized before the sector's precommit is known
+	index(30, true, minermodel.SectorFaulted)
+	_, err = p.processBatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, derivedmodel.SectorStatusFaulted, sector().Status)
+	assert.EqualValues(t, 0, sector().PrecommitHeight)
+
+	// Heights whose miner actors are still being processed are not summarized
+	index(10, false, minermodel.PreCommitAdded)
+	_, err = p.processBatch(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, sector().PrecommitHeight)
+
+	_, err = db.Exec(`UPDATE visor_processing_actors SET completed_at = ? WHERE height = 10`, testutil.KnownTime)
+	require.NoError(t, err)
+
+	// Backfilled heights below those already summarized are still applied, in height order
+	index(20, true, minermodel.SectorAdded)
+	_, err = p.processBatch(ctx)
+	require.NoError(t, err)
+
+	s := sector()
+	assert.Equal(t, derivedmodel.SectorStatusFaulted, s.Status)
+	assert.EqualValues(t, 10, s.PrecommitHeight)
+	assert.EqualValues(t, 1, s.FaultCount)
+	assert.Equal(t, minermodel.SectorFaulted, s.LastEvent)
+	assert.EqualValues(t, 30, s.LastEventHeight)
+
+	var incomplete int
+	_, err = db.QueryOne(pg.Scan(&incomplete), `SELECT COUNT(*) FROM visor_processing_tipsets WHERE miner_sectors_completed_at IS null`)
+	require.NoError(t, err)
+	assert.Equal(t, 0, incomplete)
+}
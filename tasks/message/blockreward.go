@@ -0,0 +1,274 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/go-pg/pg/v10"
+	"github.com/ipfs/go-cid"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	"github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+func NewBlockRewardProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *BlockRewardProcessor {
+	return &BlockRewardProcessor{
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// BlockRewardProcessor is a task that attributes the rewards earned for each block in a tipset to the block's
+// miner: the block reward for the block's winning tickets and the tips, less penalties, of the messages it included.
+type BlockRewardProcessor struct {
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts processing batches of tipsets until the context is done or
+// an error occurs.
+func (p *BlockRewardProcessor) Run(ctx context.Context) error {
+	node, closer, err := p.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "ChainHead", "ChainGetTipSet", "ChainGetTipSetByHeight", "ChainGetBlockMessages", "ChainGetParentMessages", "ChainGetParentReceipts", "StateGetActor", "ChainReadObj"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	// Loop until context is done or processing encounters a fatal error
+	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
+		return p.processBatch(ctx, node)
+	})
+}
+
+func (p *BlockRewardProcessor) processBatch(ctx context.Context, node lens.API) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "blockrewards"))
+	ctx, span := global.Tracer("").Start(ctx, "BlockRewardProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets to work on
+	batch, err := p.storage.LeaseTipSetBlockRewards(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset block rewards: %w", err)
+	}
+
+	// If we have no tipsets to work on then wait before trying again
+	if len(batch) == 0 {
+		sleepInterval := wait.Jitter(idleSleepInterval, 2)
+		log.Debugf("no tipsets to process, waiting for %s", sleepInterval)
+		time.Sleep(sleepInterval)
+		return false, nil
+	}
+
+	log.Debugw("leased batch of tipsets", "count", len(batch))
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.BlockRewardQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	for _, item := range batch {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
+
+		done, err := p.processItem(ctx, node, item)
+		if err != nil {
+			// Any errors are likely to be problems using the lens, mark this tipset as failed and exit this batch
+			log.Errorw("failed to process tipset", "error", err.Error(), "height", item.Height)
+			if err := p.storage.MarkTipSetBlockRewardsComplete(ctx, item.TipSet, item.Height, p.clock.Now(), err.Error()); err != nil {
+				log.Errorw("failed to mark tipset block rewards complete", "error", err.Error(), "height", item.Height)
+			}
+			return false, xerrors.Errorf("process item: %w", err)
+		}
+
+		if !done {
+			// Release the tipset from this lease so it is retried once the chain is likely to have advanced
+			if err := p.storage.DeferWork(ctx, storage.BlockRewardQueue, p.clock.Now().Add(childWaitInterval), item.TipSet, item.Height); err != nil {
+				log.Errorw("failed to defer tipset block rewards", "error", err.Error(), "height", item.Height)
+			}
+			lease.Progress()
+			continue
+		}
+
+		if err := p.storage.MarkTipSetBlockRewardsComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
+			log.Errorw("failed to mark tipset block rewards complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	return false, nil
+}
+
+// processItem attributes the rewards of the blocks in a tipset. It returns false if the tipset cannot be
+// processed yet because its messages have not been executed.
+func (p *BlockRewardProcessor) processItem(ctx context.Context, node lens.API, item *visor.ProcessingTipSet) (bool, error) {
+	ctx, span := global.Tracer("").Start(ctx, "BlockRewardProcessor.processItem")
+	defer span.End()
+	span.SetAttributes(label.Any("height", item.Height), label.Any("tipset", item.TipSet))
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	tsk, err := item.TipSetKey()
+	if err != nil {
+		return false, xerrors.Errorf("get tipsetkey: %w", err)
+	}
+
+	ts, err := node.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return false, xerrors.Errorf("get tipset: %w", err)
+	}
+
+	child, onChain, err := findChild(ctx, node, ts)
+	if err != nil {
+		return false, xerrors.Errorf("find child tipset: %w", err)
+	}
+	if !onChain {
+		// Blocks in tipsets that are not on the canonical chain are never rewarded
+		log.Debugw("skipping tipset that is not on the canonical chain", "height", item.Height)
+		return true, nil
+	}
+	if child == nil {
+		log.Debugw("delaying reward attribution of tipset with no child", "height", item.Height)
+		return false, nil
+	}
+
+	// The reward for the epoch is read from the state the tipset's messages are applied to
+	rewardActor, err := node.StateGetActor(ctx, reward.Address, ts.Key())
+	if err != nil {
+		return false, xerrors.Errorf("get reward actor: %w", err)
+	}
+
+	rstate, err := reward.Load(node.Store(), rewardActor)
+	if err != nil {
+		return false, xerrors.Errorf("load reward state: %w", err)
+	}
+
+	epochReward, err := rstate.ThisEpochReward()
+	if err != nil {
+		return false, xerrors.Errorf("get epoch reward: %w", err)
+	}
+
+	rcpts, err := fetchChildReceipts(ctx, node, child)
+	if err != nil {
+		return false, xerrors.Errorf("fetch receipts: %w", err)
+	}
+
+	blockMsgs := make([][]*types.Message, 0, len(ts.Blocks()))
+	for _, bh := range ts.Blocks() {
+		msgs, err := node.ChainGetBlockMessages(ctx, bh.Cid())
+		if err != nil {
+			return false, xerrors.Errorf("get block messages: %w", err)
+		}
+
+		// Messages in a block are executed bls messages first
+		vmm := make([]*types.Message, 0, len(msgs.Cids))
+		for _, m := range msgs.BlsMessages {
+			vmm = append(vmm, m)
+		}
+		for _, m := range msgs.SecpkMessages {
+			vmm = append(vmm, &m.Message)
+		}
+		blockMsgs = append(blockMsgs, vmm)
+	}
+
+	rewards := attributeBlockRewards(ts.Blocks(), blockMsgs, rcpts, epochReward, node.ComputeGasOutputs)
+
+	log.Debugw("persisting block rewards", "height", int64(ts.Height()), "count", len(rewards))
+
+	if err := p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return rewards.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return false, xerrors.Errorf("persist: %w", err)
+	}
+
+	return true, nil
+}
+
+type gasOutputsFunc func(gasUsed, gasLimit int64, baseFee, feeCap, gasPremium abi.TokenAmount) vm.GasOutputs
+
+// attributeBlockRewards calculates the reward earned by the miner of each block in a tipset. blockMsgs holds the
+// messages of each block in execution order. The gas rewards of a message included by several blocks go to the
+// first block to include it since that is where it is executed. Messages without a receipt were not executed and
+// earn nothing.
+func attributeBlockRewards(blocks []*types.BlockHeader, blockMsgs [][]*types.Message, rcpts map[cid.Cid]*types.MessageReceipt, epochReward abi.TokenAmount, computeGasOutputs gasOutputsFunc) derived.BlockRewardList {
+	out := make(derived.BlockRewardList, 0, len(blocks))
+	msgsSeen := map[cid.Cid]struct{}{}
+
+	for i, bh := range blocks {
+		var winCount int64
+		if bh.ElectionProof != nil {
+			winCount = bh.ElectionProof.WinCount
+		}
+
+		// Each winning ticket earns an equal share of the reward for the epoch
+		blockReward := big.Div(big.Mul(epochReward, big.NewInt(winCount)), big.NewInt(sa0builtin.ExpectedLeadersPerEpoch))
+
+		tips := big.Zero()
+		penalties := big.Zero()
+		var count int64
+
+		for _, msg := range blockMsgs[i] {
+			if _, seen := msgsSeen[msg.Cid()]; seen {
+				continue
+			}
+			msgsSeen[msg.Cid()] = struct{}{}
+
+			rcpt, ok := rcpts[msg.Cid()]
+			if !ok {
+				continue
+			}
+
+			outputs := computeGasOutputs(rcpt.GasUsed, msg.GasLimit, bh.ParentBaseFee, msg.GasFeeCap, msg.GasPremium)
+			tips = big.Add(tips, outputs.MinerTip)
+			penalties = big.Add(penalties, outputs.MinerPenalty)
+			count++
+		}
+
+		out = append(out, &derived.BlockReward{
+			Height:         int64(bh.Height),
+			Cid:            bh.Cid().String(),
+			Miner:          bh.Miner.String(),
+			StateRoot:      bh.ParentStateRoot.String(),
+			WinCount:       winCount,
+			MessageCount:   count,
+			BlockReward:    blockReward.String(),
+			MinerTips:      tips.String(),
+			MinerPenalties: penalties.String(),
+		})
+	}
+
+	return out
+}
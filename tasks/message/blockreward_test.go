@@ -0,0 +1,116 @@
+package message
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestAttributeBlockRewards(t *testing.T) {
+	addr := func(id uint64) address.Address {
+		a, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+		return a
+	}
+
+	block := func(miner uint64, winCount int64) *types.BlockHeader {
+		return &types.BlockHeader{
+			Miner:                 addr(miner),
+			Height:                10,
+			ElectionProof:         &types.ElectionProof{WinCount: winCount},
+			ParentStateRoot:       testutil.RandomCid(),
+			ParentBaseFee:         abi.NewTokenAmount(100),
+			Messages:              testutil.RandomCid(),
+			ParentMessageReceipts: testutil.RandomCid(),
+			BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+			BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+		}
+	}
+
+	msg := func(nonce uint64) *types.Message {
+		return &types.Message{
+			From:       addr(100),
+			To:         addr(200),
+			Nonce:      nonce,
+			Value:      abi.NewTokenAmount(0),
+			GasLimit:   1000,
+			GasFeeCap:  abi.NewTokenAmount(3),
+			GasPremium: abi.NewTokenAmount(2),
+		}
+	}
+
+	// Tip is the gas used, penalty is the gas limit
+	computeGasOutputs := func(gasUsed, gasLimit int64, baseFee, feeCap, gasPremium abi.TokenAmount) vm.GasOutputs {
+		out := vm.ZeroGasOutputs()
+		out.MinerTip = abi.NewTokenAmount(gasUsed)
+		out.MinerPenalty = abi.NewTokenAmount(gasLimit)
+		return out
+	}
+
+	blocks := []*types.BlockHeader{block(1000, 2), block(1001, 1)}
+	m0, m1, m2 := msg(0), msg(1), msg(2)
+
+	rcpts := map[cid.Cid]*types.MessageReceipt{
+		m0.Cid(): {GasUsed: 10},
+		m1.Cid(): {GasUsed: 20},
+		// m2 has no receipt so was not executed
+	}
+
+	// m1 is included by both blocks but is only executed in the first
+	blockMsgs := [][]*types.Message{{m0, m1}, {m1, m2}}
+
+	rewards := attributeBlockRewards(blocks, blockMsgs, rcpts, abi.NewTokenAmount(5000), computeGasOutputs)
+	require.Len(t, rewards, 2)
+
+	assert.Equal(t, blocks[0].Cid().String(), rewards[0].Cid)
+	assert.Equal(t, addr(1000).String(), rewards[0].Miner)
+	assert.EqualValues(t, 2, rewards[0].WinCount)
+	assert.Equal(t, "2000", rewards[0].BlockReward)
+	assert.Equal(t, "30", rewards[0].MinerTips)
+	assert.Equal(t, "2000", rewards[0].MinerPenalties)
+	assert.EqualValues(t, 2, rewards[0].MessageCount)
+
+	assert.Equal(t, addr(1001).String(), rewards[1].Miner)
+	assert.Equal(t, "1000", rewards[1].BlockReward)
+	assert.Equal(t, "0", rewards[1].MinerTips)
+	assert.Equal(t, "0", rewards[1].MinerPenalties)
+	assert.EqualValues(t, 0, rewards[1].MessageCount)
+}
+
+func TestAttributeBlockRewardsSecpMessages(t *testing.T) {
+	node := newMockAPI()
+
+	bls := testMessage(100, 200, 0, 0)
+	secp := testSecpMessage(101, 200, 0, 0)
+
+	ts := node.tipset(t, 10, node.stateRoot(t, nil), bls, secp)
+	node.executed([]types.ChainMsg{bls, secp}, []*types.MessageReceipt{{GasUsed: 10}, {GasUsed: 20}})
+	child := node.tipset(t, 11, node.stateRoot(t, nil))
+
+	rcpts, err := fetchChildReceipts(context.Background(), node, child)
+	require.NoError(t, err)
+
+	computeGasOutputs := func(gasUsed, gasLimit int64, baseFee, feeCap, gasPremium abi.TokenAmount) vm.GasOutputs {
+		out := vm.ZeroGasOutputs()
+		out.MinerTip = abi.NewTokenAmount(gasUsed)
+		return out
+	}
+
+	// Blocks hold the unsigned form of secp messages
+	blockMsgs := [][]*types.Message{{bls, &secp.Message}}
+
+	rewards := attributeBlockRewards(ts.Blocks(), blockMsgs, rcpts, abi.NewTokenAmount(5000), computeGasOutputs)
+	require.Len(t, rewards, 1)
+	assert.EqualValues(t, 2, rewards[0].MessageCount)
+	assert.Equal(t, "30", rewards[0].MinerTips)
+}
@@ -0,0 +1,303 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/go-pg/pg/v10"
+	"github.com/ipfs/go-cid"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/actors"
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	"github.com/filecoin-project/sentinel-visor/model/derived"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+func NewGasAggregateProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *GasAggregateProcessor {
+	return &GasAggregateProcessor{
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// GasAggregateProcessor is a task that aggregates the gas used and fees paid by the messages in each tipset,
+// grouped by the type of the destination actor and the method invoked.
+type GasAggregateProcessor struct {
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts processing batches of tipsets until the context is done or
+// an error occurs.
+func (p *GasAggregateProcessor) Run(ctx context.Context) error {
+	node, closer, err := p.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "ChainHead", "ChainGetTipSet", "ChainGetTipSetByHeight", "ChainGetBlockMessages", "ChainGetParentMessages", "ChainGetParentReceipts", "ChainReadObj"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	// Loop until context is done or processing encounters a fatal error
+	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
+		return p.processBatch(ctx, node)
+	})
+}
+
+func (p *GasAggregateProcessor) processBatch(ctx context.Context, node lens.API) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "gasaggregates"))
+	ctx, span := global.Tracer("").Start(ctx, "GasAggregateProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets to work on
+	batch, err := p.storage.LeaseTipSetGasAggregates(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset gas aggregates: %w", err)
+	}
+
+	// If we have no tipsets to work on then wait before trying again
+	if len(batch) == 0 {
+		sleepInterval := wait.Jitter(idleSleepInterval, 2)
+		log.Debugf("no tipsets to process, waiting for %s", sleepInterval)
+		time.Sleep(sleepInterval)
+		return false, nil
+	}
+
+	log.Debugw("leased batch of tipsets", "count", len(batch))
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.GasAggregateQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	for _, item := range batch {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
+
+		done, err := p.processItem(ctx, node, item)
+		if err != nil {
+			// Any errors are likely to be problems using the lens, mark this tipset as failed and exit this batch
+			log.Errorw("failed to process tipset", "error", err.Error(), "height", item.Height)
+			if err := p.storage.MarkTipSetGasAggregatesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), err.Error()); err != nil {
+				log.Errorw("failed to mark tipset gas aggregates complete", "error", err.Error(), "height", item.Height)
+			}
+			return false, xerrors.Errorf("process item: %w", err)
+		}
+
+		if !done {
+			// Release the tipset from this lease so it is retried once the chain is likely to have advanced
+			if err := p.storage.DeferWork(ctx, storage.GasAggregateQueue, p.clock.Now().Add(childWaitInterval), item.TipSet, item.Height); err != nil {
+				log.Errorw("failed to defer tipset gas aggregates", "error", err.Error(), "height", item.Height)
+			}
+			lease.Progress()
+			continue
+		}
+
+		if err := p.storage.MarkTipSetGasAggregatesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
+			log.Errorw("failed to mark tipset gas aggregates complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	return false, nil
+}
+
+// processItem aggregates the gas used by the messages in a tipset. It returns false if the tipset cannot be
+// processed yet because its messages have not been executed.
+func (p *GasAggregateProcessor) processItem(ctx context.Context, node lens.API, item *visor.ProcessingTipSet) (bool, error) {
+	ctx, span := global.Tracer("").Start(ctx, "GasAggregateProcessor.processItem")
+	defer span.End()
+	span.SetAttributes(label.Any("height", item.Height), label.Any("tipset", item.TipSet))
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	tsk, err := item.TipSetKey()
+	if err != nil {
+		return false, xerrors.Errorf("get tipsetkey: %w", err)
+	}
+
+	ts, err := node.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return false, xerrors.Errorf("get tipset: %w", err)
+	}
+
+	child, onChain, err := findChild(ctx, node, ts)
+	if err != nil {
+		return false, xerrors.Errorf("find child tipset: %w", err)
+	}
+	if !onChain {
+		// Messages in tipsets that are not on the canonical chain were never executed so use no gas
+		log.Debugw("skipping tipset that is not on the canonical chain", "height", item.Height)
+		return true, nil
+	}
+	if child == nil {
+		log.Debugw("delaying aggregation of tipset with no child", "height", item.Height)
+		return false, nil
+	}
+
+	aggs, err := p.aggregateTipSet(ctx, node, ts, child)
+	if err != nil {
+		return false, xerrors.Errorf("aggregate gas: %w", err)
+	}
+
+	log.Debugw("persisting gas aggregates", "height", int64(ts.Height()), "count", len(aggs))
+
+	if err := p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return aggs.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return false, xerrors.Errorf("persist: %w", err)
+	}
+
+	return true, nil
+}
+
+type gasAggregateKey struct {
+	code   cid.Cid
+	method abi.MethodNum
+}
+
+type gasAggregate struct {
+	count              int64
+	gasUsed            int64
+	baseFeeBurn        big.Int
+	overEstimationBurn big.Int
+	minerTip           big.Int
+}
+
+// aggregateTipSet sums the gas outputs of the unique messages in ts using the receipts from its child.
+func (p *GasAggregateProcessor) aggregateTipSet(ctx context.Context, node lens.API, ts *types.TipSet, child *types.TipSet) (derived.GasAggregateList, error) {
+	st, err := state.LoadStateTree(node.Store(), ts.ParentState())
+	if err != nil {
+		return nil, xerrors.Errorf("load parent state tree: %w", err)
+	}
+
+	rcpts, err := fetchChildReceipts(ctx, node, child)
+	if err != nil {
+		return nil, xerrors.Errorf("fetch receipts: %w", err)
+	}
+
+	// All blocks in a tipset share the same parent base fee
+	baseFee := ts.Blocks()[0].ParentBaseFee
+
+	aggs := map[gasAggregateKey]*gasAggregate{}
+	msgsSeen := map[cid.Cid]struct{}{}
+
+	for _, blk := range ts.Cids() {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("context done: %w", ctx.Err())
+		default:
+		}
+
+		blkMsgs, err := node.ChainGetBlockMessages(ctx, blk)
+		if err != nil {
+			return nil, xerrors.Errorf("get block messages: %w", err)
+		}
+
+		vmm := make([]*types.Message, 0, len(blkMsgs.Cids))
+		for _, m := range blkMsgs.BlsMessages {
+			vmm = append(vmm, m)
+		}
+		for _, m := range blkMsgs.SecpkMessages {
+			vmm = append(vmm, &m.Message)
+		}
+
+		for _, message := range vmm {
+			if _, seen := msgsSeen[message.Cid()]; seen {
+				continue
+			}
+			msgsSeen[message.Cid()] = struct{}{}
+
+			// Messages that were not executed, such as those with an invalid nonce, have no receipt
+			rcpt, ok := rcpts[message.Cid()]
+			if !ok {
+				continue
+			}
+
+			key := gasAggregateKey{code: cid.Undef, method: message.Method}
+			dstActor, err := st.GetActor(message.To)
+			if err != nil {
+				if !errors.Is(err, types.ErrActorNotFound) {
+					return nil, xerrors.Errorf("get destination actor for message %s: %w", message.Cid().String(), err)
+				}
+			} else {
+				key.code = dstActor.Code
+			}
+
+			agg, ok := aggs[key]
+			if !ok {
+				agg = &gasAggregate{
+					baseFeeBurn:        big.Zero(),
+					overEstimationBurn: big.Zero(),
+					minerTip:           big.Zero(),
+				}
+				aggs[key] = agg
+			}
+
+			outputs := node.ComputeGasOutputs(rcpt.GasUsed, message.GasLimit, baseFee, message.GasFeeCap, message.GasPremium)
+			agg.count++
+			agg.gasUsed += rcpt.GasUsed
+			agg.baseFeeBurn = big.Add(agg.baseFeeBurn, outputs.BaseFeeBurn)
+			agg.overEstimationBurn = big.Add(agg.overEstimationBurn, outputs.OverEstimationBurn)
+			agg.minerTip = big.Add(agg.minerTip, outputs.MinerTip)
+		}
+	}
+
+	out := make(derived.GasAggregateList, 0, len(aggs))
+	for key, agg := range aggs {
+		out = append(out, &derived.GasAggregate{
+			Height:             int64(ts.Height()),
+			StateRoot:          ts.ParentState().String(),
+			ActorCode:          actors.ActorNameByCode(key.code),
+			Method:             int64(key.method),
+			MethodName:         actors.MethodName(key.code, key.method),
+			MessageCount:       agg.count,
+			GasUsed:            agg.gasUsed,
+			BaseFeeBurn:        agg.baseFeeBurn.String(),
+			OverEstimationBurn: agg.overEstimationBurn.String(),
+			MinerTip:           agg.minerTip.String(),
+		})
+	}
+
+	// Keep the insert order stable
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ActorCode != out[j].ActorCode {
+			return out[i].ActorCode < out[j].ActorCode
+		}
+		return out[i].Method < out[j].Method
+	})
+
+	return out, nil
+}
@@ -0,0 +1,48 @@
+package message
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateTipSet(t *testing.T) {
+	node := newMockAPI()
+
+	market := mustIDAddress(5)
+	root := node.stateRoot(t, map[address.Address]cid.Cid{market: sa0builtin.StorageMarketActorCodeID})
+
+	bls := testMessage(100, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+	secp := testSecpMessage(101, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+	unexecuted := testSecpMessage(102, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+
+	ts := node.tipset(t, 10, root, bls, secp, unexecuted)
+	node.executed([]types.ChainMsg{bls, secp}, []*types.MessageReceipt{{GasUsed: 400}, {GasUsed: 600}})
+	child := node.tipset(t, 11, root)
+
+	aggs, err := (&GasAggregateProcessor{}).aggregateTipSet(context.Background(), node, ts, child)
+	require.NoError(t, err)
+	require.Len(t, aggs, 1)
+
+	baseFee := ts.Blocks()[0].ParentBaseFee
+	blsOut := vm.ComputeGasOutputs(400, bls.GasLimit, baseFee, bls.GasFeeCap, bls.GasPremium)
+	secpOut := vm.ComputeGasOutputs(600, secp.Message.GasLimit, baseFee, secp.Message.GasFeeCap, secp.Message.GasPremium)
+
+	// The secp message is aggregated with the bls message, the message without a receipt is not
+	agg := aggs[0]
+	assert.EqualValues(t, 10, agg.Height)
+	assert.EqualValues(t, sa0builtin.MethodsMarket.PublishStorageDeals, agg.Method)
+	assert.EqualValues(t, 2, agg.MessageCount)
+	assert.EqualValues(t, 1000, agg.GasUsed)
+	assert.Equal(t, big.Add(blsOut.BaseFeeBurn, secpOut.BaseFeeBurn).String(), agg.BaseFeeBurn)
+	assert.Equal(t, big.Add(blsOut.OverEstimationBurn, secpOut.OverEstimationBurn).String(), agg.OverEstimationBurn)
+	assert.Equal(t, big.Add(blsOut.MinerTip, secpOut.MinerTip).String(), agg.MinerTip)
+}
@@ -88,14 +88,15 @@ func (p *GasOutputsProcessor) processBatch(ctx context.Context, node lens.API) (
 	}
 
 	log.Debugw("processing batch of messages", "count", len(batch))
+	var lease *storage.Lease
 	if p.useLeases {
-		var cancel func()
-		ctx, cancel = context.WithDeadline(ctx, claimUntil)
-		defer cancel()
+		// Keep renewing the lease while the batch is being processed
+		ctx, lease = p.storage.KeepLeases(ctx, storage.GasOutputsQueue, claimUntil, p.leaseLength)
+		defer lease.Release()
 	}
 
 	for _, item := range batch {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
@@ -116,6 +117,7 @@ func (p *GasOutputsProcessor) processBatch(ctx context.Context, node lens.API) (
 		if err := p.storage.MarkGasOutputsMessagesComplete(ctx, item.Height, item.Cid, p.clock.Now(), ""); err != nil {
 			errorLog.Errorw("failed to mark message complete", "error", err.Error())
 		}
+		lease.Progress()
 	}
 
 	return false, nil
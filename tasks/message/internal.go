@@ -0,0 +1,199 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/go-pg/pg/v10"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	messagemodel "github.com/filecoin-project/sentinel-visor/model/messages"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+func NewInternalMessageProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *InternalMessageProcessor {
+	return &InternalMessageProcessor{
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// InternalMessageProcessor is a task that replays the messages in tipsets and persists the internal messages
+// found in their execution traces, including those sent by implicit messages such as cron and block rewards.
+type InternalMessageProcessor struct {
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts processing batches of tipsets until the context is done or
+// an error occurs.
+func (p *InternalMessageProcessor) Run(ctx context.Context) error {
+	node, closer, err := p.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "ChainGetTipSet", "StateCompute"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	// Loop until context is done or processing encounters a fatal error
+	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
+		return p.processBatch(ctx, node)
+	})
+}
+
+func (p *InternalMessageProcessor) processBatch(ctx context.Context, node lens.API) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/internal"))
+	ctx, span := global.Tracer("").Start(ctx, "InternalMessageProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets to work on
+	batch, err := p.storage.LeaseTipSetInternalMessages(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight)
+	if err != nil {
+		return true, err
+	}
+
+	// If we have no tipsets to work on then wait before trying again
+	if len(batch) == 0 {
+		sleepInterval := wait.Jitter(idleSleepInterval, 2)
+		log.Debugf("no tipsets to process, waiting for %s", sleepInterval)
+		time.Sleep(sleepInterval)
+		return false, nil
+	}
+
+	log.Debugw("leased batch of tipsets", "count", len(batch))
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.InternalMessageQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	for _, item := range batch {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
+
+		if err := p.processItem(ctx, node, item); err != nil {
+			// Any errors are likely to be problems using the lens, mark this tipset as failed and exit this batch
+			log.Errorw("failed to process tipset", "error", err.Error(), "height", item.Height)
+			if err := p.storage.MarkTipSetInternalMessagesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), err.Error()); err != nil {
+				log.Errorw("failed to mark tipset internal messages complete", "error", err.Error(), "height", item.Height)
+			}
+			return false, xerrors.Errorf("process item: %w", err)
+		}
+
+		if err := p.storage.MarkTipSetInternalMessagesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
+			log.Errorw("failed to mark tipset internal messages complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	return false, nil
+}
+
+func (p *InternalMessageProcessor) processItem(ctx context.Context, node lens.API, item *visor.ProcessingTipSet) error {
+	ctx, span := global.Tracer("").Start(ctx, "InternalMessageProcessor.processItem")
+	defer span.End()
+	span.SetAttributes(label.Any("height", item.Height), label.Any("tipset", item.TipSet))
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	tsk, err := item.TipSetKey()
+	if err != nil {
+		return xerrors.Errorf("get tipsetkey: %w", err)
+	}
+
+	ts, err := node.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return xerrors.Errorf("get tipset: %w", err)
+	}
+
+	// Executes the messages included in the tipset on top of its parent state, tracing each one
+	out, err := node.StateCompute(ctx, ts.Height(), nil, tsk)
+	if err != nil {
+		return xerrors.Errorf("compute state: %w", err)
+	}
+
+	var ims messagemodel.InternalMessageList
+	for _, res := range out.Trace {
+		if res.Msg == nil {
+			continue
+		}
+		implicit := res.Msg.From == builtin.SystemActorAddr
+		ims = append(ims, walkExecutionTrace(int64(ts.Height()), res.Msg.Cid().String(), implicit, res.ExecutionTrace)...)
+	}
+
+	log.Debugw("persisting internal messages", "height", int64(ts.Height()), "count", len(ims))
+
+	if err := p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return ims.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return xerrors.Errorf("persist: %w", err)
+	}
+
+	return nil
+}
+
+// walkExecutionTrace flattens an execution trace into a list of internal messages in depth first order. The
+// message at the root of the trace has a depth of zero.
+func walkExecutionTrace(height int64, parentCid string, implicit bool, root types.ExecutionTrace) messagemodel.InternalMessageList {
+	var ims messagemodel.InternalMessageList
+
+	var walk func(et types.ExecutionTrace, depth int64)
+	walk = func(et types.ExecutionTrace, depth int64) {
+		if et.Msg == nil {
+			return
+		}
+
+		im := &messagemodel.InternalMessage{
+			Height:           height,
+			ParentMessageCid: parentCid,
+			Idx:              int64(len(ims)),
+			Cid:              et.Msg.Cid().String(),
+			Depth:            depth,
+			From:             et.Msg.From.String(),
+			To:               et.Msg.To.String(),
+			Value:            et.Msg.Value.String(),
+			Method:           int64(et.Msg.Method),
+			Implicit:         implicit,
+		}
+		if et.MsgRct != nil {
+			im.ExitCode = int64(et.MsgRct.ExitCode)
+			im.GasUsed = et.MsgRct.GasUsed
+		}
+		ims = append(ims, im)
+
+		for _, sub := range et.Subcalls {
+			walk(sub, depth+1)
+		}
+	}
+	walk(root, 0)
+
+	return ims
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkExecutionTrace(t *testing.T) {
+	addr := func(id uint64) address.Address {
+		a, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+		return a
+	}
+
+	msg := func(from, to uint64, method abi.MethodNum) *types.Message {
+		return &types.Message{
+			From:   addr(from),
+			To:     addr(to),
+			Method: method,
+			Value:  abi.NewTokenAmount(int64(to)),
+		}
+	}
+
+	root := types.ExecutionTrace{
+		Msg:    msg(100, 200, 2),
+		MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok, GasUsed: 1000},
+		Subcalls: []types.ExecutionTrace{
+			{
+				Msg:    msg(200, 300, 3),
+				MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok},
+				Subcalls: []types.ExecutionTrace{
+					{
+						Msg:    msg(300, 400, 0),
+						MsgRct: &types.MessageReceipt{ExitCode: exitcode.SysErrInsufficientFunds},
+					},
+				},
+			},
+			{
+				Msg: msg(200, 500, 0),
+			},
+		},
+	}
+
+	ims := walkExecutionTrace(10, "parent", false, root)
+	require.Len(t, ims, 4)
+
+	// Messages are listed in depth first order
+	wantTo := []string{addr(200).String(), addr(300).String(), addr(400).String(), addr(500).String()}
+	wantDepth := []int64{0, 1, 2, 1}
+	for i, im := range ims {
+		assert.EqualValues(t, 10, im.Height)
+		assert.Equal(t, "parent", im.ParentMessageCid)
+		assert.EqualValues(t, i, im.Idx)
+		assert.Equal(t, wantTo[i], im.To, "to of message %d", i)
+		assert.Equal(t, wantDepth[i], im.Depth, "depth of message %d", i)
+		assert.False(t, im.Implicit)
+	}
+
+	assert.EqualValues(t, 1000, ims[0].GasUsed)
+	assert.EqualValues(t, exitcode.SysErrInsufficientFunds, ims[2].ExitCode)
+	assert.EqualValues(t, 0, ims[3].ExitCode, "missing receipt")
+	assert.Equal(t, root.Subcalls[0].Msg.Cid().String(), ims[1].Cid)
+}
@@ -0,0 +1,134 @@
+package message
+
+import (
+	"context"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/go-pg/pg/v10"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	messagemodel "github.com/filecoin-project/sentinel-visor/model/messages"
+	"github.com/filecoin-project/sentinel-visor/storage"
+)
+
+func NewMempoolWatcher(d *storage.Database, opener lens.APIOpener) *MempoolWatcher {
+	return &MempoolWatcher{
+		opener:  opener,
+		storage: d,
+		clock:   clock.New(),
+	}
+}
+
+// MempoolWatcher is a task that subscribes to the mempool of the lens and records the pending messages it sees,
+// including the replacement of a message by another with the same sender and nonce. Messages are linked to the
+// height they were included at by the MessageProcessor.
+type MempoolWatcher struct {
+	opener  lens.APIOpener
+	storage *storage.Database
+	clock   clock.Clock
+}
+
+// Run starts watching the mempool and blocks until the context is done or
+// an error occurs.
+func (w *MempoolWatcher) Run(ctx context.Context) error {
+	node, closer, err := w.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "MpoolSub"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	updates, err := node.MpoolSub(ctx)
+	if err != nil {
+		return xerrors.Errorf("mpool sub: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				log.Warn("MpoolSub channel closed, stopping MempoolWatcher")
+				return nil
+			}
+			if err := w.record(ctx, update); err != nil {
+				return xerrors.Errorf("record: %w", err)
+			}
+		}
+	}
+}
+
+func (w *MempoolWatcher) record(ctx context.Context, update api.MpoolUpdate) error {
+	if update.Message == nil {
+		return nil
+	}
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/mempool"))
+	ctx, span := global.Tracer("").Start(ctx, "MempoolWatcher.record")
+	defer span.End()
+
+	var p interface {
+		PersistWithTx(context.Context, *pg.Tx) error
+	}
+
+	switch update.Type {
+	case api.MpoolAdd:
+		mm, err := newMempoolMessage(update.Message, w.clock)
+		if err != nil {
+			return err
+		}
+		log.Debugw("message added to mempool", "cid", mm.Cid, "from", mm.From, "nonce", mm.Nonce)
+		p = mm
+	case api.MpoolRemove:
+		c := update.Message.VMMessage().Cid().String()
+		log.Debugw("message removed from mempool", "cid", c)
+		p = &messagemodel.MempoolRemoval{
+			Cid:       c,
+			RemovedAt: w.clock.Now(),
+		}
+	default:
+		return nil
+	}
+
+	if err := w.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return p.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return xerrors.Errorf("persist: %w", err)
+	}
+
+	return nil
+}
+
+func newMempoolMessage(smsg *types.SignedMessage, clk clock.Clock) (*messagemodel.MempoolMessage, error) {
+	// Messages are recorded by the cid of the unsigned message to match the cids used by the messages table
+	msg := smsg.VMMessage()
+
+	b, err := msg.Serialize()
+	if err != nil {
+		return nil, xerrors.Errorf("serialize message: %w", err)
+	}
+
+	return &messagemodel.MempoolMessage{
+		Cid:        msg.Cid().String(),
+		From:       msg.From.String(),
+		To:         msg.To.String(),
+		Value:      msg.Value.String(),
+		GasFeeCap:  msg.GasFeeCap.String(),
+		GasPremium: msg.GasPremium.String(),
+		GasLimit:   msg.GasLimit,
+		SizeBytes:  len(b),
+		Nonce:      msg.Nonce,
+		Method:     uint64(msg.Method),
+		FirstSeen:  clk.Now(),
+	}, nil
+}
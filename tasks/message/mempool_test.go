@@ -0,0 +1,51 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+func TestNewMempoolMessage(t *testing.T) {
+	from, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	to, err := address.NewIDAddress(200)
+	require.NoError(t, err)
+
+	msg := types.Message{
+		From:       from,
+		To:         to,
+		Nonce:      7,
+		Value:      abi.NewTokenAmount(10),
+		GasLimit:   1000,
+		GasFeeCap:  abi.NewTokenAmount(3),
+		GasPremium: abi.NewTokenAmount(2),
+		Method:     2,
+	}
+
+	smsg := &types.SignedMessage{
+		Message:   msg,
+		Signature: crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte{1, 2, 3}},
+	}
+
+	mm, err := newMempoolMessage(smsg, testutil.NewMockClock())
+	require.NoError(t, err)
+
+	// secp messages are recorded by the cid of the unsigned message
+	assert.Equal(t, msg.Cid().String(), mm.Cid)
+	assert.NotEqual(t, smsg.Cid().String(), mm.Cid)
+
+	assert.Equal(t, from.String(), mm.From)
+	assert.Equal(t, to.String(), mm.To)
+	assert.EqualValues(t, 7, mm.Nonce)
+	assert.EqualValues(t, 2, mm.Method)
+	assert.Equal(t, "2", mm.GasPremium)
+	assert.Equal(t, testutil.KnownTime, mm.FirstSeen)
+}
@@ -1,15 +1,12 @@
 package message
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"math"
 	"math/big"
 	"time"
 
-	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/state"
@@ -18,7 +15,6 @@ import (
 	"github.com/go-pg/pg/v10"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
-	"github.com/ipld/go-ipld-prime"
 	"github.com/raulk/clock"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
@@ -26,59 +22,45 @@ import (
 	"go.opentelemetry.io/otel/label"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/sentinel-visor/actors"
 	"github.com/filecoin-project/sentinel-visor/lens"
 	"github.com/filecoin-project/sentinel-visor/metrics"
 	messagemodel "github.com/filecoin-project/sentinel-visor/model/messages"
 	"github.com/filecoin-project/sentinel-visor/model/visor"
 	"github.com/filecoin-project/sentinel-visor/storage"
 	"github.com/filecoin-project/sentinel-visor/wait"
-	"github.com/filecoin-project/statediff"
-	"github.com/filecoin-project/statediff/codec/fcjson"
 )
 
 const (
 	idleSleepInterval = 60 * time.Second       // time to wait if the processor runs out of blocks to process
 	batchInterval     = 100 * time.Millisecond // time to wait between batches
+	childWaitInterval = 30 * time.Second       // time to wait before retrying a tipset the chain has not advanced past
 )
 
-var (
-	accountActorCodeID string
-	log                = logging.Logger("message")
-)
-
-func init() {
-	for code, actor := range statediff.LotusActorCodes {
-		if actor == statediff.AccountActorState {
-			accountActorCodeID = code
-			break
-		}
-	}
-}
+var log = logging.Logger("message")
 
-func NewMessageProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, parseMessages bool, minHeight, maxHeight int64) *MessageProcessor {
+func NewMessageProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *MessageProcessor {
 	return &MessageProcessor{
-		opener:        opener,
-		storage:       d,
-		leaseLength:   leaseLength,
-		batchSize:     batchSize,
-		parseMessages: parseMessages,
-		minHeight:     minHeight,
-		maxHeight:     maxHeight,
-		clock:         clock.New(),
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
 	}
 }
 
 // MessageProcessor is a task that processes blocks to detect messages and persists
 // their details to the database.
 type MessageProcessor struct {
-	opener        lens.APIOpener
-	storage       *storage.Database
-	leaseLength   time.Duration // length of time to lease work for
-	batchSize     int           // number of tipsets to lease in a batch
-	parseMessages bool          // if derived parsed messages should be calculated
-	minHeight     int64         // limit processing to tipsets equal to or above this height
-	maxHeight     int64         // limit processing to tipsets equal to or below this height
-	clock         clock.Clock
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
 }
 
 // Run starts processing batches of tipsets and blocks until the context is done or
@@ -90,6 +72,10 @@ func (p *MessageProcessor) Run(ctx context.Context) error {
 	}
 	defer closer()
 
+	if err := lens.RequireMethods(node, "ChainGetTipSet", "ChainGetBlockMessages", "ChainGetParentMessages", "ChainGetParentReceipts", "ChainReadObj"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
 	// TODO: restart delay when error returned
 
 	// Loop until context is done or processing encounters a fatal error
@@ -120,11 +106,12 @@ func (p *MessageProcessor) processBatch(ctx context.Context, node lens.API) (boo
 	}
 
 	log.Debugw("leased batch of tipsets", "count", len(batch))
-	ctx, cancel := context.WithDeadline(ctx, claimUntil)
-	defer cancel()
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.MessageQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
 
 	for _, item := range batch {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
@@ -144,6 +131,7 @@ func (p *MessageProcessor) processBatch(ctx context.Context, node lens.API) (boo
 		if err := p.storage.MarkTipSetMessagesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
 			log.Errorw("failed to mark tipset message complete", "error", err.Error(), "height", item.Height)
 		}
+		lease.Progress()
 	}
 
 	return false, nil
@@ -217,7 +205,7 @@ func (p *MessageProcessor) processTipSet(ctx context.Context, node lens.API, ts
 func (p *MessageProcessor) fetchMessages(ctx context.Context, node lens.API, ts *types.TipSet) (map[cid.Cid]*api.BlockMessages, error) {
 	out := make(map[cid.Cid]*api.BlockMessages)
 	for _, blk := range ts.Cids() {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return nil, xerrors.Errorf("context done: %w", ctx.Err())
@@ -236,18 +224,24 @@ func (p *MessageProcessor) extractMessageModels(ctx context.Context, node lens.A
 	result := &messagemodel.MessageTaskResult{
 		Messages:          messagemodel.Messages{},
 		BlockMessages:     messagemodel.BlockMessages{},
-		ParsedMessages:    messagemodel.ParsedMessages{},
 		MessageGasEconomy: nil,
 	}
 
 	pmsgModels := visor.ProcessingMessageList{}
 
+	// The destination actors of the messages are resolved in the state the messages are applied to
+	st, err := state.LoadStateTree(node.Store(), ts.ParentState())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("load parent state tree: %w", err)
+	}
+
 	msgsSeen := map[cid.Cid]struct{}{}
+
 	totalGasLimit := int64(0)
 	totalUniqGasLimit := int64(0)
 
 	for blk, msgs := range blkMsgs {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return nil, nil, xerrors.Errorf("context done: %w", ctx.Err())
@@ -302,54 +296,21 @@ func (p *MessageProcessor) extractMessageModels(ctx context.Context, node lens.A
 				Method:     uint64(message.Method),
 				Params:     message.Params,
 			}
-			result.Messages = append(result.Messages, msg)
 
-			msgsSeen[message.Cid()] = struct{}{}
-
-			if p.parseMessages {
-				dstAddr, err := address.NewFromString(msg.To)
-				if err != nil {
-					return nil, nil, xerrors.Errorf("parse to address failed for %s: %w", message.Cid().String(), err)
-				}
-
-				child, err := node.ChainGetTipSetByHeight(ctx, ts.Height()+1, types.NewTipSetKey())
-				if err != nil {
-					// If we aren't finalized, we fail for now, because a child tipset may occur
-					if head, headErr := node.ChainHead(ctx); headErr == nil && head.Height()-ts.Height() < build.Finality {
-						log.Warnf("Delaying derivation for message %s which is not yet finalized", message.Cid().String())
-						return nil, nil, xerrors.Errorf("Failed to load child tipset: %w", err)
-					}
-					log.Infof("Skipping derivation of message parameters for message %s with no children blocks after derivation.", message.Cid().String())
-					continue
-				}
-				if !cidsEqual(child.Parents().Cids(), ts.Cids()) {
-					// if we aren't on the main chain, we don't have an easy way to get child blocks, so skip parsing these messages for now.
-					log.Infof("Skipping derivation of message parameters for message %s not on canonical chain", message.Cid().String())
-					continue
-				}
-
-				st, err := state.LoadStateTree(node.Store(), child.ParentState())
-				if err != nil {
-					return nil, nil, xerrors.Errorf("load state tree when considering message %s: %w", message.Cid().String(), err)
+			dstActor, err := st.GetActor(message.To)
+			if err != nil {
+				if !errors.Is(err, types.ErrActorNotFound) {
+					return nil, nil, xerrors.Errorf("get destination actor for message %s: %w", message.Cid().String(), err)
 				}
+				msg.MethodName = actors.MethodName(cid.Undef, message.Method)
+			} else {
+				msg.ActorCode = actors.ActorNameByCode(dstActor.Code)
+				msg.MethodName = actors.MethodName(dstActor.Code, message.Method)
+			}
 
-				dstActorCode := accountActorCodeID
-				dstActor, err := st.GetActor(dstAddr)
-				if err != nil {
-					// implicitly if actor does not exist,
-					if !errors.Is(err, types.ErrActorNotFound) {
-						return nil, nil, xerrors.Errorf("get destination actor for message %s failed: %w", message.Cid().String(), err)
-					}
-				} else {
-					dstActorCode = dstActor.Code.String()
-				}
+			result.Messages = append(result.Messages, msg)
 
-				if pm, err := parseMsg(msg, ts, dstActorCode); err == nil {
-					result.ParsedMessages = append(result.ParsedMessages, pm)
-				} else {
-					return nil, nil, xerrors.Errorf("parse message %s failed: %w", message.Cid().String(), err)
-				}
-			}
+			msgsSeen[message.Cid()] = struct{}{}
 		}
 
 	}
@@ -360,6 +321,14 @@ func (p *MessageProcessor) extractMessageModels(ctx context.Context, node lens.A
 	baseFeeChange := new(big.Rat).SetFrac(newBaseFee.Int, ts.Blocks()[0].ParentBaseFee.Int)
 	baseFeeChangeF, _ := baseFeeChange.Float64()
 
+	result.MempoolInclusions = &messagemodel.MempoolInclusions{
+		Height:   int64(ts.Height()),
+		Messages: make([]string, 0, len(result.Messages)),
+	}
+	for _, msg := range result.Messages {
+		result.MempoolInclusions.Messages = append(result.MempoolInclusions.Messages, msg.Cid)
+	}
+
 	result.MessageGasEconomy = &messagemodel.MessageGasEconomy{
 		Height:              int64(ts.Height()),
 		StateRoot:           ts.ParentState().String(),
@@ -374,77 +343,11 @@ func (p *MessageProcessor) extractMessageModels(ctx context.Context, node lens.A
 	return result, pmsgModels, nil
 }
 
-func cidsEqual(c1, c2 []cid.Cid) bool {
-	if len(c1) != len(c2) {
-		return false
-	}
-	for i, c := range c1 {
-		if !c2[i].Equals(c) {
-			return false
-		}
-	}
-	return true
-}
-
-func parseMsg(m *messagemodel.Message, ts *types.TipSet, destCode string) (*messagemodel.ParsedMessage, error) {
-	pm := &messagemodel.ParsedMessage{
-		Cid:    m.Cid,
-		Height: int64(ts.Height()),
-		From:   m.From,
-		To:     m.To,
-		Value:  m.Value,
-	}
-
-	actor, ok := statediff.LotusActorCodes[destCode]
-	if !ok {
-		actor = statediff.LotusTypeUnknown
-	}
-	var params ipld.Node
-	var name string
-	var err error
-
-	// TODO: the following closure is in place to handle the potential for panic
-	// in ipld-prime. Can be removed once fixed upstream.
-	// tracking issue: https://github.com/ipld/go-ipld-prime/issues/97
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				err = xerrors.Errorf("recovered panic: %v", r)
-			}
-		}()
-		params, name, err = statediff.ParseParams(m.Params, int(m.Method), actor)
-	}()
-	if err != nil && actor != statediff.LotusTypeUnknown {
-		// fall back to generic cbor->json conversion.
-		actor = statediff.LotusTypeUnknown
-		params, name, err = statediff.ParseParams(m.Params, int(m.Method), actor)
-	}
-	if name == "Unknown" {
-		name = fmt.Sprintf("%s.%d", actor, m.Method)
-	}
-	pm.Method = name
-	if err != nil {
-		log.Warnf("failed to parse parameters of message %s: %v", m.Cid, err)
-		// this can occur when the message is not valid cbor
-		pm.Params = ""
-		return pm, nil
-	}
-	if params != nil {
-		buf := bytes.NewBuffer(nil)
-		if err := fcjson.Encoder(params, buf); err != nil {
-			return nil, xerrors.Errorf("json encode: %w", err)
-		}
-		pm.Params = string(bytes.ToValidUTF8(buf.Bytes(), []byte{}))
-	}
-
-	return pm, nil
-}
-
 func (p *MessageProcessor) fetchReceipts(ctx context.Context, node lens.API, ts *types.TipSet) (messagemodel.Receipts, error) {
 	out := messagemodel.Receipts{}
 
 	for _, blk := range ts.Cids() {
-		// Stop processing if we have somehow passed our own lease time
+		// Stop processing if our lease has expired
 		select {
 		case <-ctx.Done():
 			return nil, xerrors.Errorf("context done: %w", ctx.Err())
@@ -0,0 +1,407 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/go-pg/pg/v10"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/raulk/clock"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	messagemodel "github.com/filecoin-project/sentinel-visor/model/messages"
+	"github.com/filecoin-project/sentinel-visor/model/visor"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+	"github.com/filecoin-project/statediff"
+	"github.com/filecoin-project/statediff/codec/fcjson"
+)
+
+var accountActorCodeID string
+
+func init() {
+	for code, actor := range statediff.LotusActorCodes {
+		if actor == statediff.AccountActorState {
+			accountActorCodeID = code
+			break
+		}
+	}
+}
+
+func NewParsedMessageProcessor(d *storage.Database, opener lens.APIOpener, leaseLength time.Duration, batchSize int, minHeight, maxHeight int64) *ParsedMessageProcessor {
+	return &ParsedMessageProcessor{
+		opener:      opener,
+		storage:     d,
+		leaseLength: leaseLength,
+		batchSize:   batchSize,
+		minHeight:   minHeight,
+		maxHeight:   maxHeight,
+		clock:       clock.New(),
+	}
+}
+
+// ParsedMessageProcessor is a task that decodes the parameters and return values of the messages in
+// indexed tipsets and persists them as parsed messages.
+type ParsedMessageProcessor struct {
+	opener      lens.APIOpener
+	storage     *storage.Database
+	leaseLength time.Duration // length of time to lease work for
+	batchSize   int           // number of tipsets to lease in a batch
+	minHeight   int64         // limit processing to tipsets equal to or above this height
+	maxHeight   int64         // limit processing to tipsets equal to or below this height
+	clock       clock.Clock
+}
+
+// Run starts processing batches of tipsets until the context is done or
+// an error occurs.
+func (p *ParsedMessageProcessor) Run(ctx context.Context) error {
+	node, closer, err := p.opener.Open(ctx)
+	if err != nil {
+		return xerrors.Errorf("open lens: %w", err)
+	}
+	defer closer()
+
+	if err := lens.RequireMethods(node, "ChainHead", "ChainGetTipSet", "ChainGetTipSetByHeight", "ChainGetBlockMessages", "ChainGetParentMessages", "ChainGetParentReceipts", "ChainReadObj"); err != nil {
+		return xerrors.Errorf("check lens: %w", err)
+	}
+
+	// Loop until context is done or processing encounters a fatal error
+	return wait.RepeatUntil(ctx, batchInterval, func(ctx context.Context) (bool, error) {
+		return p.processBatch(ctx, node)
+	})
+}
+
+func (p *ParsedMessageProcessor) processBatch(ctx context.Context, node lens.API) (bool, error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.TaskType, "message/parsed"))
+	ctx, span := global.Tracer("").Start(ctx, "ParsedMessageProcessor.processBatch")
+	defer span.End()
+
+	claimUntil := p.clock.Now().Add(p.leaseLength)
+
+	// Lease some tipsets to work on
+	batch, err := p.storage.LeaseTipSetParsedMessages(ctx, claimUntil, p.batchSize, p.minHeight, p.maxHeight)
+	if err != nil {
+		return false, xerrors.Errorf("lease tipset parsed messages: %w", err)
+	}
+
+	// If we have no tipsets to work on then wait before trying again
+	if len(batch) == 0 {
+		sleepInterval := wait.Jitter(idleSleepInterval, 2)
+		log.Debugf("no tipsets to process, waiting for %s", sleepInterval)
+		time.Sleep(sleepInterval)
+		return false, nil
+	}
+
+	log.Debugw("leased batch of tipsets", "count", len(batch))
+	// Keep renewing the lease while the batch is being processed
+	ctx, lease := p.storage.KeepLeases(ctx, storage.ParsedMessageQueue, claimUntil, p.leaseLength)
+	defer lease.Release()
+
+	for _, item := range batch {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return false, nil // Don't propagate cancelation error so we can resume processing cleanly
+		default:
+		}
+
+		done, err := p.processItem(ctx, node, item)
+		if err != nil {
+			// Any errors are likely to be problems using the lens, mark this tipset as failed and exit this batch
+			log.Errorw("failed to process tipset", "error", err.Error(), "height", item.Height)
+			if err := p.storage.MarkTipSetParsedMessagesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), err.Error()); err != nil {
+				log.Errorw("failed to mark tipset parsed messages complete", "error", err.Error(), "height", item.Height)
+			}
+			return false, xerrors.Errorf("process item: %w", err)
+		}
+
+		if !done {
+			// Release the tipset from this lease so it is retried once the chain is likely to have advanced
+			if err := p.storage.DeferWork(ctx, storage.ParsedMessageQueue, p.clock.Now().Add(childWaitInterval), item.TipSet, item.Height); err != nil {
+				log.Errorw("failed to defer tipset parsed messages", "error", err.Error(), "height", item.Height)
+			}
+			lease.Progress()
+			continue
+		}
+
+		if err := p.storage.MarkTipSetParsedMessagesComplete(ctx, item.TipSet, item.Height, p.clock.Now(), ""); err != nil {
+			log.Errorw("failed to mark tipset parsed messages complete", "error", err.Error(), "height", item.Height)
+		}
+		lease.Progress()
+	}
+
+	return false, nil
+}
+
+// processItem parses the messages in a tipset. It returns false if the tipset cannot be processed yet because
+// the chain has not advanced past it.
+func (p *ParsedMessageProcessor) processItem(ctx context.Context, node lens.API, item *visor.ProcessingTipSet) (bool, error) {
+	ctx, span := global.Tracer("").Start(ctx, "ParsedMessageProcessor.processItem")
+	defer span.End()
+	span.SetAttributes(label.Any("height", item.Height), label.Any("tipset", item.TipSet))
+
+	stop := metrics.Timer(ctx, metrics.ProcessingDuration)
+	defer stop()
+
+	tsk, err := item.TipSetKey()
+	if err != nil {
+		return false, xerrors.Errorf("get tipsetkey: %w", err)
+	}
+
+	ts, err := node.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return false, xerrors.Errorf("get tipset: %w", err)
+	}
+
+	child, onChain, err := findChild(ctx, node, ts)
+	if err != nil {
+		return false, xerrors.Errorf("find child tipset: %w", err)
+	}
+	if onChain && child == nil {
+		log.Debugw("delaying parsing of tipset with no child", "height", item.Height)
+		return false, nil
+	}
+
+	pms, err := p.parseTipSetMessages(ctx, node, ts, child)
+	if err != nil {
+		return false, xerrors.Errorf("parse messages: %w", err)
+	}
+
+	log.Debugw("persisting parsed messages", "height", int64(ts.Height()), "count", len(pms))
+
+	if err := p.storage.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return pms.PersistWithTx(ctx, tx)
+	}); err != nil {
+		return false, xerrors.Errorf("persist: %w", err)
+	}
+
+	return true, nil
+}
+
+// parseTipSetMessages parses the unique messages included in a tipset. The destination actor of each message is
+// resolved from the tipset's parent state, falling back to the state produced by executing the tipset for actors
+// created by its messages. Return values are only decoded when child is not nil.
+func (p *ParsedMessageProcessor) parseTipSetMessages(ctx context.Context, node lens.API, ts *types.TipSet, child *types.TipSet) (messagemodel.ParsedMessages, error) {
+	parentTree, err := state.LoadStateTree(node.Store(), ts.ParentState())
+	if err != nil {
+		return nil, xerrors.Errorf("load parent state tree: %w", err)
+	}
+
+	var childTree *state.StateTree
+	var rcpts map[cid.Cid]*types.MessageReceipt
+	if child != nil {
+		childTree, err = state.LoadStateTree(node.Store(), child.ParentState())
+		if err != nil {
+			return nil, xerrors.Errorf("load child state tree: %w", err)
+		}
+
+		rcpts, err = fetchChildReceipts(ctx, node, child)
+		if err != nil {
+			return nil, xerrors.Errorf("fetch receipts: %w", err)
+		}
+	}
+
+	pms := messagemodel.ParsedMessages{}
+	msgsSeen := map[cid.Cid]struct{}{}
+
+	for _, blk := range ts.Cids() {
+		// Stop processing if our lease has expired
+		select {
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("context done: %w", ctx.Err())
+		default:
+		}
+
+		blkMsgs, err := node.ChainGetBlockMessages(ctx, blk)
+		if err != nil {
+			return nil, xerrors.Errorf("get block messages: %w", err)
+		}
+
+		vmm := make([]*types.Message, 0, len(blkMsgs.Cids))
+		for _, m := range blkMsgs.BlsMessages {
+			vmm = append(vmm, m)
+		}
+		for _, m := range blkMsgs.SecpkMessages {
+			vmm = append(vmm, &m.Message)
+		}
+
+		for _, message := range vmm {
+			if _, seen := msgsSeen[message.Cid()]; seen {
+				continue
+			}
+			msgsSeen[message.Cid()] = struct{}{}
+
+			dstActorCode, err := actorCode(message.To, parentTree, childTree)
+			if err != nil {
+				return nil, xerrors.Errorf("get destination actor for message %s failed: %w", message.Cid().String(), err)
+			}
+
+			pm, err := parseMsg(message, int64(ts.Height()), dstActorCode)
+			if err != nil {
+				return nil, xerrors.Errorf("parse message %s failed: %w", message.Cid().String(), err)
+			}
+
+			if rcpt, ok := rcpts[message.Cid()]; ok && rcpt.ExitCode.IsSuccess() {
+				ret, err := parseReturn(rcpt.Return, message.Method, dstActorCode)
+				if err != nil {
+					// this can occur when the return value is not valid cbor
+					log.Warnf("failed to parse return value of message %s: %v", message.Cid().String(), err)
+				}
+				pm.Return = ret
+			}
+
+			pms = append(pms, pm)
+		}
+	}
+
+	return pms, nil
+}
+
+// findChild finds the tipset on the canonical chain that executed the messages of ts. The returned bool is false
+// if ts is not on the canonical chain, in which case no child is returned. A nil child is returned for a tipset on
+// the canonical chain if the chain has not yet advanced past it.
+func findChild(ctx context.Context, node lens.API, ts *types.TipSet) (*types.TipSet, bool, error) {
+	head, err := node.ChainHead(ctx)
+	if err != nil {
+		return nil, false, xerrors.Errorf("get chain head: %w", err)
+	}
+
+	for h := ts.Height() + 1; h <= head.Height(); h++ {
+		next, err := node.ChainGetTipSetByHeight(ctx, h, head.Key())
+		if err != nil {
+			return nil, false, xerrors.Errorf("get tipset by height: %w", err)
+		}
+
+		// The nearest earlier tipset is returned for heights that are null rounds
+		if next.Height() < h {
+			if next.Key() != ts.Key() {
+				log.Debugw("tipset is not on the canonical chain", "height", int64(ts.Height()))
+				return nil, false, nil
+			}
+			continue
+		}
+
+		if next.Parents() != ts.Key() {
+			log.Debugw("tipset is not on the canonical chain", "height", int64(ts.Height()))
+			return nil, false, nil
+		}
+
+		return next, true, nil
+	}
+
+	// The chain has not advanced past the tipset yet
+	return nil, true, nil
+}
+
+// actorCode returns the code of the actor with the given address, looking in each state tree in turn. Addresses
+// that have no actor are assumed to be account actors since sending to them creates one.
+func actorCode(addr address.Address, trees ...*state.StateTree) (string, error) {
+	for _, st := range trees {
+		if st == nil {
+			continue
+		}
+		act, err := st.GetActor(addr)
+		if err != nil {
+			if errors.Is(err, types.ErrActorNotFound) {
+				continue
+			}
+			return "", err
+		}
+		return act.Code.String(), nil
+	}
+	return accountActorCodeID, nil
+}
+
+// fetchChildReceipts returns the receipts of the messages executed by the parent of child, keyed by the cid of the
+// unsigned message. Parent messages report the cid of the signed message for secp messages, which is not the cid of
+// the message held in blocks or stored in the messages table.
+func fetchChildReceipts(ctx context.Context, node lens.API, child *types.TipSet) (map[cid.Cid]*types.MessageReceipt, error) {
+	// All blocks in the child tipset share the same parent messages and receipts
+	blk := child.Cids()[0]
+
+	msgs, err := node.ChainGetParentMessages(ctx, blk)
+	if err != nil {
+		return nil, xerrors.Errorf("get parent messages: %w", err)
+	}
+
+	rcpts, err := node.ChainGetParentReceipts(ctx, blk)
+	if err != nil {
+		return nil, xerrors.Errorf("get parent receipts: %w", err)
+	}
+
+	if len(msgs) != len(rcpts) {
+		return nil, xerrors.Errorf("mismatching number of parent messages (%d) and receipts (%d)", len(msgs), len(rcpts))
+	}
+
+	out := make(map[cid.Cid]*types.MessageReceipt, len(msgs))
+	for i, m := range msgs {
+		out[m.Message.Cid()] = rcpts[i]
+	}
+	return out, nil
+}
+
+func parseMsg(m *types.Message, height int64, destCode string) (*messagemodel.ParsedMessage, error) {
+	pm := &messagemodel.ParsedMessage{
+		Cid:    m.Cid().String(),
+		Height: height,
+		From:   m.From.String(),
+		To:     m.To.String(),
+		Value:  m.Value.String(),
+	}
+
+	actor, ok := statediff.LotusActorCodes[destCode]
+	if !ok {
+		actor = statediff.LotusTypeUnknown
+	}
+	var params ipld.Node
+	var name string
+	var err error
+
+	// TODO: the following closure is in place to handle the potential for panic
+	// in ipld-prime. Can be removed once fixed upstream.
+	// tracking issue: https://github.com/ipld/go-ipld-prime/issues/97
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = xerrors.Errorf("recovered panic: %v", r)
+			}
+		}()
+		params, name, err = statediff.ParseParams(m.Params, int(m.Method), actor)
+	}()
+	if err != nil && actor != statediff.LotusTypeUnknown {
+		// fall back to generic cbor->json conversion.
+		actor = statediff.LotusTypeUnknown
+		params, name, err = statediff.ParseParams(m.Params, int(m.Method), actor)
+	}
+	if name == "Unknown" {
+		name = fmt.Sprintf("%s.%d", actor, m.Method)
+	}
+	pm.Method = name
+	if err != nil {
+		log.Warnf("failed to parse parameters of message %s: %v", pm.Cid, err)
+		// this can occur when the message is not valid cbor
+		pm.Params = ""
+		return pm, nil
+	}
+	if params != nil {
+		buf := bytes.NewBuffer(nil)
+		if err := fcjson.Encoder(params, buf); err != nil {
+			return nil, xerrors.Errorf("json encode: %w", err)
+		}
+		pm.Params = string(bytes.ToValidUTF8(buf.Bytes(), []byte{}))
+	}
+
+	return pm, nil
+}
@@ -0,0 +1,219 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	bstore "github.com/filecoin-project/lotus/lib/blockstore"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa0market "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/util/adt"
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/lens"
+	"github.com/filecoin-project/sentinel-visor/testutil"
+)
+
+// mockAPI serves the state, block messages and parent receipts needed to process a tipset from memory
+type mockAPI struct {
+	lens.API
+	store       adt.Store
+	blockMsgs   map[cid.Cid]*api.BlockMessages
+	parentMsgs  []api.Message
+	parentRcpts []*types.MessageReceipt
+}
+
+func newMockAPI() *mockAPI {
+	return &mockAPI{
+		store:     adt.WrapStore(context.Background(), cbornode.NewCborStore(bstore.NewTemporarySync())),
+		blockMsgs: map[cid.Cid]*api.BlockMessages{},
+	}
+}
+
+func (m *mockAPI) Store() adt.Store {
+	return m.store
+}
+
+func (m *mockAPI) ComputeGasOutputs(gasUsed, gasLimit int64, baseFee, feeCap, gasPremium abi.TokenAmount) vm.GasOutputs {
+	return vm.ComputeGasOutputs(gasUsed, gasLimit, baseFee, feeCap, gasPremium)
+}
+
+func (m *mockAPI) ChainGetBlockMessages(ctx context.Context, blk cid.Cid) (*api.BlockMessages, error) {
+	msgs, ok := m.blockMsgs[blk]
+	if !ok {
+		return nil, xerrors.Errorf("block %s not found", blk)
+	}
+	return msgs, nil
+}
+
+func (m *mockAPI) ChainGetParentMessages(ctx context.Context, blk cid.Cid) ([]api.Message, error) {
+	return m.parentMsgs, nil
+}
+
+func (m *mockAPI) ChainGetParentReceipts(ctx context.Context, blk cid.Cid) ([]*types.MessageReceipt, error) {
+	return m.parentRcpts, nil
+}
+
+// stateRoot returns the root of a version 0 state tree holding actors with the given codes
+func (m *mockAPI) stateRoot(t *testing.T, codes map[address.Address]cid.Cid) cid.Cid {
+	actors := adt.MakeEmptyMap(m.store)
+	for addr, code := range codes {
+		require.NoError(t, actors.Put(adt.AddrKey(addr), &types.Actor{Code: code, Head: testutil.RandomCid(), Balance: abi.NewTokenAmount(0)}))
+	}
+	root, err := actors.Root()
+	require.NoError(t, err)
+	return root
+}
+
+// tipset returns a tipset with a single block that includes msgs, which may be bls or signed secp messages
+func (m *mockAPI) tipset(t *testing.T, height abi.ChainEpoch, stateRoot cid.Cid, msgs ...types.ChainMsg) *types.TipSet {
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 mustIDAddress(1000),
+		Height:                height,
+		ParentStateRoot:       stateRoot,
+		ParentBaseFee:         abi.NewTokenAmount(100),
+		Messages:              testutil.RandomCid(),
+		ParentMessageReceipts: testutil.RandomCid(),
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+	}})
+	require.NoError(t, err)
+
+	bm := &api.BlockMessages{}
+	for _, msg := range msgs {
+		switch msg := msg.(type) {
+		case *types.Message:
+			bm.BlsMessages = append(bm.BlsMessages, msg)
+		case *types.SignedMessage:
+			bm.SecpkMessages = append(bm.SecpkMessages, msg)
+		}
+		bm.Cids = append(bm.Cids, msg.Cid())
+	}
+	m.blockMsgs[ts.Cids()[0]] = bm
+
+	return ts
+}
+
+// executed records msgs as the parent messages of the next tipset, reported as the node reports them
+func (m *mockAPI) executed(msgs []types.ChainMsg, rcpts []*types.MessageReceipt) {
+	for i, msg := range msgs {
+		m.parentMsgs = append(m.parentMsgs, api.Message{Cid: msg.Cid(), Message: msg.VMMessage()})
+		m.parentRcpts = append(m.parentRcpts, rcpts[i])
+	}
+}
+
+func mustIDAddress(id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func testMessage(from, to uint64, nonce uint64, method abi.MethodNum) *types.Message {
+	return &types.Message{
+		From:       mustIDAddress(from),
+		To:         mustIDAddress(to),
+		Nonce:      nonce,
+		Method:     method,
+		Value:      abi.NewTokenAmount(0),
+		GasLimit:   1000,
+		GasFeeCap:  abi.NewTokenAmount(300),
+		GasPremium: abi.NewTokenAmount(2),
+	}
+}
+
+func testSecpMessage(from, to uint64, nonce uint64, method abi.MethodNum) *types.SignedMessage {
+	return &types.SignedMessage{
+		Message:   *testMessage(from, to, nonce, method),
+		Signature: crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte("signature")},
+	}
+}
+
+func TestFetchChildReceipts(t *testing.T) {
+	node := newMockAPI()
+
+	bls := testMessage(100, 200, 0, 0)
+	secp := testSecpMessage(101, 200, 0, 0)
+	require.NotEqual(t, secp.Cid(), secp.Message.Cid())
+
+	msgs := []types.ChainMsg{bls, secp}
+	node.executed(msgs, []*types.MessageReceipt{{GasUsed: 10}, {GasUsed: 20}})
+	child := node.tipset(t, 11, node.stateRoot(t, nil))
+
+	rcpts, err := fetchChildReceipts(context.Background(), node, child)
+	require.NoError(t, err)
+
+	// Receipts are keyed by the cid of the unsigned message
+	require.Len(t, rcpts, 2)
+	assert.EqualValues(t, 10, rcpts[bls.Cid()].GasUsed)
+	assert.EqualValues(t, 20, rcpts[secp.Message.Cid()].GasUsed)
+	assert.NotContains(t, rcpts, secp.Cid())
+
+	node.parentRcpts = node.parentRcpts[:1]
+	_, err = fetchChildReceipts(context.Background(), node, child)
+	assert.Error(t, err, "mismatching receipts")
+}
+
+func TestParseTipSetMessages(t *testing.T) {
+	node := newMockAPI()
+
+	market := mustIDAddress(5)
+	root := node.stateRoot(t, map[address.Address]cid.Cid{market: sa0builtin.StorageMarketActorCodeID})
+
+	ret := func(ids ...abi.DealID) []byte {
+		buf := new(bytes.Buffer)
+		require.NoError(t, (&sa0market.PublishStorageDealsReturn{IDs: ids}).MarshalCBOR(buf))
+		return buf.Bytes()
+	}
+
+	bls := testMessage(100, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+	secp := testSecpMessage(101, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+	failed := testSecpMessage(102, 5, 0, sa0builtin.MethodsMarket.PublishStorageDeals)
+
+	msgs := []types.ChainMsg{bls, secp, failed}
+	ts := node.tipset(t, 10, root, msgs...)
+	node.executed(msgs, []*types.MessageReceipt{
+		{ExitCode: exitcode.Ok, Return: ret(1)},
+		{ExitCode: exitcode.Ok, Return: ret(2, 3)},
+		{ExitCode: exitcode.ErrIllegalArgument},
+	})
+	child := node.tipset(t, 11, root)
+
+	p := &ParsedMessageProcessor{}
+
+	t.Run("with child", func(t *testing.T) {
+		pms, err := p.parseTipSetMessages(context.Background(), node, ts, child)
+		require.NoError(t, err)
+		require.Len(t, pms, 3)
+
+		// Parsed messages are identified by the unsigned message cid
+		assert.Equal(t, bls.Cid().String(), pms[0].Cid)
+		assert.JSONEq(t, `{"IDs":[1]}`, pms[0].Return)
+		assert.Equal(t, secp.Message.Cid().String(), pms[1].Cid)
+		assert.JSONEq(t, `{"IDs":[2,3]}`, pms[1].Return)
+		assert.Equal(t, failed.Message.Cid().String(), pms[2].Cid)
+		assert.Equal(t, "", pms[2].Return)
+	})
+
+	t.Run("without child", func(t *testing.T) {
+		pms, err := p.parseTipSetMessages(context.Background(), node, ts, nil)
+		require.NoError(t, err)
+		require.Len(t, pms, 3)
+		for _, pm := range pms {
+			assert.Equal(t, "", pm.Return)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa0init "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	sa0market "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	sa0miner "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	sa0multisig "github.com/filecoin-project/specs-actors/actors/builtin/multisig"
+	sa0power "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	sa2builtin "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	sa2init "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	sa2market "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	sa2miner "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	sa2multisig "github.com/filecoin-project/specs-actors/v2/actors/builtin/multisig"
+	sa2power "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// returnDecoders holds constructors for the return types of actor methods, keyed by actor code and method number.
+var returnDecoders = map[string]map[abi.MethodNum]func() cbg.CBORUnmarshaler{
+	sa0builtin.InitActorCodeID.String(): {
+		sa0builtin.MethodsInit.Exec: func() cbg.CBORUnmarshaler { return new(sa0init.ExecReturn) },
+	},
+	sa0builtin.StoragePowerActorCodeID.String(): {
+		sa0builtin.MethodsPower.CreateMiner: func() cbg.CBORUnmarshaler { return new(sa0power.CreateMinerReturn) },
+	},
+	sa0builtin.StorageMarketActorCodeID.String(): {
+		sa0builtin.MethodsMarket.PublishStorageDeals: func() cbg.CBORUnmarshaler { return new(sa0market.PublishStorageDealsReturn) },
+	},
+	sa0builtin.StorageMinerActorCodeID.String(): {
+		sa0builtin.MethodsMiner.ControlAddresses: func() cbg.CBORUnmarshaler { return new(sa0miner.GetControlAddressesReturn) },
+	},
+	sa0builtin.MultisigActorCodeID.String(): {
+		sa0builtin.MethodsMultisig.Propose: func() cbg.CBORUnmarshaler { return new(sa0multisig.ProposeReturn) },
+		sa0builtin.MethodsMultisig.Approve: func() cbg.CBORUnmarshaler { return new(sa0multisig.ApproveReturn) },
+	},
+
+	sa2builtin.InitActorCodeID.String(): {
+		sa2builtin.MethodsInit.Exec: func() cbg.CBORUnmarshaler { return new(sa2init.ExecReturn) },
+	},
+	sa2builtin.StoragePowerActorCodeID.String(): {
+		sa2builtin.MethodsPower.CreateMiner: func() cbg.CBORUnmarshaler { return new(sa2power.CreateMinerReturn) },
+	},
+	sa2builtin.StorageMarketActorCodeID.String(): {
+		sa2builtin.MethodsMarket.PublishStorageDeals: func() cbg.CBORUnmarshaler { return new(sa2market.PublishStorageDealsReturn) },
+	},
+	sa2builtin.StorageMinerActorCodeID.String(): {
+		sa2builtin.MethodsMiner.ControlAddresses: func() cbg.CBORUnmarshaler { return new(sa2miner.GetControlAddressesReturn) },
+	},
+	sa2builtin.MultisigActorCodeID.String(): {
+		sa2builtin.MethodsMultisig.Propose: func() cbg.CBORUnmarshaler { return new(sa2multisig.ProposeReturn) },
+		sa2builtin.MethodsMultisig.Approve: func() cbg.CBORUnmarshaler { return new(sa2multisig.ApproveReturn) },
+	},
+}
+
+// parseReturn decodes the return value of a message sent to an actor with the given code into json. An empty
+// string is returned if there is no return value or the return type of the method is not known.
+func parseReturn(ret []byte, method abi.MethodNum, destCode string) (string, error) {
+	if len(ret) == 0 {
+		return "", nil
+	}
+
+	newReturn, ok := returnDecoders[destCode][method]
+	if !ok {
+		return "", nil
+	}
+
+	v := newReturn()
+	if err := v.UnmarshalCBOR(bytes.NewReader(ret)); err != nil {
+		return "", xerrors.Errorf("unmarshal return: %w", err)
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", xerrors.Errorf("json encode: %w", err)
+	}
+
+	return string(buf), nil
+}
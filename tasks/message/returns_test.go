@@ -0,0 +1,42 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	sa0builtin "github.com/filecoin-project/specs-actors/actors/builtin"
+	sa0market "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReturn(t *testing.T) {
+	marketCode := sa0builtin.StorageMarketActorCodeID.String()
+
+	t.Run("known return type", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, (&sa0market.PublishStorageDealsReturn{IDs: []abi.DealID{12, 34}}).MarshalCBOR(buf))
+
+		ret, err := parseReturn(buf.Bytes(), sa0builtin.MethodsMarket.PublishStorageDeals, marketCode)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"IDs":[12,34]}`, ret)
+	})
+
+	t.Run("unknown return type", func(t *testing.T) {
+		ret, err := parseReturn([]byte{0x80}, sa0builtin.MethodsMarket.WithdrawBalance, marketCode)
+		require.NoError(t, err)
+		assert.Equal(t, "", ret)
+	})
+
+	t.Run("empty return", func(t *testing.T) {
+		ret, err := parseReturn(nil, sa0builtin.MethodsMarket.PublishStorageDeals, marketCode)
+		require.NoError(t, err)
+		assert.Equal(t, "", ret)
+	})
+
+	t.Run("invalid cbor", func(t *testing.T) {
+		_, err := parseReturn([]byte{0xff}, sa0builtin.MethodsMarket.PublishStorageDeals, marketCode)
+		assert.Error(t, err)
+	})
+}
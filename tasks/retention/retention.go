@@ -0,0 +1,80 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/metrics"
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+var log = logging.Logger("retention")
+
+func NewRetentionManager(d *storage.Database, policies []storage.RetentionPolicy, applyRate time.Duration) *RetentionManager {
+	return &RetentionManager{
+		storage:   d,
+		policies:  policies,
+		applyRate: applyRate,
+	}
+}
+
+// RetentionManager is a task that periodically applies retention policies to tables, removing or downsampling data
+// older than each policy retains and reporting what was removed.
+type RetentionManager struct {
+	storage   *storage.Database
+	policies  []storage.RetentionPolicy
+	applyRate time.Duration // time to wait between applying all policies
+}
+
+// Run starts regularly applying retention policies until the context is done or an error occurs.
+func (m *RetentionManager) Run(ctx context.Context) error {
+	if m.applyRate == 0 || len(m.policies) == 0 {
+		return nil
+	}
+	return wait.RepeatUntil(ctx, m.applyRate, m.applyPolicies)
+}
+
+func (m *RetentionManager) applyPolicies(ctx context.Context) (bool, error) {
+	// Policies that retain only referenced rows are applied last so they see the rows removed by the other policies
+	for _, referenced := range []bool{false, true} {
+		for _, p := range m.policies {
+			if p.RetainAll() || p.Referenced != referenced {
+				continue
+			}
+			if err := m.applyPolicy(ctx, p); err != nil {
+				return true, err
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *RetentionManager) applyPolicy(ctx context.Context, p storage.RetentionPolicy) error {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.Table, p.Table))
+	ctx, span := global.Tracer("").Start(ctx, "RetentionManager.applyPolicy")
+	defer span.End()
+	span.SetAttributes(label.String("table", p.Table))
+
+	start := time.Now()
+	res, err := m.storage.ApplyRetentionPolicy(ctx, p)
+	if err != nil {
+		return xerrors.Errorf("apply retention policy %s: %w", p, err)
+	}
+
+	stats.Record(ctx, metrics.RetentionRowsDeleted.M(int64(res.RowsDeleted)), metrics.RetentionChunksDropped.M(int64(res.ChunksDropped)))
+
+	if res.RowsDeleted > 0 || res.ChunksDropped > 0 {
+		log.Infow("applied retention policy", "table", p.Table, "policy", p.String(), "before", res.Before, "chunks_dropped", res.ChunksDropped, "rows_deleted", res.RowsDeleted, "took", time.Since(start))
+	} else {
+		log.Debugw("applied retention policy", "table", p.Table, "policy", p.String(), "before", res.Before)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+var log = logging.Logger("stats")
+
+func NewLeaseReaper(d *storage.Database, reapRate time.Duration) *LeaseReaper {
+	return &LeaseReaper{
+		db:       d,
+		reapRate: reapRate,
+	}
+}
+
+// LeaseReaper is a task which periodically clears the claims of leased work that expired without being completed,
+// such as when a processor stops, so processing stats only count work that is actually claimed.
+type LeaseReaper struct {
+	db       *storage.Database
+	reapRate time.Duration
+}
+
+// Run starts regularly reaping expired leases until context is done or an error occurs
+func (r *LeaseReaper) Run(ctx context.Context) error {
+	if r.reapRate == 0 {
+		return nil
+	}
+	return wait.RepeatUntil(ctx, r.reapRate, r.reap)
+}
+
+func (r *LeaseReaper) reap(ctx context.Context) (bool, error) {
+	for _, q := range storage.WorkQueues {
+		reaped, err := r.db.ReapExpiredLeases(ctx, q)
+		if err != nil {
+			return true, xerrors.Errorf("reap: %w", err)
+		}
+		if reaped > 0 {
+			log.Infow("reaped expired leases", "queue", q.Name, "count", reaped)
+		}
+	}
+
+	return false, nil
+}
@@ -130,7 +130,7 @@ func (r *ProcessingStatsRefresher) Run(ctx context.Context) error {
 func (r *ProcessingStatsRefresher) collectStats(ctx context.Context) (bool, error) {
 	subQueries := []string{fmt.Sprintf(statsActors, actorCodeCase)}
 
-	tipsetTaskTypes := []string{"message", "statechange", "economics"}
+	tipsetTaskTypes := []string{"message", "statechange", "economics", "internal_messages", "parsed_messages", "gas_aggregates", "block_rewards", "balance_changes", "miner_sectors", "deals"}
 
 	for _, taskType := range tipsetTaskTypes {
 		subQueries = append(subQueries, fmt.Sprintf(statsTipsetsTemplate, taskType))
@@ -0,0 +1,64 @@
+package views
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+var log = logging.Logger("views")
+
+func NewChainAggregateRefresher(d *storage.Database, refreshRate time.Duration, window int64) *ChainAggregateRefresher {
+	return &ChainAggregateRefresher{
+		db:          d,
+		refreshRate: refreshRate,
+		window:      window,
+	}
+}
+
+// ChainAggregateRefresher is a task which refreshes the aggregates of chain metrics at a specific refreshRate. Only
+// the buckets containing the most recent window epochs are recomputed on each refresh.
+type ChainAggregateRefresher struct {
+	db          *storage.Database
+	refreshRate time.Duration
+	window      int64 // number of recent epochs to refresh, zero to refresh everything
+}
+
+// Run starts regularly refreshing until context is done or an error occurs
+func (r *ChainAggregateRefresher) Run(ctx context.Context) error {
+	if r.refreshRate == 0 {
+		return nil
+	}
+	return wait.RepeatUntil(ctx, r.refreshRate, r.refreshAggregates)
+}
+
+func (r *ChainAggregateRefresher) refreshAggregates(ctx context.Context) (bool, error) {
+	for _, a := range storage.ChainAggregates {
+		if err := r.refreshAggregate(ctx, a); err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func (r *ChainAggregateRefresher) refreshAggregate(ctx context.Context, a storage.ChainAggregate) error {
+	ctx, span := global.Tracer("").Start(ctx, "ChainAggregateRefresher.refreshAggregate")
+	defer span.End()
+	span.SetAttributes(label.String("aggregate", a.Name))
+
+	start := time.Now()
+	res, err := r.db.RefreshChainAggregate(ctx, a, r.window)
+	if err != nil {
+		return xerrors.Errorf("refresh aggregate %s: %w", a.Name, err)
+	}
+
+	log.Debugw("refreshed aggregate", "aggregate", a.Name, "from", res.From, "to", res.To, "full", res.Full, "took", time.Since(start))
+	return nil
+}
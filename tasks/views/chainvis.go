@@ -11,12 +11,15 @@ import (
 	"github.com/filecoin-project/sentinel-visor/wait"
 )
 
+// chainVisViews are always refreshed in full. Unlike the chain aggregates they are not grouped by height but join
+// individual blocks to their parents: a block is an orphan only while it has no child, so a new block can change rows
+// at any earlier height and there is no window of recent heights that could be refreshed on its own. The consensus
+// chain view is refreshed separately by ConsensusChainRefresher.
 var chainVisViews = []string{
 	"chain_visualizer_blocks_view",
 	"chain_visualizer_blocks_with_parents_view",
 	"chain_visualizer_chain_data_view",
 	"chain_visualizer_orphans_view",
-	"derived_consensus_chain_view",
 }
 
 func NewChainVisRefresher(d *storage.Database, refreshRate time.Duration) *ChainVisRefresher {
@@ -27,7 +30,8 @@ func NewChainVisRefresher(d *storage.Database, refreshRate time.Duration) *Chain
 }
 
 // ChainVisRefresher is a task which refreshes a set of views that support
-// chain visualization queries at a specific refreshRate
+// chain visualization queries at a specific refreshRate. Each refresh
+// recomputes the whole of every view.
 type ChainVisRefresher struct {
 	db          *storage.Database
 	refreshRate time.Duration
@@ -0,0 +1,63 @@
+package views
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sentinel-visor/storage"
+	"github.com/filecoin-project/sentinel-visor/wait"
+)
+
+// consensusChainView is walked back from the heaviest head so it is always refreshed in full, which can take longer
+// than the other chain visualization views. It has a unique index so it can be refreshed concurrently.
+const consensusChainView = "derived_consensus_chain_view"
+
+func NewConsensusChainRefresher(d *storage.Database, refreshRate time.Duration) *ConsensusChainRefresher {
+	return &ConsensusChainRefresher{
+		db:          d,
+		refreshRate: refreshRate,
+	}
+}
+
+// ConsensusChainRefresher is a task which refreshes the consensus chain view at its own refreshRate. The next refresh
+// waits for refreshRate after the previous one completes so a refresh that overruns is never started again before
+// it finishes, and queries of the view are not blocked while it is refreshed.
+type ConsensusChainRefresher struct {
+	db          *storage.Database
+	refreshRate time.Duration
+}
+
+// Run starts regularly refreshing until context is done or an error occurs
+func (r *ConsensusChainRefresher) Run(ctx context.Context) error {
+	if r.refreshRate == 0 {
+		return nil
+	}
+	return wait.RepeatUntil(ctx, r.refreshRate, r.refreshView)
+}
+
+func (r *ConsensusChainRefresher) refreshView(ctx context.Context) (bool, error) {
+	var populated bool
+	if _, err := r.db.DB.QueryOneContext(ctx, pg.Scan(&populated), `
+SELECT c.relispopulated FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = ? AND c.relname = ?`, r.db.Schema(), consensusChainView); err != nil {
+		return true, xerrors.Errorf("check %s is populated: %w", consensusChainView, err)
+	}
+
+	// A view created with no data must be populated before it can be refreshed concurrently
+	refresh := `REFRESH MATERIALIZED VIEW CONCURRENTLY ?`
+	if !populated {
+		refresh = `REFRESH MATERIALIZED VIEW ?`
+	}
+
+	start := time.Now()
+	if _, err := r.db.DB.ExecContext(ctx, refresh, pg.Ident(consensusChainView)); err != nil {
+		return true, xerrors.Errorf("refresh %s: %w", consensusChainView, err)
+	}
+	if took := time.Since(start); took > r.refreshRate {
+		log.Warnw("consensus chain view refresh took longer than the refresh rate", "took", took, "refresh_rate", r.refreshRate)
+	}
+	return false, nil
+}